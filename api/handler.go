@@ -1,42 +1,254 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"red-packet-system/model"
+	"red-packet-system/payment"
 	"red-packet-system/service"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // GrabRedPacketHandler - API handler for grabbing a red packet
-func GrabRedPacketHandler(c *gin.Context) {
-	// Parse `user_id` from query parameters
-	userID, err := strconv.Atoi(c.Query("user_id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
-		return
+func GrabRedPacketHandler(redPacketService *service.RedPacketService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Parse `user_id` from query parameters
+		userID, err := strconv.Atoi(c.Query("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+			return
+		}
+
+		// Parse `red_packet_id` from query parameters
+		redPacketID, err := strconv.Atoi(c.Query("red_packet_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid red_packet_id"})
+			return
+		}
+
+		// Call service layer to execute red packet grabbing logic
+		amount, err := redPacketService.GrabRedPacket(c.Request.Context(), uint(userID), uint(redPacketID))
+		if errors.Is(err, service.ErrGrabPending) {
+			// The share is held for the user, the credit follows once the claim is persisted
+			c.JSON(http.StatusAccepted, gin.H{
+				"message": err.Error(),
+				"amount":  amount,
+				"status":  "pending",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Return the grabbed amount
+		c.JSON(
+			http.StatusOK,
+			gin.H{
+				"message": "Red packet grabbed successfully",
+				"amount":  amount,
+			},
+		)
 	}
+}
 
-	// Parse `red_packet_id` from query parameters
-	redPacketID, err := strconv.Atoi(c.Query("red_packet_id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid red_packet_id"})
+// CreateRedPacketRequest - JSON body of the create red packet endpoint
+type CreateRedPacketRequest struct {
+	SenderID    uint    `json:"sender_id" binding:"required"`
+	TotalAmount float64 `json:"total_amount" binding:"required,gt=0"`
+	TotalCount  int     `json:"total_count" binding:"required,gt=0"`
+	GrabMode    string  `json:"grab_mode" binding:"omitempty,oneof=strict fast"`
+}
+
+// CreateRedPacketHandler - API handler for creating a red packet funded by the sender balance
+func CreateRedPacketHandler(c *gin.Context) {
+	var req CreateRedPacketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Call service layer to execute red packet grabbing logic
-	amount, err := service.GrabRedPacket(uint(userID), uint(redPacketID))
+	redPacket, err := service.CreateRedPacket(c.Request.Context(), service.CreateRedPacketRequest{
+		SenderID:    req.SenderID,
+		TotalAmount: req.TotalAmount,
+		TotalCount:  req.TotalCount,
+		GrabMode:    req.GrabMode,
+	})
+	if errors.Is(err, service.ErrInsufficientBalance) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Return the grabbed amount
 	c.JSON(
-		http.StatusOK,
+		http.StatusCreated,
 		gin.H{
-			"message": "Red packet grabbed successfully",
-			"amount":  amount,
+			"message":       "Red packet created successfully",
+			"red_packet_id": redPacket.ID,
+			"grab_mode":     redPacket.GrabMode,
 		},
 	)
 }
+
+// GetRedPacketHandler - API handler for a red packet and its claims, archived or not
+func GetRedPacketHandler(c *gin.Context) {
+	redPacketID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid red packet id"})
+		return
+	}
+
+	detail, err := service.GetRedPacket(c.Request.Context(), uint(redPacketID))
+	if errors.Is(err, service.ErrRedPacketNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, detail)
+}
+
+// ListTransactionsHandler - API handler for the red packets a user received, sent or got refunded
+// Query parameters: type (comma-separated sent,received,refund), from/to (RFC3339), cursor, limit
+func ListTransactionsHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	query := service.TransactionQuery{
+		UserID: uint(userID),
+		Cursor: c.Query("cursor"),
+	}
+	if types := c.Query("type"); types != "" {
+		query.Types = strings.Split(types, ",")
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	if from := c.Query("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected RFC3339"})
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected RFC3339"})
+			return
+		}
+	}
+
+	page, err := service.ListTransactions(c.Request.Context(), query)
+	if errors.Is(err, service.ErrInvalidCursor) || errors.Is(err, service.ErrInvalidTransactionType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// WalletRequest - JSON body of the deposit and withdrawal endpoints
+type WalletRequest struct {
+	Amount   float64 `json:"amount" binding:"required,gt=0"`
+	Provider string  `json:"provider" binding:"required"`
+}
+
+// DepositHandler - API handler starting a wallet top-up through a payment provider
+func DepositHandler(c *gin.Context) {
+	walletHandler(c, service.Deposit)
+}
+
+// WithdrawHandler - API handler starting a wallet withdrawal through a payment provider
+func WithdrawHandler(c *gin.Context) {
+	walletHandler(c, service.Withdraw)
+}
+
+// walletHandler parses a wallet request and starts the operation; the result is pending
+// until the provider callback arrives
+func walletHandler(c *gin.Context, start func(context.Context, uint, float64, string) (*model.WalletTransaction, error)) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	var req WalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	walletTx, err := start(c.Request.Context(), uint(userID), req.Amount, req.Provider)
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInsufficientBalance):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, walletResponse(walletTx))
+	}
+}
+
+// PaymentCallbackHandler - API handler receiving settlement callbacks from payment providers
+func PaymentCallbackHandler(c *gin.Context) {
+	provider, err := service.GetPaymentProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	callback, err := provider.ParseCallback(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	walletTx, err := service.HandlePaymentCallback(c.Request.Context(), provider.Name(), callback)
+	switch {
+	case errors.Is(err, service.ErrWalletTxNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWalletTxAlreadyHandled), errors.Is(err, payment.ErrInvalidCallback):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, walletResponse(walletTx))
+	}
+}
+
+// walletResponse renders a wallet transaction
+func walletResponse(walletTx *model.WalletTransaction) gin.H {
+	return gin.H{
+		"reference":          walletTx.Reference,
+		"user_id":            walletTx.UserID,
+		"type":               walletTx.Type,
+		"amount":             walletTx.Amount,
+		"status":             walletTx.Status,
+		"provider":           walletTx.Provider,
+		"provider_reference": walletTx.ProviderReference,
+		"failure_reason":     walletTx.FailureReason,
+	}
+}
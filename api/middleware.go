@@ -0,0 +1,154 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"red-packet-system/db"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// grabLimiter bounds the grabs served per second by this instance, unlimited until configured
+var grabLimiter = rate.NewLimiter(rate.Inf, 0)
+
+// SetGrabRateLimit sets the grabs per second and the burst allowed by GrabRateLimit,
+// a limit of 0 disables it. It can be called while requests are served.
+func SetGrabRateLimit(limit float64, burst int) {
+	if limit <= 0 {
+		grabLimiter.SetLimit(rate.Inf)
+		return
+	}
+	grabLimiter.SetBurst(burst)
+	grabLimiter.SetLimit(rate.Limit(limit))
+}
+
+// GrabRateLimit answers 429 to the grabs over the rate set by SetGrabRateLimit, shedding load
+// before it reaches Redis and MySQL
+func GrabRateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !grabLimiter.Allow() {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ReadYourWrites pins the request to the MySQL master when the user in the `:id` path
+// parameter (or `user_id` query parameter) wrote within the read-your-writes window
+func ReadYourWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if id == "" {
+			id = c.Query("user_id")
+		}
+		if userID, err := strconv.Atoi(id); err == nil {
+			c.Request = c.Request.WithContext(db.WithSession(c.Request.Context(), uint(userID)))
+		}
+		c.Next()
+	}
+}
+
+// RequestIDHeader carries the request ID, taken from the caller when set
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID tags the request context with the caller's X-Request-ID, or a random one,
+// so every record logged with it carries a request_id field. The ID is echoed in the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// newRequestID returns 16 random hex characters
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs every request once it is served, at error level for 5xx responses.
+// Successful requests go through the sampled logger to bound the volume under load.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		log, level := logger.Sampled(), slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			log, level = logger.GetLogger(), slog.LevelError
+		}
+		log.Log(c.Request.Context(), level, "Request served",
+			"method", c.Request.Method,
+			"route", routeOf(c),
+			"status", status,
+			"duration", time.Since(start),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// Metrics records the latency of every request by method, route template and status code
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		metrics.HTTPRequestDuration.WithLabelValues(c.Request.Method, routeOf(c), strconv.Itoa(c.Writer.Status())).Observe(metrics.Since(start))
+	}
+}
+
+// Tracing starts a server span per request, continuing the trace of the caller's traceparent header
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+routeOf(c),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", routeOf(c)),
+				attribute.String("url.path", c.Request.URL.Path),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// routeOf returns the route template of the request, "unmatched" on 404s to keep label
+// and span name cardinality bounded
+func routeOf(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return "unmatched"
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"red-packet-system/config"
+	"red-packet-system/pkg/metrics"
+)
+
+// newGrabRouter serves a grab stub behind GrabRateLimit
+func newGrabRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/grab", GrabRateLimit(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+// grabCodes sends n grabs and returns their status codes
+func grabCodes(router *gin.Engine, n int) []int {
+	codes := make([]int, n)
+	for i := range codes {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/grab", nil))
+		codes[i] = recorder.Code
+	}
+	return codes
+}
+
+// countCode counts the responses with code
+func countCode(codes []int, code int) int {
+	count := 0
+	for _, c := range codes {
+		if c == code {
+			count++
+		}
+	}
+	return count
+}
+
+func TestGrabRateLimit(t *testing.T) {
+	defer SetGrabRateLimit(0, 0)
+	router := newGrabRouter()
+
+	// The burst is served, the rest is shed with 429 and Retry-After
+	SetGrabRateLimit(0.001, 2)
+	codes := grabCodes(router, 5)
+	if countCode(codes, http.StatusOK) != 2 || countCode(codes, http.StatusTooManyRequests) != 3 {
+		t.Fatalf("expected 2 grabs served and 3 rejected, got %v", codes)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/grab", nil))
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+
+	// 0 disables the limit
+	SetGrabRateLimit(0, 0)
+	if codes := grabCodes(router, 50); countCode(codes, http.StatusOK) != 50 {
+		t.Fatalf("expected every grab served without a limit, got %v", codes)
+	}
+}
+
+func TestGrabRateLimitReload(t *testing.T) {
+	defer SetGrabRateLimit(0, 0)
+	router := newGrabRouter()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("grab_rate_limit: 0\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	// Wired as cmd/server does
+	cfg := config.LoadConfig()
+	SetGrabRateLimit(cfg.GrabRateLimit, cfg.GrabRateBurst)
+	config.Subscribe(func(old, cfg *config.Config) {
+		SetGrabRateLimit(cfg.GrabRateLimit, cfg.GrabRateBurst)
+	})
+	if codes := grabCodes(router, 20); countCode(codes, http.StatusOK) != 20 {
+		t.Fatalf("expected no limit before the reload, got %v", codes)
+	}
+
+	// The reloaded limit applies to the next requests, without a restart
+	write("grab_rate_limit: 0.001\ngrab_rate_burst: 3\n")
+	if err := config.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if codes := grabCodes(router, 10); countCode(codes, http.StatusOK) != 3 {
+		t.Fatalf("expected the burst of 3 after the reload, got %v", codes)
+	}
+
+	write("grab_rate_limit: 0\n")
+	if err := config.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if codes := grabCodes(router, 20); countCode(codes, http.StatusOK) != 20 {
+		t.Fatalf("expected the limit disabled by the reload, got %v", codes)
+	}
+}
+
+func TestMetricsLabelsRouteTemplates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Metrics())
+	router.GET("/red-packets/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	metrics.HTTPRequestDuration.Reset()
+	t.Cleanup(metrics.HTTPRequestDuration.Reset)
+
+	for _, path := range []string{"/red-packets/1", "/red-packets/2", "/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// IDs are folded into the route template, unknown paths share one label
+	if series := testutil.CollectAndCount(metrics.HTTPRequestDuration); series != 2 {
+		t.Fatalf("got %d series, want one per route", series)
+	}
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]uint64{}
+	for _, family := range families {
+		if family.GetName() != "red_packet_http_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			counts[labels["method"]+" "+labels["route"]+" "+labels["code"]] = metric.GetHistogram().GetSampleCount()
+		}
+	}
+	want := map[string]uint64{"GET /red-packets/:id 200": 2, "GET unmatched 404": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("got request counts %v, want %v", counts, want)
+	}
+}
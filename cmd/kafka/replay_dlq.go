@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"red-packet-system/kafka"
+	"red-packet-system/pkg/logger"
+)
+
+// runReplayDLQ publishes the dead-lettered messages back to their source topic once the
+// cause of their failure is fixed. Replays resume after the last replayed message.
+// Usage: kafka-worker replay-dlq [--reason failed|invalid|all]
+func runReplayDLQ(args []string) {
+	log := logger.GetLogger()
+
+	flags := flag.NewFlagSet("replay-dlq", flag.ExitOnError)
+	reason := flags.String("reason", kafka.DLQReasonFailed, "replay the messages dead-lettered for this reason, or all")
+	flags.Parse(args)
+	if *reason == "all" {
+		*reason = ""
+	}
+
+	defer func() {
+		if err := kafka.CloseProducer(); err != nil {
+			log.Error("Failed to close Kafka Producer", "error", err)
+		}
+	}()
+	replayed, err := kafka.ReplayDLQ(context.Background(), *reason)
+	if err != nil {
+		logger.Fatal("Replaying dead letters failed, run again to resume", "replayed", replayed, "error", err)
+	}
+	log.Info("Dead letters replayed", "replayed", replayed)
+}
@@ -1,49 +1,136 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"red-packet-system/config"
 	"red-packet-system/db"
 	"red-packet-system/kafka"
+	"red-packet-system/pkg/health"
 	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/pkg/tracing"
+	"red-packet-system/service"
 )
 
 func main() {
-	log := logger.GetLogger()
-	log.Println("Starting Kafka Consumer...")
-
 	// Load environment configuration
 	cfg := config.LoadConfig()
 
+	// Apply the configured log format and level
+	if err := logger.Init(cfg.LoggerOptions()); err != nil {
+		logger.Fatal("Invalid logger configuration", "error", err)
+	}
+	log := logger.GetLogger()
+
+	// Subcommands that only need Kafka
+	if len(os.Args) > 1 && os.Args[1] == "replay-dlq" {
+		runReplayDLQ(os.Args[2:])
+		return
+	}
+	log.Info("Starting Kafka Consumer")
+
 	// Initialize MySQL connection
 	if err := db.InitDB(cfg); err != nil {
-		log.Fatalf("Database connection failed: %v", err)
+		logger.Fatal("Database connection failed", "error", err)
 	}
 
 	// Ensure DB connection is properly initialized
 	if db.GetDB() == nil {
-		log.Fatal("Database connection is not initialized")
+		logger.Fatal("Database connection is not initialized")
+	}
+
+	// Refuse to run against a schema this binary was not built for
+	if err := db.CheckSchemaVersion(context.Background()); err != nil {
+		logger.Fatal("Schema check failed", "error", err)
+	}
+	if err := db.CheckLogsSharded(context.Background()); err != nil {
+		logger.Fatal("Claim log check failed", "error", err)
 	}
 
 	// Ensure database connection is closed when the worker stops
 	defer func() {
-		log.Println("Closing MySQL connection...")
+		log.Info("Closing MySQL connection")
 		if err := db.CloseDB(); err != nil {
-			log.Printf("Failed to close database: %v", err)
-		} else {
-			log.Println("MySQL connection closed")
+			log.Error("Failed to close database", "error", err)
+		}
+	}()
+
+	// Export spans of the consumed events, continuing the traces of their producers
+	shutdownTracing, err := tracing.Init(context.Background(), "red-packet-worker", cfg.TracingExporter, cfg.TracingSampleRatio)
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", "error", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error("Failed to flush spans", "error", err)
 		}
 	}()
 
+	// Route consumed events to the balance handlers
+	service.RegisterEventHandlers()
+
+	// Flush dead-lettered messages still buffered in the producer
+	defer func() {
+		if err := kafka.CloseProducer(); err != nil {
+			log.Error("Failed to close Kafka Producer", "error", err)
+		}
+	}()
+
+	// Serve Prometheus metrics, the liveness and readiness probes and the runtime log level.
+	// The worker is ready while MySQL answers and the consumer and dead-letter producer reach Kafka.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LiveHandler())
+	mux.Handle("/readyz", health.ReadyHandler(cfg.HealthCheckTimeout,
+		append(db.HealthChecks(), kafka.ConsumerHealthCheck(), kafka.ProducerHealthCheck())...))
+	mux.Handle("/debug/log-level", logger.LevelHandler())
+	httpServer := &http.Server{Addr: cfg.WorkerMetricsAddr, Handler: mux}
+	go func() {
+		log.Info("Worker HTTP server listening", "addr", cfg.WorkerMetricsAddr)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("Worker HTTP server failed", "error", err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	// Follow the log level of reloaded configurations (SIGHUP or CONFIG_FILE change)
+	config.Subscribe(func(old, cfg *config.Config) {
+		if cfg.LogLevel != old.LogLevel {
+			logger.SetLevel(cfg.LogLevel) // Validated by the reload
+		}
+	})
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go config.Watch(watchCtx, cfg.ConfigWatchInterval)
+
 	// Start Kafka consumer in a separate goroutine
-	go kafka.StartConsumer()
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		kafka.StartConsumer(consumerCtx)
+	}()
 
 	// Capture shutdown signals (CTRL+C, Docker Stop, etc.)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	sig := <-quit
-	log.Printf("Received signal: %v, shutting down Kafka Consumer...", sig)
+	log.Info("Received signal, shutting down Kafka Consumer", "signal", sig.String())
+
+	// Finish the message in progress and commit its offset before closing MySQL
+	stopConsumer()
+	<-consumerDone
 }
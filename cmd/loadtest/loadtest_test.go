@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAPI serves the create and grab endpoints: shares grabs succeed, the rest find the red packet empty
+func fakeAPI(t *testing.T, shares int64) *httptest.Server {
+	t.Helper()
+	var grabbed atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/red-packets", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"red_packet_id": 7})
+	})
+	mux.HandleFunc("/grab", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("red_packet_id") != "7" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid red_packet_id"})
+			return
+		}
+		if grabbed.Add(1) > shares {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "red packet is empty"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Red packet grabbed successfully", "amount": 0.5})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRun(t *testing.T) {
+	server := fakeAPI(t, 10)
+	opts := options{
+		Target:      server.URL,
+		Users:       100,
+		Packets:     1,
+		Shares:      10,
+		Amount:      5,
+		GrabMode:    "strict",
+		SenderID:    1,
+		Concurrency: 4,
+		Duration:    200 * time.Millisecond,
+		Timeout:     time.Second,
+	}
+	client := newClient(opts)
+
+	redPacketIDs, err := createRedPackets(context.Background(), client, opts)
+	if err != nil || len(redPacketIDs) != 1 || redPacketIDs[0] != 7 {
+		t.Fatalf("createRedPackets = %v, %v, want [7]", redPacketIDs, err)
+	}
+
+	report := run(context.Background(), client, opts, redPacketIDs)
+	if report.Requests <= 10 {
+		t.Fatalf("only %d requests sent in %s", report.Requests, opts.Duration)
+	}
+	if report.Grabbed != 10 || report.GrabbedTotal != 5 {
+		t.Fatalf("grabbed %d for %.2f, want 10 for 5.00", report.Grabbed, report.GrabbedTotal)
+	}
+	if got, want := report.Errors["500 red packet is empty"], report.Requests-10; got != want || len(report.Errors) != 1 {
+		t.Fatalf("errors = %v, want %d empty red packet errors", report.Errors, want)
+	}
+	if report.Codes["200"] != 10 || report.Latency.Max <= 0 || report.Latency.P50 > report.Latency.P99 {
+		t.Fatalf("unexpected codes %v or latency %+v", report.Codes, report.Latency)
+	}
+
+	var out strings.Builder
+	report.writeText(&out, report)
+	if text := out.String(); !strings.Contains(text, "500 red packet is empty") || !strings.Contains(text, "+0.0%") {
+		t.Fatalf("text report lacks the error breakdown or baseline change:\n%s", text)
+	}
+	t.Log("\n" + out.String())
+}
+
+func TestRunPaced(t *testing.T) {
+	server := fakeAPI(t, 1000)
+	opts := options{Target: server.URL, Users: 10, Concurrency: 2, Rate: 100, Duration: 500 * time.Millisecond, Timeout: time.Second}
+
+	report := run(context.Background(), newClient(opts), opts, []uint{7})
+	// 50 grabs are due in 500ms, leave room for the scheduler
+	if report.Requests+int(report.Missed) < 35 || report.Requests+int(report.Missed) > 55 {
+		t.Fatalf("sent %d and missed %d grabs at 100/s in 500ms, want about 50", report.Requests, report.Missed)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	latencies := make([]time.Duration, 1000)
+	for i := range latencies {
+		latencies[len(latencies)-1-i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	got := summarize(latencies)
+	want := Latency{Mean: 500.5, P50: 500, P90: 900, P95: 950, P99: 990, P999: 999, Max: 1000}
+	if got != want {
+		t.Fatalf("summarize = %+v, want %+v", got, want)
+	}
+	if (summarize(nil) != Latency{}) {
+		t.Fatal("summarize of no latency is not zero")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"red-packet-system/pkg/logger"
+)
+
+// loadtest creates red packets through the API, then drives grab traffic against it and reports
+// throughput, latency percentiles and errors by code.
+// Usage: go run ./cmd/loadtest [flags]
+func main() {
+	logger.Init(logger.Options{Output: os.Stderr}) // Keep stdout for the report
+	log := logger.GetLogger()
+
+	var opts options
+	flag.StringVar(&opts.Target, "url", "http://localhost:8080", "base URL of the API")
+	flag.IntVar(&opts.Users, "users", 10000, "grabbing users, IDs 1..users")
+	flag.IntVar(&opts.Packets, "packets", 10, "red packets to create")
+	flag.IntVar(&opts.Shares, "shares", 1000, "shares per created red packet")
+	flag.Float64Var(&opts.Amount, "amount", 1000, "amount per created red packet")
+	flag.StringVar(&opts.GrabMode, "mode", "strict", "grab mode of the created red packets: strict or fast")
+	sender := flag.Uint("sender", 1, "user funding the created red packets (needs the balance)")
+	packetIDs := flag.String("packet-ids", "", "comma-separated existing red packets to grab, skips creation")
+	flag.IntVar(&opts.Rate, "rate", 0, "grabs per second, 0 sends as fast as the workers allow")
+	flag.IntVar(&opts.Concurrency, "concurrency", 100, "requests in flight at most")
+	flag.DurationVar(&opts.Duration, "duration", 30*time.Second, "length of the run")
+	flag.Float64Var(&opts.Skew, "skew", 0, "Zipf exponent (> 1) concentrating grabs on the first red packets, 0 for uniform")
+	flag.DurationVar(&opts.Timeout, "timeout", 5*time.Second, "request timeout")
+	flag.Int64Var(&opts.Seed, "seed", time.Now().UnixNano(), "seed of the user and red packet picks")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	baselinePath := flag.String("baseline", "", "JSON report of a previous run to compare with")
+	flag.Parse()
+
+	opts.SenderID = uint(*sender)
+	opts.Target = strings.TrimRight(opts.Target, "/")
+	if opts.Users <= 0 || opts.Concurrency <= 0 || opts.Duration <= 0 || opts.Rate < 0 {
+		logger.Fatal("users, concurrency and duration must be positive, rate must not be negative")
+	}
+	if opts.Skew != 0 && opts.Skew <= 1 {
+		logger.Fatal("skew must be greater than 1 (or 0 for uniform)")
+	}
+	for _, field := range strings.Split(*packetIDs, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			logger.Fatal("Invalid red packet id", "id", field)
+		}
+		opts.PacketIDs = append(opts.PacketIDs, uint(id))
+	}
+
+	var baseline *Report
+	if *baselinePath != "" {
+		var err error
+		if baseline, err = loadReport(*baselinePath); err != nil {
+			logger.Fatal("Failed to read baseline", "error", err)
+		}
+	}
+
+	// Stop early on CTRL+C, still reporting what was measured
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := newClient(opts)
+	redPacketIDs := opts.PacketIDs
+	if len(redPacketIDs) == 0 {
+		if opts.Packets <= 0 {
+			logger.Fatal("packets must be positive")
+		}
+		var err error
+		if redPacketIDs, err = createRedPackets(ctx, client, opts); err != nil {
+			logger.Fatal("Failed to create red packets", "error", err)
+		}
+		log.Info("Created red packets", "count", len(redPacketIDs), "shares", opts.Shares, "ids", redPacketIDs)
+	}
+
+	log.Info("Grabbing", "duration", opts.Duration, "target", opts.Target, "concurrency", opts.Concurrency, "rate", opts.Rate)
+	report := run(ctx, client, opts, redPacketIDs)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+	report.writeText(os.Stdout, baseline)
+}
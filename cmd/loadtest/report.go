@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Latency summarizes request latencies in milliseconds
+type Latency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// Report is the outcome of a run, printed as text or JSON
+type Report struct {
+	StartedAt    time.Time      `json:"started_at"`
+	Options      options        `json:"options"`
+	RedPacketIDs []uint         `json:"red_packet_ids"`
+	Elapsed      float64        `json:"elapsed_seconds"`
+	Requests     int            `json:"requests"`
+	Grabbed      int            `json:"grabbed"`        // 200 and 202 (pending) responses
+	GrabbedTotal float64        `json:"grabbed_amount"` // Sum of the grabbed amounts
+	Missed       int64          `json:"missed"`         // Paced grabs not sent because every worker was busy
+	Throughput   float64        `json:"throughput_rps"`
+	Latency      Latency        `json:"latency"`
+	Codes        map[string]int `json:"codes"`  // Responses by HTTP status code, "timeout" or "transport"
+	Errors       map[string]int `json:"errors"` // Failures by "<code> <error message>"
+}
+
+// collector merges the results of the workers
+type collector struct {
+	mu      sync.Mutex
+	results []result
+}
+
+func newCollector() *collector {
+	return &collector{}
+}
+
+func (c *collector) add(results []result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, results...)
+}
+
+func (c *collector) report(opts options, redPacketIDs []uint, elapsed time.Duration, missed int64) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &Report{
+		StartedAt:    time.Now().Add(-elapsed).UTC(),
+		Options:      opts,
+		RedPacketIDs: redPacketIDs,
+		Elapsed:      elapsed.Seconds(),
+		Requests:     len(c.results),
+		Missed:       missed,
+		Codes:        map[string]int{},
+		Errors:       map[string]int{},
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Requests) / elapsed.Seconds()
+	}
+
+	latencies := make([]time.Duration, 0, len(c.results))
+	for _, res := range c.results {
+		r.Codes[res.code]++
+		// 202: fast mode claim held, pending confirmation of its event
+		if res.code == strconv.Itoa(http.StatusOK) || res.code == strconv.Itoa(http.StatusAccepted) {
+			r.Grabbed++
+			r.GrabbedTotal += res.amount
+		} else {
+			key := res.code
+			if res.message != "" {
+				key += " " + res.message
+			}
+			r.Errors[key]++
+		}
+		if res.code != "transport" {
+			latencies = append(latencies, res.latency)
+		}
+	}
+	r.GrabbedTotal = math.Round(r.GrabbedTotal*100) / 100
+	r.Latency = summarize(latencies)
+	return r
+}
+
+// summarize computes the latency percentiles (nearest rank)
+func summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var sum time.Duration
+	for _, latency := range latencies {
+		sum += latency
+	}
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p/100*float64(len(latencies))-1e-9)) - 1 // Tolerate 99.9/100 rounding up
+		if rank < 0 {
+			rank = 0
+		}
+		return milliseconds(latencies[rank])
+	}
+	return Latency{
+		Mean: milliseconds(sum / time.Duration(len(latencies))),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P95:  percentile(95),
+		P99:  percentile(99),
+		P999: percentile(99.9),
+		Max:  milliseconds(latencies[len(latencies)-1]),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*1000) / 1000
+}
+
+// loadReport reads a JSON report written by a previous run
+func loadReport(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r Report
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &r, nil
+}
+
+// writeText prints the report, with the change against baseline when given
+func (r *Report) writeText(w io.Writer, baseline *Report) {
+	opts := r.Options
+	fmt.Fprintf(w, "target=%s packets=%d users=%d concurrency=%d rate=%d duration=%s skew=%g\n",
+		opts.Target, len(r.RedPacketIDs), opts.Users, opts.Concurrency, opts.Rate, opts.Duration, opts.Skew)
+	fmt.Fprintf(w, "%d requests in %.1fs, %d grabbed (%.2f in total)", r.Requests, r.Elapsed, r.Grabbed, r.GrabbedTotal)
+	if r.Missed > 0 {
+		fmt.Fprintf(w, ", %d paced grabs missed (workers saturated)", r.Missed)
+	}
+	fmt.Fprintln(w)
+
+	row := func(name string, value float64, unit string, before float64) {
+		fmt.Fprintf(w, "  %-11s %12.3f %-5s", name, value, unit)
+		if baseline != nil && before != 0 {
+			fmt.Fprintf(w, " %+7.1f%%", (value-before)/before*100)
+		}
+		fmt.Fprintln(w)
+	}
+	var b Report
+	if baseline != nil {
+		b = *baseline
+		fmt.Fprintf(w, "\nchange against the baseline run of %s\n", baseline.StartedAt.Format(time.RFC3339))
+	}
+	row("throughput", r.Throughput, "req/s", b.Throughput)
+	row("mean", r.Latency.Mean, "ms", b.Latency.Mean)
+	row("p50", r.Latency.P50, "ms", b.Latency.P50)
+	row("p90", r.Latency.P90, "ms", b.Latency.P90)
+	row("p95", r.Latency.P95, "ms", b.Latency.P95)
+	row("p99", r.Latency.P99, "ms", b.Latency.P99)
+	row("p99.9", r.Latency.P999, "ms", b.Latency.P999)
+	row("max", r.Latency.Max, "ms", b.Latency.Max)
+
+	fmt.Fprintln(w, "\nresponses by code")
+	for _, code := range sortedKeys(r.Codes) {
+		fmt.Fprintf(w, "  %-10s %8d\n", code, r.Codes[code])
+	}
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w, "\nerrors")
+		for _, key := range sortedKeys(r.Errors) {
+			fmt.Fprintf(w, "  %8d  %s\n", r.Errors[key], key)
+		}
+	}
+}
+
+// sortedKeys returns the keys by decreasing count
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
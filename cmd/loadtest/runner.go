@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// options configures a load test run
+type options struct {
+	Target      string        `json:"target"`
+	Users       int           `json:"users"`       // Grabbing users, picked uniformly
+	Packets     int           `json:"packets"`     // Red packets created before the run
+	Shares      int           `json:"shares"`      // Shares per created red packet
+	Amount      float64       `json:"amount"`      // Amount per created red packet
+	GrabMode    string        `json:"grab_mode"`   // Grab mode of the created red packets
+	SenderID    uint          `json:"sender_id"`   // Funds the created red packets
+	PacketIDs   []uint        `json:"packet_ids"`  // Existing red packets, skips creation when set
+	Rate        int           `json:"rate"`        // Grabs per second, 0 for as fast as the workers go
+	Concurrency int           `json:"concurrency"` // Requests in flight at most
+	Duration    time.Duration `json:"duration_ns"`
+	Skew        float64       `json:"skew"` // Zipf exponent of the red packet picks (> 1), 0 for uniform
+	Timeout     time.Duration `json:"timeout_ns"`
+	Seed        int64         `json:"seed"`
+}
+
+// result is the outcome of one grab request
+type result struct {
+	latency time.Duration
+	code    string // HTTP status code, or "timeout" / "transport" when no response arrived
+	message string // Error message returned by the API
+	amount  float64
+}
+
+// apiResponse is the JSON body of the grab and create endpoints
+type apiResponse struct {
+	Error       string  `json:"error"`
+	Amount      float64 `json:"amount"`
+	RedPacketID uint    `json:"red_packet_id"`
+}
+
+// newClient returns an HTTP client keeping a connection per worker alive
+func newClient(opts options) *http.Client {
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        opts.Concurrency,
+			MaxIdleConnsPerHost: opts.Concurrency,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// createRedPackets creates the red packets of the run through POST /red-packets
+func createRedPackets(ctx context.Context, client *http.Client, opts options) ([]uint, error) {
+	redPacketIDs := make([]uint, 0, opts.Packets)
+	for i := 0; i < opts.Packets; i++ {
+		body, _ := json.Marshal(map[string]interface{}{
+			"sender_id":    opts.SenderID,
+			"total_amount": opts.Amount,
+			"total_count":  opts.Shares,
+			"grab_mode":    opts.GrabMode,
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.Target+"/red-packets", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var created apiResponse
+		decodeErr := json.NewDecoder(resp.Body).Decode(&created)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			return nil, fmt.Errorf("create red packet: %s: %s", resp.Status, created.Error)
+		}
+		if decodeErr != nil {
+			return nil, fmt.Errorf("create red packet: %v", decodeErr)
+		}
+		redPacketIDs = append(redPacketIDs, created.RedPacketID)
+	}
+	return redPacketIDs, nil
+}
+
+// grab sends one GET /grab request
+func grab(ctx context.Context, client *http.Client, target string, userID, redPacketID uint) result {
+	query := url.Values{}
+	query.Set("user_id", strconv.FormatUint(uint64(userID), 10))
+	query.Set("red_packet_id", strconv.FormatUint(uint64(redPacketID), 10))
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target+"/grab?"+query.Encode(), nil)
+	if err != nil {
+		return result{code: "transport", message: err.Error()}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		r := result{latency: time.Since(start), code: "transport", message: err.Error()}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			r.code, r.message = "timeout", ""
+		}
+		return r
+	}
+	defer resp.Body.Close()
+
+	var body apiResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+	io.Copy(io.Discard, resp.Body) // Drain so the connection is reused
+	r := result{latency: time.Since(start), code: strconv.Itoa(resp.StatusCode), message: body.Error, amount: body.Amount}
+	if decodeErr != nil && r.message == "" && resp.StatusCode != http.StatusOK {
+		r.message = "invalid response body"
+	}
+	return r
+}
+
+// picker chooses the red packet of each grab, uniformly or with a Zipf hot-key skew
+type picker struct {
+	rng          *rand.Rand
+	zipf         *rand.Zipf
+	redPacketIDs []uint
+}
+
+func newPicker(seed int64, skew float64, redPacketIDs []uint) *picker {
+	p := &picker{rng: rand.New(rand.NewSource(seed)), redPacketIDs: redPacketIDs}
+	if skew > 1 {
+		p.zipf = rand.NewZipf(p.rng, skew, 1, uint64(len(redPacketIDs)-1))
+	}
+	return p
+}
+
+func (p *picker) next() uint {
+	if p.zipf != nil {
+		return p.redPacketIDs[p.zipf.Uint64()]
+	}
+	return p.redPacketIDs[p.rng.Intn(len(p.redPacketIDs))]
+}
+
+// run drives grab traffic until the duration elapses or ctx is cancelled.
+// With a rate, grabs are paced open-loop: a tick that finds every worker busy is counted as missed.
+func run(ctx context.Context, client *http.Client, opts options, redPacketIDs []uint) *Report {
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	tokens := make(chan struct{}, opts.Concurrency)
+	var missed atomic.Int64
+	if opts.Rate > 0 {
+		go pace(ctx, opts.Rate, tokens, &missed)
+	}
+
+	stats := newCollector()
+	var wg sync.WaitGroup
+	start := time.Now()
+	for worker := 0; worker < opts.Concurrency; worker++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			p := newPicker(seed, opts.Skew, redPacketIDs)
+			var local []result
+			defer func() { stats.add(local) }()
+
+			for {
+				if opts.Rate > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				userID := uint(1 + p.rng.Intn(opts.Users))
+				r := grab(ctx, client, opts.Target, userID, p.next())
+				if ctx.Err() != nil && (r.code == "transport" || r.code == "timeout") {
+					return // Cut short by the end of the run, not a failure
+				}
+				local = append(local, r)
+			}
+		}(opts.Seed + int64(worker))
+	}
+	wg.Wait()
+
+	return stats.report(opts, redPacketIDs, time.Since(start), missed.Load())
+}
+
+// pace sends rate tokens per second until ctx is done, spreading them over 1ms ticks
+func pace(ctx context.Context, rate int, tokens chan<- struct{}, missed *atomic.Int64) {
+	const tick = time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	start := time.Now()
+	sent := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			due := int(now.Sub(start).Seconds() * float64(rate))
+			for ; sent < due; sent++ {
+				select {
+				case tokens <- struct{}{}:
+				default:
+					missed.Add(1)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"red-packet-system/config"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/service"
+)
+
+// runArchive moves finished red packets past the retention window to the archive tables once.
+// Usage: server-api archive [--retention 720h]
+// The run resumes from the last checkpoint, so it can be interrupted and restarted.
+func runArchive(args []string) {
+	log := logger.GetLogger()
+
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	retention := flags.Duration("retention", config.LoadConfig().ArchiveRetention, "archive red packets created before now minus retention")
+	flags.Parse(args)
+
+	archived, err := service.ArchiveRedPackets(context.Background(), *retention)
+	if err != nil {
+		logger.Fatal("Archiving failed", "archived", archived, "error", err)
+	}
+	log.Info("Archived red packets", "archived", archived)
+}
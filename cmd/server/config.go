@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"red-packet-system/config"
+	"red-packet-system/pkg/logger"
+)
+
+// runConfig inspects the effective configuration: defaults, config file and environment merged.
+// Usage: server-api config print [--format yaml|toml]
+// Secrets are redacted. Exits with status 1 and the list of problems if the configuration is invalid.
+func runConfig(args []string) {
+	// Keep stdout to the printed configuration
+	logger.Init(logger.Options{Output: os.Stderr})
+
+	if len(args) == 0 || args[0] != "print" {
+		logger.Fatal("Usage: config print [--format yaml|toml]")
+	}
+
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	format := flags.String("format", config.FormatYAML, "output format: yaml or toml")
+	flags.Parse(args[1:])
+
+	cfg, err := config.Load()
+	if cfg != nil {
+		if writeErr := cfg.Write(os.Stdout, *format); writeErr != nil {
+			logger.Fatal("Failed to print the configuration", "error", writeErr)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+
+	"red-packet-system/pkg/logger"
+	"red-packet-system/service"
+)
+
+// runLedger runs ledger maintenance tasks.
+// Usage:
+//
+//	server-api ledger backfill            # post opening entries for balances that predate the ledger
+//	server-api ledger verify [--repair]   # compare User.Balance with wallet postings
+//
+// verify prints mismatches as JSON lines and exits with status 1 if any remain unrepaired.
+func runLedger(args []string) {
+	if len(args) == 0 {
+		logger.Fatal("Usage: ledger backfill | ledger verify [--repair]")
+	}
+
+	switch args[0] {
+	case "backfill":
+		if err := service.BackfillLedger(context.Background()); err != nil {
+			logger.Fatal("Ledger backfill failed", "error", err)
+		}
+	case "verify":
+		flags := flag.NewFlagSet("ledger verify", flag.ExitOnError)
+		repair := flags.Bool("repair", false, "overwrite User.Balance with the ledger balance")
+		flags.Parse(args[1:])
+
+		mismatches, err := service.VerifyBalances(context.Background(), *repair)
+
+		unresolved := 0
+		encoder := json.NewEncoder(os.Stdout)
+		for _, m := range mismatches {
+			encoder.Encode(m)
+			if !m.Repaired {
+				unresolved++
+			}
+		}
+
+		if err != nil {
+			logger.Fatal("Ledger verification failed", "error", err)
+		}
+		logger.GetLogger().Info("Ledger verification completed", "mismatches", len(mismatches), "unresolved", unresolved)
+		if unresolved > 0 {
+			os.Exit(1)
+		}
+	default:
+		logger.Fatal("Unknown ledger command", "command", args[0])
+	}
+}
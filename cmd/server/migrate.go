@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"red-packet-system/db"
+	"red-packet-system/pkg/logger"
+)
+
+const migrateUsage = "usage: server-api migrate up | down [steps] | to <version> | status | force <version>"
+
+// runMigrate applies the embedded schema migrations.
+// Usage: server-api migrate up | down [steps] | to <version> | status | force <version>
+func runMigrate(args []string) {
+	ctx := context.Background()
+
+	if len(args) == 0 {
+		logger.Fatal(migrateUsage)
+	}
+
+	var err error
+	switch args[0] {
+	case "up":
+		err = db.MigrateUp(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				logger.Fatal("Invalid number of steps", "steps", args[1])
+			}
+		}
+		err = db.MigrateDown(ctx, steps)
+	case "to":
+		err = db.MigrateTo(ctx, parseVersion(args))
+	case "force":
+		err = db.ForceVersion(ctx, parseVersion(args))
+	case "status":
+		err = printMigrationStatus(ctx)
+	default:
+		logger.Fatal(migrateUsage)
+	}
+
+	if err != nil {
+		logger.Fatal("Migration failed", "error", err)
+	}
+}
+
+// parseVersion reads the version argument of `to` and `force`
+func parseVersion(args []string) uint {
+	if len(args) < 2 {
+		logger.Fatal(migrateUsage)
+	}
+	version, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		logger.Fatal("Invalid migration version", "version", args[1])
+	}
+	return uint(version)
+}
+
+// printMigrationStatus prints the current version and the pending migrations
+func printMigrationStatus(ctx context.Context) error {
+	status, err := db.GetMigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "current: %d (latest %d)\n", status.Current, status.Latest)
+	if status.Dirty {
+		fmt.Fprintln(os.Stdout, "dirty: true")
+	}
+	for _, m := range status.Pending {
+		fmt.Fprintf(os.Stdout, "pending: %06d_%s\n", m.Version, m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+
+	"red-packet-system/pkg/logger"
+	"red-packet-system/service"
+)
+
+// runReconcile compares Redis and MySQL stock once and prints discrepancies as JSON lines.
+// Usage: server-api reconcile [--repair]
+// Exits with status 1 if any discrepancy remains unrepaired.
+func runReconcile(args []string) {
+	log := logger.GetLogger()
+
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "repair discrepancies that can be fixed safely")
+	flags.Parse(args)
+
+	discrepancies, err := service.ReconcileStock(context.Background(), *repair)
+
+	unresolved := 0
+	encoder := json.NewEncoder(os.Stdout)
+	for _, d := range discrepancies {
+		encoder.Encode(d)
+		if !d.Repaired {
+			unresolved++
+		}
+	}
+
+	if err != nil {
+		logger.Fatal("Stock reconciliation failed", "error", err)
+	}
+	log.Info("Stock reconciliation completed", "discrepancies", len(discrepancies), "unresolved", unresolved)
+	if unresolved > 0 {
+		os.Exit(1)
+	}
+}
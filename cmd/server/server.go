@@ -10,50 +10,164 @@ import (
 	"syscall"
 	"time"
 
+	"red-packet-system/api"
 	"red-packet-system/config"
 	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/payment"
 	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/tracing"
 	"red-packet-system/redisclient"
 	"red-packet-system/routes"
+	"red-packet-system/service"
 )
 
 func main() {
-	log := logger.GetLogger()
+	// Print the configuration without connecting to anything
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		runConfig(os.Args[2:])
+		return
+	}
 
-	// Load configuration from environment variables
+	// Load configuration from the config file and environment variables
 	cfg := config.LoadConfig()
 
+	// Apply the configured log format and level
+	if err := logger.Init(cfg.LoggerOptions()); err != nil {
+		logger.Fatal("Invalid logger configuration", "error", err)
+	}
+	log := logger.GetLogger()
+
 	// Initialize MySQL connection
 	if err := db.InitDB(cfg); err != nil {
-		log.Fatalf("Database connection failed: %v", err)
+		logger.Fatal("Database connection failed", "error", err)
 	}
-	fmt.Println("Database connected successfully")
+	log.Info("Database connected successfully")
 
 	// Ensure database connection is closed before shutting down
 	defer func() {
 		if db.GetDB() != nil {
-			log.Println("Closing MySQL connection...")
+			log.Info("Closing MySQL connection")
 			if err := db.CloseDB(); err != nil {
-				log.Printf("Failed to close database: %v", err)
-			} else {
-				log.Println("MySQL connection closed")
+				log.Error("Failed to close database", "error", err)
 			}
 		}
 	}()
 
+	// Schema migrations only need MySQL
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	// Refuse to run against a schema this binary was not built for
+	if err := db.CheckSchemaVersion(context.Background()); err != nil {
+		logger.Fatal("Schema check failed", "error", err)
+	}
+
 	// Initialize Redis connection
 	if err := redisclient.InitRedis(cfg); err != nil {
-		log.Printf("[WARN] Redis connection failed (initial attempt): %v", err)
+		log.Warn("Redis connection failed (initial attempt)", "error", err)
 	}
 
-	// If `--seed` flag is provided, run database seeding and exit
-	if len(os.Args) > 1 && os.Args[1] == "--seed" {
-		db.RunSeeds()
-		return
+	// Claim logs are only read from the shards, every legacy row must have been copied
+	if len(os.Args) < 2 || os.Args[1] != "shard-logs" {
+		if err := db.CheckLogsSharded(context.Background()); err != nil {
+			logger.Fatal("Claim log check failed", "error", err)
+		}
+	}
+
+	// Command-line tasks run against the initialized connections and exit
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "--seed":
+			db.RunSeeds()
+			if err := service.BackfillLedger(context.Background()); err != nil {
+				logger.Fatal("Ledger backfill failed", "error", err)
+			}
+			return
+		case "ledger":
+			runLedger(os.Args[2:])
+			return
+		case "reconcile":
+			runReconcile(os.Args[2:])
+			return
+		case "archive":
+			runArchive(os.Args[2:])
+			return
+		case "shard-logs":
+			runShardLogs(os.Args[2:])
+			return
+		}
+	}
+
+	// Export spans of the API requests and the jobs they trigger
+	shutdownTracing, err := tracing.Init(context.Background(), "red-packet-api", cfg.TracingExporter, cfg.TracingSampleRatio)
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", "error", err)
+	}
+
+	// Background jobs run until jobsCtx is cancelled on shutdown
+	// Start the outbox relay publishing committed grab events to Kafka
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		kafka.StartOutboxRelay(jobsCtx, cfg.OutboxMaxAttempts, cfg.OutboxRetention)
+	}()
+
+	// Periodically reconcile Redis stock with MySQL
+	if cfg.ReconcileInterval > 0 {
+		go service.StartReconciler(jobsCtx, cfg.ReconcileInterval, cfg.ReconcileRepair)
 	}
 
+	// Eject replicas failing their health check from the read rotation
+	if cfg.ReplicaHealthCheckInterval > 0 {
+		go db.StartReplicaHealthCheck(jobsCtx, cfg.ReplicaHealthCheckInterval)
+	}
+
+	// Take lagging replicas out of the read rotation, falling back to the master
+	if cfg.ReplicaLagCheckInterval > 0 {
+		go db.StartReplicaLagMonitor(jobsCtx, cfg.ReplicaLagCheckInterval, cfg.ReplicaMaxLag)
+	}
+
+	// Expire and refund red packets past their TTL
+	if cfg.RedPacketTTL > 0 {
+		go service.StartRefunder(jobsCtx, cfg.RefundInterval, cfg.RedPacketTTL)
+	}
+
+	// Move finished red packets past the retention window to the archive tables
+	if cfg.ArchiveInterval > 0 {
+		go service.StartArchiver(jobsCtx, cfg.ArchiveInterval, cfg.ArchiveRetention)
+	}
+
+	// Apply the live settings of reloaded configurations (SIGHUP or CONFIG_FILE change)
+	api.SetGrabRateLimit(cfg.GrabRateLimit, cfg.GrabRateBurst)
+	config.Subscribe(applyReload)
+	go config.Watch(jobsCtx, cfg.ConfigWatchInterval)
+
+	// Register payment providers
+	if cfg.FakePaymentSecret != "" {
+		fakeProvider := payment.NewFakeProvider(cfg.FakePaymentSecret)
+		fakeProvider.AutoSettle = true
+		fakeProvider.Notify = func(ctx context.Context, callback *payment.Callback) error {
+			_, err := service.HandlePaymentCallback(ctx, fakeProvider.Name(), callback)
+			return err
+		}
+		service.RegisterPaymentProvider(fakeProvider)
+		log.Warn("Fake payment provider enabled, deposits settle without real payments")
+	}
+
+	// Wire the grab service to MySQL, Redis Cluster, Redlock and Kafka
+	redPacketService := service.NewRedPacketService(
+		service.NewGormPacketRepository(db.GetDB(), service.NewMasterSessionPinner()),
+		service.NewRedisStockCache(redisclient.GetRedisClient()),
+		service.NewRedlockProvider(redisclient.GetRedlock()),
+		service.NewKafkaEventPublisher(),
+	)
+
 	// Set up Gin router
-	router := routes.SetupRouter()
+	router := routes.SetupRouter(redPacketService)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -63,9 +177,18 @@ func main() {
 
 	// Start API server (non-blocking)
 	go func() {
-		log.Println("API Server is running on port: ", cfg.ServerPort)
+		log.Info("API Server is running", "port", cfg.ServerPort)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+			logger.Fatal("Server error", "error", err)
+		}
+	}()
+
+	// Serve metrics and the log level on the internal admin listener
+	adminServer := &http.Server{Addr: cfg.AdminAddr, Handler: routes.SetupAdminRouter()}
+	go func() {
+		log.Info("Admin server is running", "addr", cfg.AdminAddr)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Admin server error", "error", err)
 		}
 	}()
 
@@ -73,15 +196,38 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	sig := <-quit
-	log.Printf("Received signal: %v, shutting down server...", sig)
+	log.Info("Received signal, shutting down server", "signal", sig.String())
 
 	// Gracefully shutdown with a timeout of 5 seconds
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		logger.Fatal("Server forced to shutdown", "error", err)
 	} else {
-		log.Println("Server gracefully stopped")
+		log.Info("Server gracefully stopped")
+	}
+	adminServer.Shutdown(ctx)
+
+	// Stop background jobs, then flush buffered Kafka messages
+	stopJobs()
+	<-relayDone
+	if err := kafka.CloseProducer(); err != nil {
+		log.Error("Failed to flush Kafka Producer", "error", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error("Failed to flush spans", "error", err)
+	}
+}
+
+// applyReload applies the settings tagged `reload:"live"` in config.Config.
+// DEFAULT_GRAB_MODE needs nothing here, it is read from config.LoadConfig on every red packet creation.
+func applyReload(old, cfg *config.Config) {
+	if cfg.LogLevel != old.LogLevel {
+		logger.SetLevel(cfg.LogLevel) // Validated by the reload
 	}
+	api.SetGrabRateLimit(cfg.GrabRateLimit, cfg.GrabRateBurst)
+	db.SetSessionPinWindow(cfg.ReadYourWritesWindow)
+	db.SetReplicaMaxLag(cfg.ReplicaMaxLag)
+	service.SetReconcileRepair(cfg.ReconcileRepair)
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"red-packet-system/db"
+	"red-packet-system/pkg/logger"
+)
+
+// runShardLogs copies the legacy red_packet_logs rows into their shard tables, then checks
+// that every legacy row has a shard copy.
+// Usage: server-api shard-logs [--from-id N] [--batch N]
+// Exits with status 1 if rows are still missing from the shards.
+func runShardLogs(args []string) {
+	log := logger.GetLogger()
+
+	flags := flag.NewFlagSet("shard-logs", flag.ExitOnError)
+	fromID := flags.Uint64("from-id", 0, "resume after this legacy red_packet_logs ID")
+	batch := flags.Int("batch", 5000, "legacy IDs copied per batch")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	lastID, err := db.ShardLegacyLogs(ctx, *fromID, *batch)
+	if err != nil {
+		logger.Fatal("Sharding claim logs failed, resume with --from-id", "last_id", lastID, "error", err)
+	}
+
+	missing, err := db.CountUnshardedLogs(ctx)
+	if err != nil {
+		logger.Fatal("Verifying sharded claim logs failed", "error", err)
+	}
+	log.Info("Claim logs sharded", "last_id", lastID, "missing", missing)
+	if missing > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+
+	"red-packet-system/pkg/logger"
+	"red-packet-system/stress"
+)
+
+// stress fires concurrent grabs against local backends and checks that no red packet is oversold.
+// Usage: go run ./cmd/stress [flags]
+// Exits with status 1 if any invariant is violated.
+func main() {
+	opts := stress.DefaultOptions()
+
+	flag.StringVar(&opts.Backend, "backend", opts.Backend, "memory (in-memory implementations) or store (SQLite and in-process Redis)")
+	flag.StringVar(&opts.Mode, "mode", opts.Mode, "grab mode of the red packets: strict, fast or mixed")
+	flag.IntVar(&opts.Packets, "packets", opts.Packets, "red packets to create")
+	flag.IntVar(&opts.Shares, "shares", opts.Shares, "shares per red packet")
+	flag.Float64Var(&opts.Amount, "amount", opts.Amount, "amount per red packet")
+	flag.IntVar(&opts.Users, "users", opts.Users, "distinct grabbing users")
+	flag.IntVar(&opts.Grabs, "grabs", opts.Grabs, "grab attempts in total")
+	flag.IntVar(&opts.Concurrency, "concurrency", opts.Concurrency, "concurrent grabs")
+	flag.IntVar(&opts.Deliveries, "deliveries", opts.Deliveries, "times each grab event is delivered to the worker handlers")
+	flag.Int64Var(&opts.Seed, "seed", opts.Seed, "seed of the red packet and user picks")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	verbose := flag.Bool("v", false, "keep the service logs")
+	flag.Parse()
+
+	if !*verbose {
+		logger.Init(logger.Options{Output: io.Discard}) // Every grab logs
+	}
+
+	report, err := stress.Run(context.Background(), opts)
+	if err != nil {
+		logger.Init(logger.Options{Output: os.Stderr})
+		logger.Fatal("Stress run failed", "error", err)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		report.WriteText(os.Stdout)
+	}
+	if len(report.Violations) > 0 {
+		os.Exit(1)
+	}
+}
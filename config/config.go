@@ -1,79 +1,227 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	"red-packet-system/pkg/logger"
 )
 
-// Config stores all environment variables
+// ReplicaConfig is one MySQL read replica and its load-balancing weight
+type ReplicaConfig struct {
+	Addr   string `yaml:"addr" toml:"addr"`
+	Weight int    `yaml:"weight" toml:"weight"`
+}
+
+// Config is the process configuration. Every field has a default (see Default), can be set
+// in the config file under the lower case name of its environment variable, and is overridden
+// by the environment variable itself. Fields tagged `reload:"live"` are applied by Reload,
+// the others require a restart.
 type Config struct {
-	ServerPort      string
-	DBMaster        string
-	DBSlave         string
-	DBUser          string
-	DBPassword      string
-	DBName          string
-	RedisAddr       string
-	RedisPassword   string
-	RedisCluster    []string
-	KafkaBrokers    []string
-	ZookeeperBroker string
+	ServerPort       string   `env:"SERVER_PORT" yaml:"server_port" toml:"server_port"`
+	DBMaster         string   `env:"DB_MASTER" yaml:"db_master" toml:"db_master"`
+	DBSlave          string   `env:"DB_SLAVE" yaml:"db_slave" toml:"db_slave"`
+	DBUser           string   `env:"DB_USER" yaml:"db_user" toml:"db_user"`
+	DBPassword       string   `env:"DB_PASSWORD" yaml:"db_password" toml:"db_password" secret:"true"`
+	DBName           string   `env:"DB_NAME" yaml:"db_name" toml:"db_name"`
+	RedisAddr        string   `env:"REDIS_ADDR" yaml:"redis_addr" toml:"redis_addr"`
+	RedisPassword    string   `env:"REDIS_PASSWORD" yaml:"redis_password" toml:"redis_password" secret:"true"`
+	RedisCluster     []string `env:"REDIS_CLUSTER_NODES" yaml:"redis_cluster_nodes" toml:"redis_cluster_nodes"`
+	KafkaBrokers     []string `env:"KAFKA_BROKERS" yaml:"kafka_brokers" toml:"kafka_brokers"`
+	ZookeeperBroker  string   `env:"KAFKA_ZOOKEEPER_CONNECT" yaml:"kafka_zookeeper_connect" toml:"kafka_zookeeper_connect"`
+	KafkaEventFormat string   `env:"KAFKA_EVENT_FORMAT" yaml:"kafka_event_format" toml:"kafka_event_format"`
+	KafkaPartitioner string   `env:"KAFKA_PARTITIONER" yaml:"kafka_partitioner" toml:"kafka_partitioner"`
+	KafkaCompression string   `env:"KAFKA_COMPRESSION" yaml:"kafka_compression" toml:"kafka_compression"`
+	// Async producer batching and buffering
+	KafkaFlushFrequency time.Duration `env:"KAFKA_FLUSH_FREQUENCY" yaml:"kafka_flush_frequency" toml:"kafka_flush_frequency"`
+	KafkaFlushMessages  int           `env:"KAFKA_FLUSH_MESSAGES" yaml:"kafka_flush_messages" toml:"kafka_flush_messages"`
+	KafkaBufferSize     int           `env:"KAFKA_BUFFER_SIZE" yaml:"kafka_buffer_size" toml:"kafka_buffer_size"`
+	// Consumer group of the Kafka workers, which stores their committed offsets
+	KafkaConsumerGroup string `env:"KAFKA_CONSUMER_GROUP" yaml:"kafka_consumer_group" toml:"kafka_consumer_group"`
+	// Grab mode assigned to new red packets (strict or fast)
+	DefaultGrabMode string `env:"DEFAULT_GRAB_MODE" yaml:"default_grab_mode" toml:"default_grab_mode" reload:"live"`
+	// Scheduled Redis/MySQL stock reconciliation (0 disables)
+	ReconcileInterval time.Duration `env:"RECONCILE_INTERVAL" yaml:"reconcile_interval" toml:"reconcile_interval"`
+	ReconcileRepair   bool          `env:"RECONCILE_REPAIR" yaml:"reconcile_repair" toml:"reconcile_repair" reload:"live"`
+	// Red packets older than RedPacketTTL are expired and refunded every RefundInterval (0 TTL disables)
+	RedPacketTTL   time.Duration `env:"RED_PACKET_TTL" yaml:"red_packet_ttl" toml:"red_packet_ttl"`
+	RefundInterval time.Duration `env:"REFUND_INTERVAL" yaml:"refund_interval" toml:"refund_interval"`
+	// Finished red packets older than ArchiveRetention move to the archive tables every ArchiveInterval (0 disables)
+	ArchiveInterval  time.Duration `env:"ARCHIVE_INTERVAL" yaml:"archive_interval" toml:"archive_interval"`
+	ArchiveRetention time.Duration `env:"ARCHIVE_RETENTION" yaml:"archive_retention" toml:"archive_retention"`
+	// Outbox events failing OutboxMaxAttempts times are marked dead (0 retries forever),
+	// sent events older than OutboxRetention are purged (0 keeps them)
+	OutboxMaxAttempts int           `env:"OUTBOX_MAX_ATTEMPTS" yaml:"outbox_max_attempts" toml:"outbox_max_attempts"`
+	OutboxRetention   time.Duration `env:"OUTBOX_RETENTION" yaml:"outbox_retention" toml:"outbox_retention"`
+	// Read replicas (DB_REPLICAS, falls back to DB_SLAVE) and the replica load-balancing policy
+	DBReplicas      []ReplicaConfig `env:"DB_REPLICAS" yaml:"db_replicas" toml:"db_replicas"`
+	DBReplicaPolicy string          `env:"DB_REPLICA_POLICY" yaml:"db_replica_policy" toml:"db_replica_policy"`
+	// Connection pool settings, applied to the master and every replica
+	DBMaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS" yaml:"db_max_open_conns" toml:"db_max_open_conns"`
+	DBMaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS" yaml:"db_max_idle_conns" toml:"db_max_idle_conns"`
+	DBConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" yaml:"db_conn_max_lifetime" toml:"db_conn_max_lifetime"`
+	DBConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME" yaml:"db_conn_max_idle_time" toml:"db_conn_max_idle_time"`
+	// Failing replicas are ejected until a health check succeeds again (0 disables)
+	ReplicaHealthCheckInterval time.Duration `env:"REPLICA_HEALTH_CHECK_INTERVAL" yaml:"replica_health_check_interval" toml:"replica_health_check_interval"`
+	// Reads of a user stay on the master for ReadYourWritesWindow after a write (0 disables)
+	ReadYourWritesWindow time.Duration `env:"READ_YOUR_WRITES_WINDOW" yaml:"read_your_writes_window" toml:"read_your_writes_window" reload:"live"`
+	// Reads fall back to the master while the replica lags more than ReplicaMaxLag (0 interval disables)
+	ReplicaLagCheckInterval time.Duration `env:"REPLICA_LAG_CHECK_INTERVAL" yaml:"replica_lag_check_interval" toml:"replica_lag_check_interval"`
+	ReplicaMaxLag           time.Duration `env:"REPLICA_MAX_LAG" yaml:"replica_max_lag" toml:"replica_max_lag" reload:"live"`
+	// Enables the in-process fake payment provider when set; development only, unset by default
+	FakePaymentSecret string `env:"FAKE_PAYMENT_SECRET" yaml:"fake_payment_secret" toml:"fake_payment_secret" secret:"true"`
+	// Address of the Kafka worker's /metrics, /healthz, /readyz and /debug/log-level endpoints
+	WorkerMetricsAddr string `env:"WORKER_METRICS_ADDR" yaml:"worker_metrics_addr" toml:"worker_metrics_addr"`
+	// Internal listener of the API server's /metrics and /debug/log-level, keep it off the public network
+	AdminAddr string `env:"ADMIN_ADDR" yaml:"admin_addr" toml:"admin_addr"`
+	// Timeout of each dependency check of /readyz
+	HealthCheckTimeout time.Duration `env:"HEALTH_CHECK_TIMEOUT" yaml:"health_check_timeout" toml:"health_check_timeout"`
+	// Span exporter (none, stdout or otlp, see OTEL_EXPORTER_OTLP_ENDPOINT) and the share of traces sampled
+	TracingExporter    string  `env:"TRACING_EXPORTER" yaml:"tracing_exporter" toml:"tracing_exporter"`
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO" yaml:"tracing_sample_ratio" toml:"tracing_sample_ratio"`
+	// Log output (text or json), level (debug, info, warn, error) and grab log sampling per message and second
+	LogFormat           string `env:"LOG_FORMAT" yaml:"log_format" toml:"log_format"`
+	LogLevel            string `env:"LOG_LEVEL" yaml:"log_level" toml:"log_level" reload:"live"`
+	LogSampleFirst      int    `env:"LOG_SAMPLE_FIRST" yaml:"log_sample_first" toml:"log_sample_first"`
+	LogSampleThereafter int    `env:"LOG_SAMPLE_THEREAFTER" yaml:"log_sample_thereafter" toml:"log_sample_thereafter"`
+	// Grabs per second served by each API instance (0 disables the limit) and the burst allowed above it
+	GrabRateLimit float64 `env:"GRAB_RATE_LIMIT" yaml:"grab_rate_limit" toml:"grab_rate_limit" reload:"live"`
+	GrabRateBurst int     `env:"GRAB_RATE_BURST" yaml:"grab_rate_burst" toml:"grab_rate_burst" reload:"live"`
+	// How often CONFIG_FILE is checked for changes (0 reloads on SIGHUP only)
+	ConfigWatchInterval time.Duration `env:"CONFIG_WATCH_INTERVAL" yaml:"config_watch_interval" toml:"config_watch_interval"`
 }
 
-// Ensure singleton pattern using `sync.Once`
+// Ensure singleton pattern using `sync.Once`, Reload swaps the instance
 var (
-	configInstance *Config
+	configInstance atomic.Pointer[Config]
 	once           sync.Once
 )
 
-// LoadConfig loads environment variables (ensures singleton instance)
+// LoadConfig returns the current configuration, loaded on the first call, and exits when it
+// is invalid (ensures singleton instance). The returned Config must not be modified; read it
+// again rather than keeping it to follow the live settings.
 func LoadConfig() *Config {
-	log := logger.GetLogger()
-
 	once.Do(func() {
-		// Attempt to load `.env` from multiple possible locations
-		possiblePaths := []string{
-			"../../.env", // For `cmd/server/` execution
-			"../.env",    // For `cmd/worker/` execution
-			".env",       // For execution within `red-packet-system/`
+		cfg, err := Load()
+		if err != nil {
+			logger.Fatal("Invalid configuration", "error", err)
 		}
+		configInstance.Store(cfg)
+		logger.GetLogger().Debug("Config loaded", "config", fmt.Sprintf("%+v", *cfg.Redacted()))
+	})
 
-		envLoaded := false
-		for _, path := range possiblePaths {
-			if _, err := os.Stat(path); err == nil { // Ensure the file exists
-				if err := godotenv.Load(path); err == nil {
-					log.Printf(".env file loaded from: %s", path)
-					envLoaded = true
-					break
-				}
-			}
-		}
+	return configInstance.Load()
+}
 
-		if !envLoaded {
-			log.Println("No .env file found, using system environment variables")
-		}
+// Load reads the defaults, then the file named by CONFIG_FILE (YAML or TOML), then the
+// environment variables, including those of a .env file, and validates the result.
+// Every invalid setting is reported in the returned error; the Config is still returned
+// unless the file could not be read, so it can be printed.
+func Load() (*Config, error) {
+	loadDotEnv()
+	return load()
+}
 
-		// Load configuration from environment variables
-		configInstance = &Config{
-			ServerPort:      os.Getenv("SERVER_PORT"),
-			DBMaster:        os.Getenv("DB_MASTER"),
-			DBSlave:         os.Getenv("DB_SLAVE"),
-			DBUser:          os.Getenv("DB_USER"),
-			DBPassword:      os.Getenv("DB_PASSWORD"),
-			DBName:          os.Getenv("DB_NAME"),
-			RedisAddr:       os.Getenv("REDIS_ADDR"),
-			RedisPassword:   os.Getenv("REDIS_PASSWORD"),
-			RedisCluster:    strings.Split(os.Getenv("REDIS_CLUSTER_NODES"), ","),
-			KafkaBrokers:    strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
-			ZookeeperBroker: os.Getenv("KAFKA_ZOOKEEPER_CONNECT"),
+// load builds the configuration from the current environment, see Load
+func load() (*Config, error) {
+	cfg := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
 		}
+		logger.GetLogger().Info("Config file loaded", "path", path)
+	}
 
-		log.Printf("Config Loaded: %+v\n", configInstance)
-	})
+	envErr := cfg.applyEnv()
+	// DB_SLAVE is the single replica of older deployments
+	if len(cfg.DBReplicas) == 0 && cfg.DBSlave != "" {
+		cfg.DBReplicas = []ReplicaConfig{{Addr: cfg.DBSlave, Weight: 1}}
+	}
+	if err := errors.Join(envErr, cfg.Validate()); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Default returns the configuration of the docker-compose deployment
+func Default() *Config {
+	return &Config{
+		ServerPort:                 "8080",
+		AdminAddr:                  ":9090",
+		DBMaster:                   "mysql-master:3306",
+		DBUser:                     "root",
+		DBName:                     "red_packet_db",
+		DBReplicaPolicy:            "random",
+		DBMaxOpenConns:             100,
+		DBMaxIdleConns:             10,
+		DBConnMaxLifetime:          30 * time.Minute,
+		DBConnMaxIdleTime:          5 * time.Minute,
+		RedisCluster:               []string{"redis-cluster-1:6379", "redis-cluster-2:6379", "redis-cluster-3:6379"},
+		KafkaBrokers:               []string{"kafka:9092"},
+		ZookeeperBroker:            "zookeeper:2181",
+		KafkaEventFormat:           "json",
+		KafkaPartitioner:           "hash",
+		KafkaCompression:           "snappy",
+		KafkaFlushFrequency:        10 * time.Millisecond,
+		KafkaFlushMessages:         100,
+		KafkaBufferSize:            1024,
+		KafkaConsumerGroup:         "red-packet-worker",
+		DefaultGrabMode:            "strict",
+		RefundInterval:             time.Minute,
+		ArchiveInterval:            time.Hour,
+		ArchiveRetention:           30 * 24 * time.Hour,
+		OutboxMaxAttempts:          50,
+		OutboxRetention:            7 * 24 * time.Hour,
+		ReplicaHealthCheckInterval: 5 * time.Second,
+		ReadYourWritesWindow:       5 * time.Second,
+		ReplicaLagCheckInterval:    time.Second,
+		ReplicaMaxLag:              2 * time.Second,
+		WorkerMetricsAddr:          ":9091",
+		HealthCheckTimeout:         2 * time.Second,
+		TracingExporter:            "none",
+		TracingSampleRatio:         1,
+		LogFormat:                  logger.FormatText,
+		LogLevel:                   "info",
+		LogSampleFirst:             100,
+		LogSampleThereafter:        100,
+		GrabRateBurst:              100,
+		ConfigWatchInterval:        5 * time.Second,
+	}
+}
+
+// loadDotEnv loads the first `.env` found into the environment, variables already set win
+func loadDotEnv() {
+	log := logger.GetLogger()
+
+	// Attempt to load `.env` from multiple possible locations
+	possiblePaths := []string{
+		"../../.env", // For `cmd/server/` execution
+		"../.env",    // For `cmd/worker/` execution
+		".env",       // For execution within `red-packet-system/`
+	}
+
+	for _, path := range possiblePaths {
+		if _, err := os.Stat(path); err == nil { // Ensure the file exists
+			if err := godotenv.Load(path); err == nil {
+				log.Info(".env file loaded", "path", path)
+				return
+			}
+		}
+	}
+	log.Info("No .env file found, using system environment variables")
+}
 
-	return configInstance
+// LoggerOptions returns the logger settings, see logger.Init
+func (c *Config) LoggerOptions() logger.Options {
+	return logger.Options{
+		Format:           c.LogFormat,
+		Level:            c.LogLevel,
+		SampleFirst:      c.LogSampleFirst,
+		SampleThereafter: c.LogSampleThereafter,
+	}
 }
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults must be valid: %v", err)
+	}
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	t.Setenv("KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092,")
+	t.Setenv("DB_REPLICAS", "mysql-slave-1:3306=3,mysql-slave-2:3306")
+	t.Setenv("KAFKA_FLUSH_FREQUENCY", "25ms")
+	t.Setenv("RECONCILE_REPAIR", "true")
+
+	cfg, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kafka-1:9092", "kafka-2:9092"}; !reflect.DeepEqual(cfg.KafkaBrokers, want) {
+		t.Fatalf("expected brokers %v, got %v", want, cfg.KafkaBrokers)
+	}
+	if want := []ReplicaConfig{{"mysql-slave-1:3306", 3}, {"mysql-slave-2:3306", 1}}; !reflect.DeepEqual(cfg.DBReplicas, want) {
+		t.Fatalf("expected replicas %v, got %v", want, cfg.DBReplicas)
+	}
+	if cfg.KafkaFlushFrequency != 25*time.Millisecond || !cfg.ReconcileRepair {
+		t.Fatalf("overrides not applied: %+v", cfg)
+	}
+	if cfg.DBMaxOpenConns != 100 {
+		t.Fatalf("expected the default pool size, got %d", cfg.DBMaxOpenConns)
+	}
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	t.Setenv("KAFKA_BROKERS", "")
+	t.Setenv("DB_MAX_OPEN_CONNS", "many")
+	t.Setenv("RED_PACKET_TTL", "24h")
+	t.Setenv("REFUND_INTERVAL", "0s")
+	t.Setenv("DEFAULT_GRAB_MODE", "fastest")
+
+	cfg, err := load()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if cfg == nil {
+		t.Fatal("expected the config along with the errors")
+	}
+	for _, key := range []string{"KAFKA_BROKERS", "DB_MAX_OPEN_CONNS", "REFUND_INTERVAL", "DEFAULT_GRAB_MODE"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("expected an error for %s in:\n%v", key, err)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "kafka_brokers: [kafka-1:9092]\nrefund_interval: 30s\ndb_replicas:\n  - addr: mysql-slave:3306\n    weight: 2\n",
+		"config.toml": "kafka_brokers = [\"kafka-1:9092\"]\nrefund_interval = \"30s\"\n\n[[db_replicas]]\naddr = \"mysql-slave:3306\"\nweight = 2\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CONFIG_FILE", path)
+			t.Setenv("REFUND_INTERVAL", "45s") // The environment wins over the file
+
+			cfg, err := load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.KafkaBrokers[0] != "kafka-1:9092" || cfg.RefundInterval != 45*time.Second ||
+				len(cfg.DBReplicas) != 1 || cfg.DBReplicas[0].Weight != 2 {
+				t.Fatalf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadFileUnknownKey(t *testing.T) {
+	for name, content := range map[string]string{"config.yaml": "kafka_broker: [kafka:9092]\n", "config.toml": "kafka_broker = [\"kafka:9092\"]\n"} {
+		cfg := Default()
+		path := filepath.Join(t.TempDir(), name)
+		os.WriteFile(path, []byte(content), 0o600)
+		if err := cfg.loadFile(path); err == nil || !strings.Contains(err.Error(), "kafka_broker") {
+			t.Fatalf("%s: expected an unknown key error, got %v", name, err)
+		}
+	}
+}
+
+func TestWriteRedactsAndRoundTrips(t *testing.T) {
+	cfg := Default()
+	cfg.DBPassword = "123456"
+	cfg.DBReplicas = []ReplicaConfig{{Addr: "mysql-slave:3306", Weight: 1}}
+
+	for _, format := range []string{FormatYAML, FormatTOML} {
+		var out bytes.Buffer
+		if err := cfg.Write(&out, format); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(out.String(), "123456") {
+			t.Fatalf("%s: password printed:\n%s", format, out.String())
+		}
+
+		decoded := &Config{}
+		if err := decoded.decode(out.Bytes(), format); err != nil {
+			t.Fatalf("%s: printed config does not load: %v", format, err)
+		}
+		expected := cfg.Redacted()
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Fatalf("%s: round trip mismatch:\n%+v\n%+v", format, decoded, expected)
+		}
+	}
+	if cfg.DBPassword != "123456" {
+		t.Fatal("Redacted modified the config")
+	}
+}
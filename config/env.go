@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyEnv overrides every field whose `env` variable is set. A variable set to an empty
+// value overrides too, so `KAFKA_BROKERS=` clears the broker list and fails validation
+// instead of silently keeping the default.
+func (c *Config) applyEnv() error {
+	var errs []error
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		key := value.Type().Field(i).Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		raw, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		if err := setField(value.Field(i), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setField parses raw into a field of one of the types used by Config
+func setField(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		field.SetFloat(f)
+	case bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, expected e.g. 500ms, 5s or 1h30m", raw)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		field.Set(reflect.ValueOf(splitList(raw)))
+	case []ReplicaConfig:
+		replicas, err := parseReplicas(raw)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(replicas))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping blank entries
+func splitList(raw string) []string {
+	items := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseReplicas parses a comma-separated `host:port[=weight]` replica list, weight 1 by default
+func parseReplicas(raw string) ([]ReplicaConfig, error) {
+	replicas := []ReplicaConfig{}
+	for _, entry := range splitList(raw) {
+		addr, weight, hasWeight := strings.Cut(entry, "=")
+		replica := ReplicaConfig{Addr: strings.TrimSpace(addr), Weight: 1}
+		if hasWeight {
+			w, err := strconv.Atoi(strings.TrimSpace(weight))
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight %q of replica %s", weight, replica.Addr)
+			}
+			replica.Weight = w
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
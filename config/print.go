@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces the secrets of a printed configuration
+const redactedValue = "REDACTED"
+
+// Redacted returns a copy of c with the `secret` fields that are set replaced by REDACTED
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.RedisCluster = slices.Clone(c.RedisCluster)
+	redacted.KafkaBrokers = slices.Clone(c.KafkaBrokers)
+	redacted.DBReplicas = slices.Clone(c.DBReplicas)
+
+	value := reflect.ValueOf(&redacted).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).Tag.Get("secret") == "true" && value.Field(i).String() != "" {
+			value.Field(i).SetString(redactedValue)
+		}
+	}
+	return &redacted
+}
+
+// Write encodes c as a YAML or TOML config file, secrets redacted
+func (c *Config) Write(w io.Writer, format string) error {
+	redacted := c.Redacted()
+	switch format {
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(redacted); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case FormatTOML:
+		return toml.NewEncoder(w).Encode(redacted)
+	}
+	return fmt.Errorf("unsupported config format %q", format)
+}
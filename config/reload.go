@@ -0,0 +1,146 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"red-packet-system/pkg/logger"
+)
+
+var (
+	reloadMu    sync.Mutex // Serializes reloads and guards subscribers and restartPending
+	subscribers []func(old, cfg *Config)
+	// restartPending holds the ignored new value of each restart-only setting, warned about once
+	restartPending = map[string]any{}
+)
+
+// Subscribe registers fn to be called after every reload that changed a live setting,
+// with the previous and the new configuration
+func Subscribe(fn func(old, cfg *Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	subscribers = append(subscribers, fn)
+}
+
+// Reload reads the configuration again and applies the settings tagged `reload:"live"`.
+// Changes to other settings are logged and ignored, they keep their value until a restart.
+// An invalid configuration is rejected as a whole and the current one stays in use.
+func Reload() error {
+	current, next, notify, err := reload()
+	if err != nil || next == nil {
+		return err
+	}
+	// Called without reloadMu, a subscriber may call Subscribe
+	for _, fn := range notify {
+		fn(current, next)
+	}
+	return nil
+}
+
+// reload stores the reloaded configuration if a live setting changed and returns it with the
+// previous one and the subscribers to notify; next is nil when nothing changed
+func reload() (current, next *Config, notify []func(old, cfg *Config), err error) {
+	log := logger.GetLogger()
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	current = LoadConfig()
+
+	next, err = load()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	changed := false
+	currentValue, nextValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < nextValue.NumField(); i++ {
+		field := nextValue.Type().Field(i)
+		key := field.Tag.Get("env")
+		if reflect.DeepEqual(currentValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			delete(restartPending, key)
+			continue
+		}
+		if field.Tag.Get("reload") != "live" {
+			value := nextValue.Field(i).Interface()
+			if pending, ok := restartPending[key]; !ok || !reflect.DeepEqual(pending, value) {
+				log.Warn("Setting changed but requires a restart, keeping the current value", "setting", key)
+				restartPending[key] = value
+			}
+			nextValue.Field(i).Set(currentValue.Field(i))
+			continue
+		}
+		log.Info("Setting reloaded", "setting", key,
+			"old", fmt.Sprint(currentValue.Field(i).Interface()), "new", fmt.Sprint(nextValue.Field(i).Interface()))
+		changed = true
+	}
+	if !changed {
+		return current, nil, nil, nil
+	}
+
+	configInstance.Store(next)
+	return current, next, slices.Clone(subscribers), nil
+}
+
+// Watch reloads the configuration on SIGHUP and when CONFIG_FILE changes, checked every
+// interval (0 for SIGHUP only), until ctx is cancelled
+func Watch(ctx context.Context, interval time.Duration) {
+	log := logger.GetLogger()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	path := os.Getenv("CONFIG_FILE")
+	var poll <-chan time.Time
+	if path != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	version := fileVersionOf(path)
+	log.Info("Config watch started", "file", path, "interval", interval)
+
+	reload := func(trigger string) {
+		if err := Reload(); err != nil {
+			log.Error("Config reload rejected, keeping the current configuration", "trigger", trigger, "error", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Config watch stopped")
+			return
+		case <-hangup:
+			reload("SIGHUP")
+		case <-poll:
+			if current := fileVersionOf(path); current != version {
+				version = current
+				reload("file changed")
+			}
+		}
+	}
+}
+
+// fileVersion identifies a revision of the config file, following symlinks so that
+// ConfigMap style atomic swaps are noticed
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func fileVersionOf(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
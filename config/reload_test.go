@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"red-packet-system/pkg/logger"
+)
+
+// resetReload drops the subscribers and pending restart warnings of the test once it ends
+func resetReload(t *testing.T) {
+	t.Cleanup(func() {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		subscribers = nil
+		restartPending = map[string]any{}
+	})
+}
+
+func TestReload(t *testing.T) {
+	resetReload(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("log_level: info\nserver_port: \"8080\"\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	initial, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	once.Do(func() {})
+	configInstance.Store(initial)
+
+	var notified []*Config
+	Subscribe(func(old, cfg *Config) {
+		if old != initial {
+			t.Errorf("expected the previous configuration")
+		}
+		notified = append(notified, cfg)
+	})
+
+	// A live setting is applied, a restart-only one keeps its value
+	write("log_level: debug\ngrab_rate_limit: 500\nserver_port: \"9090\"\n")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := LoadConfig()
+	if cfg.LogLevel != "debug" || cfg.GrabRateLimit != 500 {
+		t.Fatalf("live settings not applied: %+v", cfg)
+	}
+	if cfg.ServerPort != "8080" {
+		t.Fatalf("expected SERVER_PORT to keep its value, got %s", cfg.ServerPort)
+	}
+	if len(notified) != 1 || notified[0] != cfg {
+		t.Fatalf("expected one notification with the new configuration, got %d", len(notified))
+	}
+
+	// An invalid file is rejected as a whole
+	write("log_level: debug\ngrab_rate_limit: 500\ndefault_grab_mode: fastest\nrefund_interval: 10s\n")
+	if err := Reload(); err == nil {
+		t.Fatal("expected the invalid configuration to be rejected")
+	}
+	if LoadConfig() != cfg || LoadConfig().RefundInterval != time.Minute {
+		t.Fatal("the rejected configuration was applied")
+	}
+
+	// Only restart-only changes: nothing to notify
+	write("log_level: debug\ngrab_rate_limit: 500\nserver_port: \"7070\"\n")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 1 {
+		t.Fatalf("expected no notification, got %d", len(notified))
+	}
+}
+
+func TestReloadWarnsOnceAndNotifiesUnlocked(t *testing.T) {
+	resetReload(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reload := func() {
+		if err := Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("server_port: \"8080\"\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	initial, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	once.Do(func() {})
+	configInstance.Store(initial)
+
+	var out bytes.Buffer
+	if err := logger.Init(logger.Options{Output: &out}); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Init(logger.Options{})
+	warnings := func() int { return strings.Count(out.String(), "requires a restart") }
+
+	// A pending restart-only change is reported once, then again when its value changes
+	write("server_port: \"9090\"\n")
+	reload()
+	reload()
+	if n := warnings(); n != 1 {
+		t.Fatalf("expected 1 warning, got %d:\n%s", n, out.String())
+	}
+	write("server_port: \"7070\"\n")
+	reload()
+	write("server_port: \"8080\"\n")
+	reload()
+	write("server_port: \"7070\"\n")
+	reload()
+	if n := warnings(); n != 3 {
+		t.Fatalf("expected 3 warnings, got %d:\n%s", n, out.String())
+	}
+
+	// Subscribers run after the reload lock is released, so they may subscribe
+	calls := 0
+	Subscribe(func(old, cfg *Config) {
+		calls++
+		Subscribe(func(old, cfg *Config) {})
+	})
+	write("log_level: debug\n")
+	done := make(chan error, 1)
+	go func() { done <- Reload() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reload deadlocked on a subscriber calling Subscribe")
+	}
+	if calls != 1 || LoadConfig().LogLevel != "debug" {
+		t.Fatalf("expected the subscriber to be notified once with the new level, got %d calls", calls)
+	}
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"red-packet-system/pkg/logger"
+)
+
+// Accepted values of the enumerated settings, as understood by the packages using them
+var (
+	replicaPolicies  = []string{"random", "round_robin", "least_conn"}
+	eventFormats     = []string{"json", "protobuf", "proto", "application/json", "application/x-protobuf"}
+	partitioners     = []string{"hash", "reference", "crc32"}
+	compressions     = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+	grabModes        = []string{"strict", "fast"}
+	tracingExporters = []string{"none", "stdout", "otlp"}
+	logFormats       = []string{logger.FormatText, logger.FormatJSON}
+)
+
+// validator collects every problem instead of stopping at the first one
+type validator struct {
+	errs []error
+}
+
+// check records the message for key unless ok
+func (v *validator) check(ok bool, key, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) required(key, value string) {
+	v.check(strings.TrimSpace(value) != "", key, "must be set")
+}
+
+func (v *validator) oneOf(key, value string, allowed []string) {
+	v.check(slices.Contains(allowed, value), key, "unsupported value %q, expected one of %s", value, strings.Join(allowed, ", "))
+}
+
+func (v *validator) atLeast(key string, value, min int) {
+	v.check(value >= min, key, "must be at least %d, got %d", min, value)
+}
+
+// positive checks a duration that must be set, e.g. a ticker interval
+func (v *validator) positive(key string, value time.Duration) {
+	v.check(value > 0, key, "must be greater than 0, got %s", value)
+}
+
+// nonNegative checks a duration where 0 disables the feature
+func (v *validator) nonNegative(key string, value time.Duration) {
+	v.check(value >= 0, key, "must not be negative, got %s", value)
+}
+
+// addr checks a host:port address, the host may be empty to listen on every interface
+func (v *validator) addr(key, value string) {
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		v.check(false, key, "invalid address %q, expected host:port", value)
+		return
+	}
+	n, err := strconv.Atoi(port)
+	v.check(err == nil && n > 0 && n <= 65535, key, "invalid port in %q", value)
+}
+
+// addrs checks a non-empty list of host:port addresses
+func (v *validator) addrs(key string, values []string) {
+	v.check(len(values) > 0, key, "must list at least one host:port address")
+	for _, value := range values {
+		v.addr(key, value)
+	}
+}
+
+// Validate checks every setting and reports all the invalid ones at once
+func (c *Config) Validate() error {
+	var v validator
+
+	port, err := strconv.Atoi(c.ServerPort)
+	v.check(err == nil && port > 0 && port <= 65535, "SERVER_PORT", "invalid port %q", c.ServerPort)
+	v.addr("ADMIN_ADDR", c.AdminAddr)
+	v.addr("WORKER_METRICS_ADDR", c.WorkerMetricsAddr)
+
+	// MySQL
+	v.addr("DB_MASTER", c.DBMaster)
+	v.required("DB_USER", c.DBUser)
+	v.required("DB_NAME", c.DBName)
+	for _, replica := range c.DBReplicas {
+		v.addr("DB_REPLICAS", replica.Addr)
+		v.check(replica.Weight > 0, "DB_REPLICAS", "weight of %s must be at least 1, got %d", replica.Addr, replica.Weight)
+	}
+	v.oneOf("DB_REPLICA_POLICY", c.DBReplicaPolicy, replicaPolicies)
+	v.atLeast("DB_MAX_OPEN_CONNS", c.DBMaxOpenConns, 1)
+	v.atLeast("DB_MAX_IDLE_CONNS", c.DBMaxIdleConns, 0)
+	v.check(c.DBMaxIdleConns <= c.DBMaxOpenConns, "DB_MAX_IDLE_CONNS", "must not exceed DB_MAX_OPEN_CONNS (%d), got %d", c.DBMaxOpenConns, c.DBMaxIdleConns)
+	v.nonNegative("DB_CONN_MAX_LIFETIME", c.DBConnMaxLifetime)
+	v.nonNegative("DB_CONN_MAX_IDLE_TIME", c.DBConnMaxIdleTime)
+	v.nonNegative("REPLICA_HEALTH_CHECK_INTERVAL", c.ReplicaHealthCheckInterval)
+	v.nonNegative("READ_YOUR_WRITES_WINDOW", c.ReadYourWritesWindow)
+	v.nonNegative("REPLICA_LAG_CHECK_INTERVAL", c.ReplicaLagCheckInterval)
+	v.nonNegative("REPLICA_MAX_LAG", c.ReplicaMaxLag)
+
+	// Redis and Kafka
+	v.addrs("REDIS_CLUSTER_NODES", c.RedisCluster)
+	v.addrs("KAFKA_BROKERS", c.KafkaBrokers)
+	v.oneOf("KAFKA_EVENT_FORMAT", c.KafkaEventFormat, eventFormats)
+	v.oneOf("KAFKA_PARTITIONER", c.KafkaPartitioner, partitioners)
+	v.oneOf("KAFKA_COMPRESSION", c.KafkaCompression, compressions)
+	v.nonNegative("KAFKA_FLUSH_FREQUENCY", c.KafkaFlushFrequency)
+	v.atLeast("KAFKA_FLUSH_MESSAGES", c.KafkaFlushMessages, 0)
+	v.atLeast("KAFKA_BUFFER_SIZE", c.KafkaBufferSize, 1)
+	v.required("KAFKA_CONSUMER_GROUP", c.KafkaConsumerGroup)
+
+	// Red packets and background jobs
+	v.oneOf("DEFAULT_GRAB_MODE", c.DefaultGrabMode, grabModes)
+	v.nonNegative("RECONCILE_INTERVAL", c.ReconcileInterval)
+	v.nonNegative("RED_PACKET_TTL", c.RedPacketTTL)
+	if c.RedPacketTTL > 0 {
+		v.positive("REFUND_INTERVAL", c.RefundInterval)
+	}
+	v.nonNegative("ARCHIVE_INTERVAL", c.ArchiveInterval)
+	v.positive("ARCHIVE_RETENTION", c.ArchiveRetention)
+	v.atLeast("OUTBOX_MAX_ATTEMPTS", c.OutboxMaxAttempts, 0)
+	v.nonNegative("OUTBOX_RETENTION", c.OutboxRetention)
+
+	// Observability
+	v.positive("HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout)
+	v.oneOf("TRACING_EXPORTER", c.TracingExporter, tracingExporters)
+	v.check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "TRACING_SAMPLE_RATIO", "must be between 0 and 1, got %g", c.TracingSampleRatio)
+	v.oneOf("LOG_FORMAT", c.LogFormat, logFormats)
+	_, err = logger.ParseLevel(c.LogLevel)
+	v.check(err == nil, "LOG_LEVEL", "unsupported value %q, expected debug, info, warn or error", c.LogLevel)
+	v.atLeast("LOG_SAMPLE_FIRST", c.LogSampleFirst, 1)
+	v.atLeast("LOG_SAMPLE_THEREAFTER", c.LogSampleThereafter, 0)
+
+	// Runtime tuning
+	v.check(c.GrabRateLimit >= 0, "GRAB_RATE_LIMIT", "must not be negative, got %g", c.GrabRateLimit)
+	if c.GrabRateLimit > 0 {
+		v.atLeast("GRAB_RATE_BURST", c.GrabRateBurst, 1)
+	}
+	v.nonNegative("CONFIG_WATCH_INTERVAL", c.ConfigWatchInterval)
+
+	return errors.Join(v.errs...)
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// statementCallback is the unexported GORM type returned by Before and After
+type statementCallback interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// registerAroundStatements registers before and after callbacks, named name+":start" and
+// name+":end", around every create, query, update, delete, row and raw statement.
+// The callbacks receive the operation name.
+func registerAroundStatements(db *gorm.DB, name string, before, after func(operation string) func(*gorm.DB)) error {
+	register := func(operation string, start, end statementCallback) error {
+		if err := start.Register(name+":start", before(operation)); err != nil {
+			return err
+		}
+		return end.Register(name+":end", after(operation))
+	}
+
+	callbacks := db.Callback()
+	if err := register("create", callbacks.Create().Before("gorm:create"), callbacks.Create().After("gorm:create")); err != nil {
+		return err
+	}
+	if err := register("query", callbacks.Query().Before("gorm:query"), callbacks.Query().After("gorm:query")); err != nil {
+		return err
+	}
+	if err := register("update", callbacks.Update().Before("gorm:update"), callbacks.Update().After("gorm:update")); err != nil {
+		return err
+	}
+	if err := register("delete", callbacks.Delete().Before("gorm:delete"), callbacks.Delete().After("gorm:delete")); err != nil {
+		return err
+	}
+	if err := register("row", callbacks.Row().Before("gorm:row"), callbacks.Row().After("gorm:row")); err != nil {
+		return err
+	}
+	return register("raw", callbacks.Raw().Before("gorm:raw"), callbacks.Raw().After("gorm:raw"))
+}
+
+// statementTable returns the table of a statement, "raw" for hand-written SQL
+func statementTable(tx *gorm.DB) string {
+	if tx.Statement.Table == "" {
+		return "raw"
+	}
+	return tx.Statement.Table
+}
+
+// statementError returns the error of a statement, ignoring lookup misses
+func statementError(tx *gorm.DB) error {
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil // A lookup miss is a successful query
+	}
+	return tx.Error
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"red-packet-system/pkg/health"
+)
+
+// HealthChecks returns the readiness checks of the master and of every replica.
+// Replicas are optional: while one is down its reads go to the master.
+func HealthChecks() []health.Check {
+	checks := []health.Check{{Name: "mysql_master", Run: pingMaster}}
+	for _, r := range replicas {
+		checks = append(checks, health.Check{Name: "mysql_replica_" + r.addr, Optional: true, Run: r.pool.PingContext})
+	}
+	return checks
+}
+
+// pingMaster pings the MySQL master pool
+func pingMaster(ctx context.Context) error {
+	if masterPool == nil {
+		return errors.New("MySQL is not initialized")
+	}
+	return masterPool.PingContext(ctx)
+}
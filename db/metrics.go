@@ -0,0 +1,42 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+	"red-packet-system/pkg/metrics"
+)
+
+// queryStartKey stores the statement start time in the GORM instance
+const queryStartKey = "red_packet:query_start"
+
+// registerMetricsCallbacks times every GORM statement into metrics.DBQueryDuration
+func registerMetricsCallbacks(db *gorm.DB) error {
+	start := func(string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			tx.InstanceSet(queryStartKey, time.Now())
+		}
+	}
+	observe := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			value, ok := tx.InstanceGet(queryStartKey)
+			if !ok {
+				return
+			}
+			result := metrics.Result(statementError(tx))
+			metrics.DBQueryDuration.WithLabelValues(operation, statementTable(tx), result).Observe(metrics.Since(value.(time.Time)))
+		}
+	}
+	return registerAroundStatements(db, "red_packet:metrics", start, observe)
+}
+
+// registerPoolMetrics exposes the connection pool statistics of the master and every replica
+func registerPoolMetrics(master *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(master, "master"))
+	for _, r := range replicas {
+		prometheus.MustRegister(collectors.NewDBStatsCollector(r.pool, "replica_"+r.addr))
+	}
+}
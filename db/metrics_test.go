@@ -0,0 +1,84 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"red-packet-system/pkg/metrics"
+)
+
+// statementCount returns how many statements DBQueryDuration observed with the given labels
+func statementCount(t *testing.T, operation, table, result string) uint64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"operation": operation, "table": table, "result": result}
+	for _, family := range families {
+		if family.GetName() != "red_packet_db_query_duration_seconds" {
+			continue
+		}
+	series:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if want[label.GetName()] != label.GetValue() {
+					continue series
+				}
+			}
+			return metric.GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestMetricsCallbacksTimeStatements(t *testing.T) {
+	sqliteDB, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registerMetricsCallbacks(sqliteDB); err != nil {
+		t.Fatal(err)
+	}
+	metrics.DBQueryDuration.Reset()
+	t.Cleanup(metrics.DBQueryDuration.Reset)
+
+	type metricsNode struct {
+		ID   uint
+		Name string
+	}
+	if err := sqliteDB.Exec("CREATE TABLE metrics_nodes (id INTEGER PRIMARY KEY, name TEXT)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := sqliteDB.Create(&metricsNode{Name: "master"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var node metricsNode
+	if err := sqliteDB.First(&node).Error; err != nil {
+		t.Fatal(err)
+	}
+	sqliteDB.First(&node, 42)                                  // A lookup miss is not an error
+	sqliteDB.Table("missing_table").Find(&[]metricsNode{})     // Fails
+	sqliteDB.Model(&node).Update("name", "replica")            // Update
+	sqliteDB.Raw("SELECT name FROM metrics_nodes").Scan(&node) // Hand-written read
+
+	tests := []struct {
+		operation, table, result string
+		want                     uint64
+	}{
+		{"raw", "raw", "ok", 1}, // CREATE TABLE
+		{"row", "raw", "ok", 1}, // Hand-written SELECT
+		{"create", "metrics_nodes", "ok", 1},
+		{"query", "metrics_nodes", "ok", 2},
+		{"query", "missing_table", "error", 1},
+		{"update", "metrics_nodes", "ok", 1},
+	}
+	for _, tt := range tests {
+		if got := statementCount(t, tt.operation, tt.table, tt.result); got != tt.want {
+			t.Errorf("%s %s %s: observed %d statements, want %d", tt.operation, tt.table, tt.result, got, tt.want)
+		}
+	}
+}
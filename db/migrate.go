@@ -0,0 +1,395 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"red-packet-system/db/migrations"
+	"red-packet-system/pkg/logger"
+)
+
+// schemaMigrationsTable keeps the golang-migrate layout (single row: version, dirty),
+// so databases migrated with the external `migrate` binary are picked up as-is.
+const schemaMigrationsTable = "schema_migrations"
+
+// migrationLockName serializes migration runs across processes (MySQL GET_LOCK)
+const migrationLockName = "red_packet_system.schema_migrations"
+
+var (
+	ErrSchemaDirty    = errors.New("schema is dirty, a previous migration failed half-way; fix it and run `migrate force <version>`")
+	ErrSchemaOutdated = errors.New("schema is outdated, run `migrate up`")
+)
+
+// Migration is one embedded schema version
+type Migration struct {
+	Version uint
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus describes the schema version of the database
+type MigrationStatus struct {
+	Current uint // 0 when no migration was applied
+	Dirty   bool
+	Latest  uint
+	Pending []Migration
+}
+
+// migrationData is passed to the migration files, which are text/templates, so statements
+// repeated per claim log table are generated instead of copied
+type migrationData struct {
+	HotLogTables   []string // Legacy red_packet_logs and its shards, claims of red_packets
+	ClaimLogTables []string // HotLogTables and the archive table
+}
+
+// newMigrationData lists the tables of the current sharding layout
+func newMigrationData() migrationData {
+	hot := append([]string{LegacyLogTable}, LogTables()...)
+	return migrationData{
+		HotLogTables:   hot,
+		ClaimLogTables: append(slices.Clone(hot), ArchiveLogTable),
+	}
+}
+
+// renderMigration executes a migration file template
+func renderMigration(name string, content []byte) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("invalid migration file %q: %v", name, err)
+	}
+	var script strings.Builder
+	if err := tmpl.Execute(&script, newMigrationData()); err != nil {
+		return "", fmt.Errorf("invalid migration file %q: %v", name, err)
+	}
+	return script.String(), nil
+}
+
+// LoadMigrations parses the embedded migration files ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		prefix, rest, ok := strings.Cut(name, "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+
+		content, err := fs.ReadFile(migrations.FS, name)
+		if err != nil {
+			return nil, err
+		}
+		script, err := renderMigration(name, content)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: strings.TrimSuffix(rest, "."+direction+".sql")}
+			byVersion[uint(version)] = m
+		}
+		if direction == "up" {
+			m.up = script
+		} else {
+			m.down = script
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", m.Version)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// GetMigrationStatus returns the current and latest schema versions
+func GetMigrationStatus(ctx context.Context) (*MigrationStatus, error) {
+	var status *MigrationStatus
+	err := withMigrationConn(ctx, func(conn *sql.Conn, list []Migration) error {
+		current, dirty, err := readSchemaVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		status = &MigrationStatus{Current: current, Dirty: dirty}
+		for _, m := range list {
+			status.Latest = m.Version
+			if m.Version > current {
+				status.Pending = append(status.Pending, m)
+			}
+		}
+		return nil
+	})
+	return status, err
+}
+
+// CheckSchemaVersion refuses to start on a dirty or outdated schema
+func CheckSchemaVersion(ctx context.Context) error {
+	status, err := GetMigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		return fmt.Errorf("%w (version %d)", ErrSchemaDirty, status.Current)
+	}
+	if status.Current < status.Latest {
+		return fmt.Errorf("%w (database at %d, binary expects %d)", ErrSchemaOutdated, status.Current, status.Latest)
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration
+func MigrateUp(ctx context.Context) error {
+	list, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return MigrateTo(ctx, list[len(list)-1].Version)
+}
+
+// MigrateDown reverts the latest `steps` migrations
+func MigrateDown(ctx context.Context, steps int) error {
+	return withMigrationConn(ctx, func(conn *sql.Conn, list []Migration) error {
+		current, dirty, err := readSchemaVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrSchemaDirty
+		}
+
+		for i := len(list) - 1; i >= 0 && steps > 0; i-- {
+			if list[i].Version > current {
+				continue
+			}
+			previous := uint(0)
+			if i > 0 {
+				previous = list[i-1].Version
+			}
+			if err := applyMigration(ctx, conn, list[i], false, previous); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrateTo migrates up or down to version; 0 reverts every migration
+func MigrateTo(ctx context.Context, version uint) error {
+	return withMigrationConn(ctx, func(conn *sql.Conn, list []Migration) error {
+		current, dirty, err := readSchemaVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrSchemaDirty
+		}
+
+		steps, err := planMigration(list, current, version)
+		if err != nil {
+			return err
+		}
+		for _, step := range steps {
+			if err := applyMigration(ctx, conn, step.migration, step.up, step.target); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrationStep is one migration to run and the version recorded once it succeeded
+type migrationStep struct {
+	migration Migration
+	up        bool
+	target    uint
+}
+
+// planMigration returns the steps moving the schema from current to version, in order
+func planMigration(list []Migration, current, version uint) ([]migrationStep, error) {
+	if version != 0 && !hasMigration(list, version) {
+		return nil, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	var steps []migrationStep
+
+	// Up
+	for _, m := range list {
+		if m.Version > current && m.Version <= version {
+			steps = append(steps, migrationStep{migration: m, up: true, target: m.Version})
+		}
+	}
+
+	// Down
+	for i := len(list) - 1; i >= 0; i-- {
+		m := list[i]
+		if m.Version <= current && m.Version > version {
+			previous := uint(0)
+			if i > 0 {
+				previous = list[i-1].Version
+			}
+			steps = append(steps, migrationStep{migration: m, up: false, target: previous})
+		}
+	}
+	return steps, nil
+}
+
+// ForceVersion records version as current and clears the dirty flag without running SQL
+func ForceVersion(ctx context.Context, version uint) error {
+	return withMigrationConn(ctx, func(conn *sql.Conn, _ []Migration) error {
+		return writeSchemaVersion(ctx, conn, version, false)
+	})
+}
+
+// hasMigration reports whether version is an embedded migration
+func hasMigration(list []Migration, version uint) bool {
+	for _, m := range list {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// withMigrationConn runs fn on a dedicated master connection holding the migration lock
+func withMigrationConn(ctx context.Context, fn func(conn *sql.Conn, list []Migration) error) error {
+	list, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if DB == nil {
+		return errors.New("MySQL is not initialized, please run InitDB() first")
+	}
+	sqlDB, err := DB.DB() // Master pool, dbresolver replicas are not involved
+	if err != nil {
+		return err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", migrationLockName).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return errors.New("timed out waiting for the migration lock")
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", migrationLockName)
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+schemaMigrationsTable+
+		" (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)"); err != nil {
+		return err
+	}
+
+	return fn(conn, list)
+}
+
+// readSchemaVersion returns the recorded version, 0 when none
+func readSchemaVersion(ctx context.Context, conn *sql.Conn) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM "+schemaMigrationsTable+" LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// writeSchemaVersion replaces the recorded version
+func writeSchemaVersion(ctx context.Context, conn *sql.Conn, version uint, dirty bool) error {
+	if _, err := conn.ExecContext(ctx, "DELETE FROM "+schemaMigrationsTable); err != nil {
+		return err
+	}
+	if version == 0 && !dirty {
+		return nil
+	}
+	_, err := conn.ExecContext(ctx, "INSERT INTO "+schemaMigrationsTable+" (version, dirty) VALUES (?, ?)", version, dirty)
+	return err
+}
+
+// applyMigration runs one direction of a migration. MySQL DDL is not transactional, so the
+// version is marked dirty first and only cleared once every statement succeeded.
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool, target uint) error {
+	log := logger.GetLogger()
+
+	script, direction := m.down, "down"
+	if up {
+		script, direction = m.up, "up"
+	}
+
+	if err := writeSchemaVersion(ctx, conn, m.Version, true); err != nil {
+		return err
+	}
+
+	for _, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migration %d_%s %s failed: %v", m.Version, m.Name, direction, err)
+		}
+	}
+
+	if err := writeSchemaVersion(ctx, conn, target, false); err != nil {
+		return err
+	}
+	log.Info("Migration applied", "version", m.Version, "name", m.Name, "direction", direction)
+	return nil
+}
+
+// splitStatements splits a script on `;` line endings, dropping `--` comment lines
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
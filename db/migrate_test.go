@@ -0,0 +1,109 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range list {
+		if m.Version != uint(i+1) {
+			t.Fatalf("expected version %d, got %d_%s", i+1, m.Version, m.Name)
+		}
+		if strings.Contains(m.up+m.down, "{{") {
+			t.Fatalf("migration %d_%s left a template action unexecuted", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrationTemplatesCoverEveryLogTable(t *testing.T) {
+	list, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fastClaims := list[13]
+	if fastClaims.Name != "red_packet_logs_fast_claims" {
+		t.Fatalf("expected migration 14 to be red_packet_logs_fast_claims, got %s", fastClaims.Name)
+	}
+
+	tables := append([]string{LegacyLogTable, ArchiveLogTable}, LogTables()...)
+	up, down := splitStatements(fastClaims.up), splitStatements(fastClaims.down)
+	for _, table := range tables {
+		alter := "ALTER TABLE " + table + "\n"
+		if count := countPrefixed(up, alter); count != 1 {
+			t.Errorf("up alters %s %d times, want once", table, count)
+		}
+		if count := countPrefixed(down, alter); count != 1 {
+			t.Errorf("down alters %s %d times, want once", table, count)
+		}
+		if count := countPrefixed(up, "UPDATE "+table+" l "); count != 1 {
+			t.Errorf("up backfills %s %d times, want once", table, count)
+		}
+	}
+	// Per table: the conditional index drop (4 statements), the ALTER and the backfill
+	if want := 6 * len(tables); len(up) != want {
+		t.Errorf("up has %d statements, want %d", len(up), want)
+	}
+}
+
+// countPrefixed counts the statements starting with prefix
+func countPrefixed(statements []string, prefix string) int {
+	count := 0
+	for _, statement := range statements {
+		if strings.HasPrefix(statement, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPlanMigration(t *testing.T) {
+	list := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+
+	// describe renders steps as "+version>target" or "-version>target"
+	describe := func(steps []migrationStep) []string {
+		var out []string
+		for _, step := range steps {
+			sign := "-"
+			if step.up {
+				sign = "+"
+			}
+			out = append(out, fmt.Sprintf("%s%d>%d", sign, step.migration.Version, step.target))
+		}
+		return out
+	}
+
+	tests := []struct {
+		name             string
+		current, version uint
+		steps            []string
+	}{
+		{"up from scratch", 0, 3, []string{"+1>1", "+2>2", "+3>3"}},
+		{"up partially", 1, 2, []string{"+2>2"}},
+		{"down one", 3, 2, []string{"-3>2"}},
+		{"zero reverts everything", 3, 0, []string{"-3>2", "-2>1", "-1>0"}},
+		{"already there", 2, 2, nil},
+		{"nothing to revert", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := planMigration(list, tt.current, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describe(steps); !reflect.DeepEqual(got, tt.steps) {
+				t.Fatalf("expected %v, got %v", tt.steps, got)
+			}
+		})
+	}
+
+	if _, err := planMigration(list, 1, 7); err == nil {
+		t.Fatal("expected an error for an unknown version")
+	}
+}
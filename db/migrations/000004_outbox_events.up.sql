@@ -0,0 +1,15 @@
+CREATE TABLE outbox_events (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    topic VARCHAR(255) NOT NULL COMMENT 'Kafka topic the event is published to',
+    event_key VARCHAR(255) NOT NULL COMMENT 'Kafka message key',
+    payload TEXT NOT NULL COMMENT 'Encoded event payload',
+    status TINYINT NOT NULL DEFAULT 0 COMMENT '0: Pending, 1: Sent',
+    attempts INT NOT NULL DEFAULT 0 COMMENT 'Number of failed publish attempts',
+    last_error TEXT NULL COMMENT 'Last publish error',
+    sent_at TIMESTAMP NULL DEFAULT NULL COMMENT 'Timestamp when the event was published',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Record creation timestamp',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Last update timestamp'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Transactional outbox for Kafka events';
+
+-- Relay polls pending events in insertion order
+CREATE INDEX idx_outbox_events_status_id ON outbox_events (status, id);
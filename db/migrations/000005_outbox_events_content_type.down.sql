@@ -0,0 +1,3 @@
+ALTER TABLE outbox_events
+    DROP COLUMN content_type,
+    MODIFY payload TEXT NOT NULL COMMENT 'Encoded event payload';
@@ -0,0 +1,3 @@
+ALTER TABLE outbox_events
+    MODIFY payload BLOB NOT NULL COMMENT 'Encoded event envelope',
+    ADD COLUMN content_type VARCHAR(64) NOT NULL DEFAULT 'text/csv' COMMENT 'Payload encoding (text/csv, application/json, application/x-protobuf)' AFTER payload;
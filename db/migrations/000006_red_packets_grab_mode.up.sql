@@ -0,0 +1,6 @@
+ALTER TABLE red_packets
+    ADD COLUMN grab_mode VARCHAR(16) NOT NULL DEFAULT 'strict' COMMENT 'strict: Redlock + MySQL per grab, fast: Redis claim persisted by the Kafka worker' AFTER status;
+
+-- Earlier versions of this migration added a unique (red_packet_id, user_id) key to red_packet_logs,
+-- which fails on users with several strict mode grabs of a red packet. Fast mode claims are kept
+-- unique by the claim_user_id key of migration 000014 instead.
@@ -0,0 +1,8 @@
+ALTER TABLE red_packets
+    DROP COLUMN refunded_at,
+    DROP COLUMN refunded_amount,
+    DROP COLUMN sender_id;
+
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS ledger_accounts;
@@ -0,0 +1,32 @@
+CREATE TABLE ledger_accounts (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    type VARCHAR(32) NOT NULL COMMENT 'user, red_packet or external',
+    owner_id BIGINT NOT NULL DEFAULT 0 COMMENT 'User ID, red packet ID, or 0 for external',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Record creation timestamp',
+    UNIQUE KEY uk_ledger_accounts_type_owner (type, owner_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Double-entry ledger accounts';
+
+CREATE TABLE journal_entries (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    type VARCHAR(32) NOT NULL COMMENT 'opening_balance, packet_funding, grab_credit, packet_refund, ...',
+    reference VARCHAR(128) NOT NULL COMMENT 'Idempotency key of the business event',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Record creation timestamp',
+    UNIQUE KEY uk_journal_entries_reference (reference)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Double-entry ledger journal entries';
+
+CREATE TABLE postings (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    journal_entry_id BIGINT NOT NULL COMMENT 'Journal entry ID',
+    account_id BIGINT NOT NULL COMMENT 'Ledger account ID',
+    amount BIGINT NOT NULL COMMENT 'Amount in cents, positive credits the account',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Record creation timestamp'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Double-entry ledger postings, each entry sums to zero';
+
+-- Indexes for balance derivation and entry lookups
+CREATE INDEX idx_postings_account_id ON postings (account_id);
+CREATE INDEX idx_postings_journal_entry_id ON postings (journal_entry_id);
+
+ALTER TABLE red_packets
+    ADD COLUMN sender_id BIGINT NOT NULL DEFAULT 0 COMMENT 'Funding user ID, 0 for system funded packets' AFTER id,
+    ADD COLUMN refunded_amount DECIMAL(10,2) NOT NULL DEFAULT 0 COMMENT 'Amount refunded to the sender on expiry' AFTER grab_mode,
+    ADD COLUMN refunded_at TIMESTAMP NULL DEFAULT NULL COMMENT 'Timestamp of the expiry refund' AFTER refunded_amount;
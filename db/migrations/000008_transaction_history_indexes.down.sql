@@ -0,0 +1,5 @@
+DROP INDEX idx_red_packets_sender_refunded ON red_packets;
+DROP INDEX idx_red_packets_sender_created ON red_packets;
+
+CREATE INDEX idx_red_packet_logs_user_id ON red_packet_logs (user_id);
+DROP INDEX idx_red_packet_logs_user_created ON red_packet_logs;
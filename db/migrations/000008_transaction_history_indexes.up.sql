@@ -0,0 +1,7 @@
+-- Received history: red_packet_logs by user, newest first
+CREATE INDEX idx_red_packet_logs_user_created ON red_packet_logs (user_id, created_at);
+DROP INDEX idx_red_packet_logs_user_id ON red_packet_logs;
+
+-- Sent and refund history: red_packets by sender
+CREATE INDEX idx_red_packets_sender_created ON red_packets (sender_id, created_at);
+CREATE INDEX idx_red_packets_sender_refunded ON red_packets (sender_id, refunded_at);
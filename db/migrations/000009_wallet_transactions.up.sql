@@ -0,0 +1,16 @@
+CREATE TABLE wallet_transactions (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    user_id BIGINT NOT NULL COMMENT 'Wallet owner',
+    type VARCHAR(16) NOT NULL COMMENT 'deposit or withdrawal',
+    amount DECIMAL(10,2) NOT NULL COMMENT 'Transaction amount',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' COMMENT 'pending, settled or failed',
+    provider VARCHAR(32) NOT NULL COMMENT 'Payment provider name',
+    reference VARCHAR(64) NOT NULL COMMENT 'Our idempotency key, echoed by provider callbacks',
+    provider_reference VARCHAR(128) NOT NULL DEFAULT '' COMMENT 'Provider side transaction ID',
+    failure_reason VARCHAR(255) NOT NULL DEFAULT '' COMMENT 'Provider failure reason',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Record creation timestamp',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Last update timestamp',
+    UNIQUE KEY uk_wallet_transactions_reference (reference)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Deposits and withdrawals through payment providers';
+
+CREATE INDEX idx_wallet_transactions_user_created ON wallet_transactions (user_id, created_at);
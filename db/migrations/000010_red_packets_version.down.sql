@@ -0,0 +1 @@
+ALTER TABLE red_packets DROP COLUMN version;
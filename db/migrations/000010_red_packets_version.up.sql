@@ -0,0 +1,2 @@
+ALTER TABLE red_packets
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 0 COMMENT 'Optimistic concurrency version, incremented on every counter update';
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS red_packet_logs_00;
+DROP TABLE IF EXISTS red_packet_logs_01;
+DROP TABLE IF EXISTS red_packet_logs_02;
+DROP TABLE IF EXISTS red_packet_logs_03;
+DROP TABLE IF EXISTS red_packet_logs_04;
+DROP TABLE IF EXISTS red_packet_logs_05;
+DROP TABLE IF EXISTS red_packet_logs_06;
+DROP TABLE IF EXISTS red_packet_logs_07;
+DROP TABLE IF EXISTS red_packet_logs_08;
+DROP TABLE IF EXISTS red_packet_logs_09;
+DROP TABLE IF EXISTS red_packet_logs_10;
+DROP TABLE IF EXISTS red_packet_logs_11;
+DROP TABLE IF EXISTS red_packet_logs_12;
+DROP TABLE IF EXISTS red_packet_logs_13;
+DROP TABLE IF EXISTS red_packet_logs_14;
+DROP TABLE IF EXISTS red_packet_logs_15;
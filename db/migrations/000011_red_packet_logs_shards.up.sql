@@ -0,0 +1,37 @@
+-- Shard red_packet_logs by red packet ID (red_packet_id % 16), see db.LogTable.
+-- Shards copy the columns and indexes of red_packet_logs.
+-- Each shard allocates IDs from its own range (shard * 10^12, 16 * 10^12 for shard 00), keeping
+-- IDs unique across shards for history pagination. Existing rows are copied with their IDs,
+-- which stay below 10^12, by `server-api shard-logs`.
+CREATE TABLE red_packet_logs_00 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_00 AUTO_INCREMENT = 16000000000000;
+CREATE TABLE red_packet_logs_01 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_01 AUTO_INCREMENT = 1000000000000;
+CREATE TABLE red_packet_logs_02 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_02 AUTO_INCREMENT = 2000000000000;
+CREATE TABLE red_packet_logs_03 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_03 AUTO_INCREMENT = 3000000000000;
+CREATE TABLE red_packet_logs_04 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_04 AUTO_INCREMENT = 4000000000000;
+CREATE TABLE red_packet_logs_05 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_05 AUTO_INCREMENT = 5000000000000;
+CREATE TABLE red_packet_logs_06 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_06 AUTO_INCREMENT = 6000000000000;
+CREATE TABLE red_packet_logs_07 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_07 AUTO_INCREMENT = 7000000000000;
+CREATE TABLE red_packet_logs_08 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_08 AUTO_INCREMENT = 8000000000000;
+CREATE TABLE red_packet_logs_09 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_09 AUTO_INCREMENT = 9000000000000;
+CREATE TABLE red_packet_logs_10 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_10 AUTO_INCREMENT = 10000000000000;
+CREATE TABLE red_packet_logs_11 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_11 AUTO_INCREMENT = 11000000000000;
+CREATE TABLE red_packet_logs_12 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_12 AUTO_INCREMENT = 12000000000000;
+CREATE TABLE red_packet_logs_13 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_13 AUTO_INCREMENT = 13000000000000;
+CREATE TABLE red_packet_logs_14 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_14 AUTO_INCREMENT = 14000000000000;
+CREATE TABLE red_packet_logs_15 LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_15 AUTO_INCREMENT = 15000000000000;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS archive_checkpoints;
+DROP TABLE IF EXISTS red_packet_logs_archive;
+DROP TABLE IF EXISTS red_packets_archive;
@@ -0,0 +1,14 @@
+-- Cold copies of finished red packets and their claim logs, moved by the archive job
+CREATE TABLE red_packets_archive LIKE red_packets;
+ALTER TABLE red_packets_archive
+    ADD COLUMN archived_at TIMESTAMP NULL DEFAULT NULL COMMENT 'When the archive job moved the red packet',
+    COMMENT = 'Finished red packets moved out of red_packets after the retention window';
+
+CREATE TABLE red_packet_logs_archive LIKE red_packet_logs;
+ALTER TABLE red_packet_logs_archive COMMENT = 'Claim logs of archived red packets, all shards';
+
+CREATE TABLE archive_checkpoints (
+    job VARCHAR(64) PRIMARY KEY COMMENT 'Archive job name',
+    last_id BIGINT NOT NULL DEFAULT 0 COMMENT 'Highest red packet ID the job is done with',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT 'Last update timestamp'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Resume points of archive jobs';
@@ -0,0 +1,2 @@
+ALTER TABLE outbox_events
+    ADD COLUMN trace_context VARCHAR(512) NOT NULL DEFAULT '' COMMENT 'W3C trace context of the writing request, propagated in the Kafka headers' AFTER content_type;
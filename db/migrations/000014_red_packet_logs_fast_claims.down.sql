@@ -0,0 +1,5 @@
+{{range .ClaimLogTables}}
+ALTER TABLE {{.}}
+    DROP INDEX uk_red_packet_logs_fast_claim,
+    DROP COLUMN claim_user_id;
+{{end}}
@@ -0,0 +1,21 @@
+-- One claim per user and red packet applies to fast mode only: strict mode packets may be grabbed
+-- repeatedly by a user. claim_user_id is set on fast mode claims, where the unique key makes the
+-- asynchronous persistence idempotent, and NULL on strict grabs.
+-- The unique (red_packet_id, user_id) key added by earlier versions of migration 000006, and copied
+-- to the shards by 000011, is dropped where it exists.
+{{range .ClaimLogTables}}
+SET @drop_index = (SELECT IF(COUNT(*) > 0, 'ALTER TABLE {{.}} DROP INDEX uk_red_packet_logs_red_packet_user', 'DO 0')
+    FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = '{{.}}' AND index_name = 'uk_red_packet_logs_red_packet_user');
+PREPARE drop_index FROM @drop_index;
+EXECUTE drop_index;
+DEALLOCATE PREPARE drop_index;
+ALTER TABLE {{.}}
+    ADD COLUMN claim_user_id BIGINT NULL DEFAULT NULL COMMENT 'User ID of a fast mode claim, NULL for strict mode grabs' AFTER red_packet_id,
+    ADD UNIQUE INDEX uk_red_packet_logs_fast_claim (red_packet_id, claim_user_id);
+{{end}}
+-- Existing claims of fast mode packets
+{{range .HotLogTables}}
+UPDATE {{.}} l JOIN red_packets p ON p.id = l.red_packet_id SET l.claim_user_id = l.user_id WHERE p.grab_mode = 'fast';
+{{end}}
+UPDATE red_packet_logs_archive l JOIN red_packets_archive p ON p.id = l.red_packet_id SET l.claim_user_id = l.user_id WHERE p.grab_mode = 'fast';
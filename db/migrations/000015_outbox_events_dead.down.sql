@@ -0,0 +1,7 @@
+DROP INDEX idx_outbox_events_status_sent_at ON outbox_events;
+
+-- Dead events go back to pending, the relay retries them
+UPDATE outbox_events SET status = 0 WHERE status = 2;
+
+ALTER TABLE outbox_events
+    MODIFY status TINYINT NOT NULL DEFAULT 0 COMMENT '0: Pending, 1: Sent';
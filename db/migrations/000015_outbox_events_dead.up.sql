@@ -0,0 +1,5 @@
+ALTER TABLE outbox_events
+    MODIFY status TINYINT NOT NULL DEFAULT 0 COMMENT '0: Pending, 1: Sent, 2: Dead';
+
+-- The relay purges sent events past OUTBOX_RETENTION
+CREATE INDEX idx_outbox_events_status_sent_at ON outbox_events (status, sent_at);
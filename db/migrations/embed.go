@@ -0,0 +1,9 @@
+// Package migrations embeds the versioned SQL schema migrations.
+// Files are named `<version>_<name>.up.sql` / `<version>_<name>.down.sql` and are text/templates
+// executed with the claim log tables, see db.LoadMigrations.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"sync"
 
@@ -23,50 +24,93 @@ func InitDB(cfg *config.Config) error {
 
 	once.Do(func() {
 		// Debug logs to verify connection details
-		log.Printf("Connecting to MySQL Master: %s", cfg.DBMaster)
-		log.Printf("Connecting to MySQL Slave: %s", cfg.DBSlave)
-
-		// MySQL Master DSN (Write)
-		dsnMaster := fmt.Sprintf(
-			"%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local&allowNativePasswords=true",
-			cfg.DBUser, cfg.DBPassword, cfg.DBMaster, cfg.DBName,
-		)
-
-		// MySQL Slave DSN (Read)
-		dsnSlave := fmt.Sprintf(
-			"%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local&allowNativePasswords=true",
-			cfg.DBUser, cfg.DBPassword, cfg.DBSlave, cfg.DBName,
-		)
-
-		// Connect to MySQL Master
-		DB, err = gorm.Open(mysql.Open(dsnMaster), &gorm.Config{})
+		log.Info("Connecting to MySQL Master", "addr", cfg.DBMaster)
+
+		// Connect to MySQL Master (Write)
+		DB, err = gorm.Open(mysql.Open(mysqlDSN(cfg, cfg.DBMaster)), &gorm.Config{
+			TranslateError: true, // Expose gorm.ErrDuplicatedKey for idempotent inserts
+		})
 		if err != nil {
-			log.Fatalf("Failed to connect to MySQL Master at %s: %v", cfg.DBMaster, err)
+			logger.Fatal("Failed to connect to MySQL Master", "addr", cfg.DBMaster, "error", err)
+		}
+		if masterPool, err = DB.DB(); err != nil {
+			logger.Fatal("Failed to get MySQL Master pool", "error", err)
+		}
+		configurePool(masterPool, cfg)
+
+		// Open one pool per replica (Read), so the policy and health checks can address each one
+		var dialectors []gorm.Dialector
+		for _, replicaCfg := range cfg.DBReplicas {
+			log.Info("Connecting to MySQL Replica", "addr", replicaCfg.Addr, "weight", replicaCfg.Weight)
+			pool, openErr := sql.Open("mysql", mysqlDSN(cfg, replicaCfg.Addr))
+			if openErr != nil {
+				logger.Fatal("Failed to open MySQL Replica", "addr", replicaCfg.Addr, "error", openErr)
+			}
+			configurePool(pool, cfg)
+			replicas = append(replicas, &replica{addr: replicaCfg.Addr, weight: replicaCfg.Weight, pool: pool})
+			dialectors = append(dialectors, mysql.New(mysql.Config{Conn: pool}))
+		}
+
+		policy, policyErr := newReplicaPolicy(cfg.DBReplicaPolicy)
+		if policyErr != nil {
+			logger.Fatal("Invalid DB_REPLICA_POLICY", "error", policyErr)
 		}
 
-		// Configure read/write separation
+		// Configure read/write separation, the master pool is the only source
 		err = DB.Use(dbresolver.Register(dbresolver.Config{
-			Sources:  []gorm.Dialector{mysql.Open(dsnMaster)}, // Master (Write)
-			Replicas: []gorm.Dialector{mysql.Open(dsnSlave)},  // Slave (Read)
-			Policy:   dbresolver.RandomPolicy{},               // Load balancing policy
+			Replicas: dialectors,
+			Policy:   policy,
 		}))
 
 		if err != nil {
-			log.Fatalf("Failed to set up read/write splitting: %v", err)
+			logger.Fatal("Failed to set up read/write splitting", "error", err)
+		}
+
+		// Route pinned requests and reads without a usable replica to the master
+		if err = registerRoutingCallbacks(DB); err != nil {
+			logger.Fatal("Failed to set up read-your-writes routing", "error", err)
+		}
+		SetSessionPinWindow(cfg.ReadYourWritesWindow)
+
+		// Time every statement and expose the pool statistics on /metrics
+		if err = registerMetricsCallbacks(DB); err != nil {
+			logger.Fatal("Failed to set up query metrics", "error", err)
 		}
+		registerPoolMetrics(masterPool)
 
-		log.Println("MySQL Read/Write Splitting Configured")
+		// Trace statements made within a request or consumed event
+		if err = registerTracingCallbacks(DB); err != nil {
+			logger.Fatal("Failed to set up query tracing", "error", err)
+		}
+
+		log.Info("MySQL read/write splitting configured", "replicas", len(replicas), "policy", policy.name)
 	})
 
 	return err
 }
 
+// mysqlDSN builds the DSN of a MySQL server
+func mysqlDSN(cfg *config.Config, addr string) string {
+	return fmt.Sprintf(
+		"%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local&allowNativePasswords=true",
+		cfg.DBUser, cfg.DBPassword, addr, cfg.DBName,
+	)
+}
+
+// configurePool applies the connection pool settings
+func configurePool(pool *sql.DB, cfg *config.Config) {
+	pool.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	pool.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	pool.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+}
+
 // GetDB returns the MySQL connection (Singleton)
 func GetDB() *gorm.DB {
 	log := logger.GetLogger()
 
 	if DB == nil {
-		log.Println("MySQL is not initialized, please run InitDB() first")
+		log.Error("MySQL is not initialized, please run InitDB() first")
 	}
 	return DB
 }
@@ -88,6 +132,12 @@ func CloseDB() error {
 		return fmt.Errorf("Failed to close database: %v", err)
 	}
 
-	log.Println("MySQL connection closed")
+	for _, r := range replicas {
+		if err := r.pool.Close(); err != nil {
+			return fmt.Errorf("Failed to close replica %s: %v", r.addr, err)
+		}
+	}
+
+	log.Info("MySQL connection closed")
 	return nil
 }
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/pkg/logger"
+)
+
+// Replica load-balancing policies
+const (
+	ReplicaPolicyRandom     = "random"      // Weighted random
+	ReplicaPolicyRoundRobin = "round_robin" // Smooth weighted round-robin
+	ReplicaPolicyLeastConn  = "least_conn"  // Fewest in-use connections per weight
+)
+
+// replicaPingTimeout bounds a single replica health check
+const replicaPingTimeout = 2 * time.Second
+
+// replica is one read replica connection pool and its routing state
+type replica struct {
+	addr    string
+	weight  int
+	pool    *sql.DB
+	down    atomic.Bool // Ejected by the health check
+	lagging atomic.Bool // Behind the master more than the allowed lag
+	current int         // Smooth weighted round-robin state, guarded by replicaPolicy.mu
+}
+
+// usable reports whether reads may be routed to the replica
+func (r *replica) usable() bool {
+	return !r.down.Load() && !r.lagging.Load()
+}
+
+var (
+	replicas   []*replica
+	masterPool *sql.DB
+)
+
+// ReplicaStatus describes the routing state of a read replica
+type ReplicaStatus struct {
+	Addr    string
+	Weight  int
+	Down    bool
+	Lagging bool
+	InUse   int
+}
+
+// ReplicaStatuses returns the routing state of every configured replica
+func ReplicaStatuses() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(replicas))
+	for _, r := range replicas {
+		statuses = append(statuses, ReplicaStatus{
+			Addr:    r.addr,
+			Weight:  r.weight,
+			Down:    r.down.Load(),
+			Lagging: r.lagging.Load(),
+			InUse:   r.pool.Stats().InUse,
+		})
+	}
+	return statuses
+}
+
+// replicaAvailable reports whether at least one replica may serve reads
+func replicaAvailable() bool {
+	for _, r := range replicas {
+		if r.usable() {
+			return true
+		}
+	}
+	return false
+}
+
+// replicaPolicy picks a usable replica, falling back to the master when none is left.
+// dbresolver skips the policy with a single replica, the routing callback covers that case.
+type replicaPolicy struct {
+	name   string
+	mu     sync.Mutex
+	byPool map[gorm.ConnPool]*replica
+}
+
+// newReplicaPolicy returns the dbresolver policy for name (random when empty)
+func newReplicaPolicy(name string) (*replicaPolicy, error) {
+	switch name {
+	case "":
+		name = ReplicaPolicyRandom
+	case ReplicaPolicyRandom, ReplicaPolicyRoundRobin, ReplicaPolicyLeastConn:
+	default:
+		return nil, fmt.Errorf("unknown replica policy %q", name)
+	}
+
+	policy := &replicaPolicy{name: name, byPool: map[gorm.ConnPool]*replica{}}
+	for _, r := range replicas {
+		policy.byPool[r.pool] = r
+	}
+	return policy, nil
+}
+
+// Resolve implements dbresolver.Policy
+func (p *replicaPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	candidates := make([]*replica, 0, len(connPools))
+	totalWeight := 0
+	for _, connPool := range connPools {
+		if r, ok := p.byPool[connPool]; ok && r.usable() {
+			candidates = append(candidates, r)
+			totalWeight += r.weight
+		}
+	}
+	if len(candidates) == 0 {
+		return masterPool
+	}
+
+	switch p.name {
+	case ReplicaPolicyRoundRobin:
+		// Smooth weighted round-robin (as in nginx): spreads heavier replicas evenly
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		var best *replica
+		for _, r := range candidates {
+			r.current += r.weight
+			if best == nil || r.current > best.current {
+				best = r
+			}
+		}
+		best.current -= totalWeight
+		return best.pool
+
+	case ReplicaPolicyLeastConn:
+		best := candidates[0]
+		for _, r := range candidates[1:] {
+			// Compare InUse/weight without dividing
+			if r.pool.Stats().InUse*best.weight < best.pool.Stats().InUse*r.weight {
+				best = r
+			}
+		}
+		return best.pool
+
+	default:
+		n := rand.Intn(totalWeight)
+		for _, r := range candidates {
+			if n -= r.weight; n < 0 {
+				return r.pool
+			}
+		}
+		return candidates[len(candidates)-1].pool
+	}
+}
+
+var _ dbresolver.Policy = (*replicaPolicy)(nil)
+
+// StartReplicaHealthCheck pings every replica each interval until ctx is cancelled.
+// A failing replica is ejected from the read rotation until it answers again.
+func StartReplicaHealthCheck(ctx context.Context, interval time.Duration) {
+	log := logger.GetLogger()
+	log.Info("Replica health check started", "interval", interval, "replicas", len(replicas))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Replica health check stopped")
+			return
+		case <-ticker.C:
+			checkReplicaHealth(ctx)
+		}
+	}
+}
+
+// checkReplicaHealth pings every replica once, ejecting the failing ones and readmitting the others
+func checkReplicaHealth(ctx context.Context) {
+	log := logger.GetLogger()
+	for _, r := range replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, replicaPingTimeout)
+		err := r.pool.PingContext(pingCtx)
+		cancel()
+
+		down := err != nil
+		if down != r.down.Swap(down) {
+			if down {
+				log.Warn("Replica failed its health check, ejected", "replica", r.addr, "error", err)
+			} else {
+				log.Info("Replica is healthy again, back in rotation", "replica", r.addr)
+			}
+		}
+	}
+}
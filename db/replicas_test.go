@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeReplica is a database/sql connector whose pings and replication status are set by the test
+type fakeReplica struct {
+	mu     sync.Mutex
+	down   bool
+	status []string // Seconds_Behind_Master per status row, "" for NULL (SQL thread stopped)
+}
+
+func (f *fakeReplica) set(down bool, status []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down, f.status = down, status
+}
+
+func (f *fakeReplica) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeReplica) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ replica *fakeReplica }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeConn) Ping(context.Context) error {
+	c.replica.mu.Lock()
+	defer c.replica.mu.Unlock()
+	if c.replica.down {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+// QueryContext answers SHOW SLAVE STATUS with the Seconds_Behind_Master set by the test
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.replica.mu.Lock()
+	defer c.replica.mu.Unlock()
+	rows := &fakeRows{columns: []string{"Slave_IO_State", "Seconds_Behind_Master"}}
+	for _, seconds := range c.replica.status {
+		var value driver.Value
+		if seconds != "" {
+			value = []byte(seconds)
+		}
+		rows.values = append(rows.values, []driver.Value{[]byte("Waiting for source"), value})
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestReplicaLag(t *testing.T) {
+	fake := &fakeReplica{}
+	pool := sql.OpenDB(fake)
+	t.Cleanup(func() { pool.Close() })
+
+	tests := []struct {
+		name    string
+		status  []string
+		lag     time.Duration
+		ok      bool
+		wantErr bool
+	}{
+		{name: "replicating", status: []string{"3"}, lag: 3 * time.Second, ok: true},
+		{name: "sql thread stopped", status: []string{""}},
+		{name: "not a replica"},
+		{name: "garbage", status: []string{"soon"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		fake.set(false, tt.status)
+		lag, ok, err := replicaLag(context.Background(), pool)
+		if (err != nil) != tt.wantErr || lag != tt.lag || ok != tt.ok {
+			t.Errorf("%s: got lag %v, ok %v, error %v", tt.name, lag, ok, err)
+		}
+	}
+}
+
+func TestCheckReplicaLagEjectsLaggingReplicas(t *testing.T) {
+	fake := &fakeReplica{}
+	pool := sql.OpenDB(fake)
+	t.Cleanup(func() { pool.Close() })
+	r := useReplicas(t, nil, pool)[0]
+	SetReplicaMaxLag(2 * time.Second)
+	t.Cleanup(func() { SetReplicaMaxLag(0) })
+
+	steps := []struct {
+		name    string
+		status  []string
+		down    bool
+		lagging bool
+	}{
+		{name: "in sync", status: []string{"0"}},
+		{name: "behind", status: []string{"5"}, lagging: true},
+		{name: "caught up", status: []string{"2"}},
+		{name: "replication stopped", status: []string{""}, lagging: true},
+		{name: "replication restarted", status: []string{"1"}},
+		// Ejected replicas are left to the health check
+		{name: "ejected", status: []string{"9"}, down: true},
+	}
+	for _, step := range steps {
+		fake.set(false, step.status)
+		r.down.Store(step.down)
+		checkReplicaLag(context.Background())
+		if r.lagging.Load() != step.lagging {
+			t.Errorf("%s: lagging is %v, want %v", step.name, r.lagging.Load(), step.lagging)
+		}
+	}
+}
+
+func TestCheckReplicaHealthEjectsFailingReplicas(t *testing.T) {
+	healthy, failing := &fakeReplica{}, &fakeReplica{down: true}
+	healthyPool, failingPool := sql.OpenDB(healthy), sql.OpenDB(failing)
+	t.Cleanup(func() { healthyPool.Close(); failingPool.Close() })
+	configured := useReplicas(t, nil, healthyPool, failingPool)
+
+	checkReplicaHealth(context.Background())
+	if configured[0].down.Load() || !configured[1].down.Load() {
+		t.Fatalf("down states are %v and %v, want the failing replica ejected", configured[0].down.Load(), configured[1].down.Load())
+	}
+	if ReplicaFallback() {
+		t.Fatal("reads fall back to the master although a replica is healthy")
+	}
+
+	// Both down, then the failing one answers again
+	healthy.set(true, nil)
+	checkReplicaHealth(context.Background())
+	if !ReplicaFallback() {
+		t.Fatal("reads stay on the replicas although every replica is down")
+	}
+	failing.set(false, nil)
+	checkReplicaHealth(context.Background())
+	if !configured[0].down.Load() || configured[1].down.Load() {
+		t.Fatalf("down states are %v and %v, want the recovered replica readmitted", configured[0].down.Load(), configured[1].down.Load())
+	}
+}
+
+func TestReplicaPolicySkipsUnusableReplicas(t *testing.T) {
+	pools := []*sql.DB{sql.OpenDB(&fakeReplica{}), sql.OpenDB(&fakeReplica{}), sql.OpenDB(&fakeReplica{})}
+	master := sql.OpenDB(&fakeReplica{})
+	t.Cleanup(func() {
+		for _, pool := range append(pools, master) {
+			pool.Close()
+		}
+	})
+	configured := useReplicas(t, master, pools...)
+	connPools := []gorm.ConnPool{pools[0], pools[1], pools[2]}
+
+	for _, name := range []string{ReplicaPolicyRandom, ReplicaPolicyRoundRobin, ReplicaPolicyLeastConn} {
+		t.Run(name, func(t *testing.T) {
+			policy, err := newReplicaPolicy(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			configured[0].down.Store(true)
+			configured[1].lagging.Store(true)
+			t.Cleanup(func() {
+				configured[0].down.Store(false)
+				configured[1].lagging.Store(false)
+			})
+
+			for i := 0; i < 20; i++ {
+				if got := policy.Resolve(connPools); got != pools[2] {
+					t.Fatalf("resolved to %p, want the only usable replica %p", got, pools[2])
+				}
+			}
+			configured[2].down.Store(true)
+			defer configured[2].down.Store(false)
+			if got := policy.Resolve(connPools); got != master {
+				t.Fatalf("resolved to %p without a usable replica, want the master", got)
+			}
+		})
+	}
+
+	if _, err := newReplicaPolicy("fastest"); err == nil {
+		t.Fatal("unknown replica policy accepted")
+	}
+}
+
+func TestReplicaPolicyWeights(t *testing.T) {
+	light, heavy := sql.OpenDB(&fakeReplica{}), sql.OpenDB(&fakeReplica{})
+	t.Cleanup(func() { light.Close(); heavy.Close() })
+	configured := useReplicas(t, nil, light, heavy)
+	configured[1].weight = 2
+	connPools := []gorm.ConnPool{light, heavy}
+
+	// Smooth weighted round-robin interleaves the heavier replica
+	policy, _ := newReplicaPolicy(ReplicaPolicyRoundRobin)
+	var got []gorm.ConnPool
+	for i := 0; i < 6; i++ {
+		got = append(got, policy.Resolve(connPools))
+	}
+	want := []gorm.ConnPool{heavy, light, heavy, heavy, light, heavy}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("round-robin pick %d went to the wrong replica", i)
+		}
+	}
+
+	// Least connections weighs in-use connections by replica weight
+	policy, _ = newReplicaPolicy(ReplicaPolicyLeastConn)
+	conn, err := heavy.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := policy.Resolve(connPools); got != light {
+		t.Fatal("least connections picked the replica with a connection in use")
+	}
+	lightConn, err := light.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lightConn.Close()
+	if got := policy.Resolve(connPools); got != heavy {
+		t.Fatal("least connections ignored the weight of the heavier replica")
+	}
+}
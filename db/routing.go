@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/redisclient"
+)
+
+type routingKey int
+
+// masterPinKey marks a context whose reads go to the master
+const masterPinKey routingKey = 0
+
+// sessionPinWindow is how long a user's reads stay on the master after a write (0 disables)
+var sessionPinWindow atomic.Int64
+
+// WithMaster pins every read made with the returned context to the master
+func WithMaster(ctx context.Context) context.Context {
+	return context.WithValue(ctx, masterPinKey, true)
+}
+
+// PinnedToMaster reports whether reads made with ctx go to the master
+func PinnedToMaster(ctx context.Context) bool {
+	pinned, _ := ctx.Value(masterPinKey).(bool)
+	return pinned
+}
+
+// SetSessionPinWindow configures how long PinSession keeps a user on the master
+func SetSessionPinWindow(window time.Duration) {
+	sessionPinWindow.Store(int64(window))
+}
+
+// sessionPinKey stores the pin of a user session in Redis, shared by all API instances
+func sessionPinKey(userID uint) string {
+	return fmt.Sprintf("db:pin:user:%d", userID)
+}
+
+// PinSession routes the reads of userID to the master for the session pin window.
+// Call it after a committed write so the user reads their own writes despite replica lag.
+func PinSession(ctx context.Context, userID uint) {
+	if sessionPinWindow.Load() <= 0 {
+		return
+	}
+	pinSession(ctx, redisclient.GetRedisClient(), userID)
+}
+
+func pinSession(ctx context.Context, redisClient *redis.ClusterClient, userID uint) {
+	window := time.Duration(sessionPinWindow.Load())
+	if window <= 0 {
+		return
+	}
+	if err := redisClient.Set(ctx, sessionPinKey(userID), 1, window).Err(); err != nil {
+		logger.GetLogger().WarnContext(ctx, "Failed to pin session to master", "user_id", userID, "error", err)
+	}
+}
+
+// WithSession returns ctx pinned to the master if userID wrote within the session pin window
+func WithSession(ctx context.Context, userID uint) context.Context {
+	if sessionPinWindow.Load() <= 0 || PinnedToMaster(ctx) {
+		return ctx
+	}
+	return withSession(ctx, redisclient.GetRedisClient(), userID)
+}
+
+func withSession(ctx context.Context, redisClient *redis.ClusterClient, userID uint) context.Context {
+	if sessionPinWindow.Load() <= 0 || PinnedToMaster(ctx) {
+		return ctx
+	}
+	pinned, err := redisClient.Exists(ctx, sessionPinKey(userID)).Result()
+	if err != nil || pinned > 0 {
+		return WithMaster(ctx) // Unknown pin state, prefer a consistent read
+	}
+	return ctx
+}
+
+// ReplicaFallback reports whether reads currently fall back to the master because every
+// replica is lagging or ejected
+func ReplicaFallback() bool {
+	return !replicaAvailable()
+}
+
+// registerRoutingCallbacks sends reads to the master when the request is pinned or no
+// replica is usable. Registered after dbresolver with Before("*"), it runs before dbresolver picks
+// a connection pool (a Before("gorm:db_resolver") constraint conflicts with dbresolver's own).
+func registerRoutingCallbacks(db *gorm.DB) error {
+	route := func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		if (ctx != nil && PinnedToMaster(ctx)) || !replicaAvailable() {
+			dbresolver.Write.ModifyStatement(tx.Statement)
+		}
+	}
+
+	if err := db.Callback().Query().Before("*").Register("red_packet:read_your_writes", route); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("*").Register("red_packet:read_your_writes", route); err != nil {
+		return err
+	}
+	return db.Callback().Raw().Before("*").Register("red_packet:read_your_writes", route)
+}
+
+// replicaLag returns the replication delay reported by a replica.
+// ok is false when replication is stopped or the server is not a replica.
+func replicaLag(ctx context.Context, pool *sql.DB) (lag time.Duration, ok bool, err error) {
+	rows, err := pool.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, false, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, false, err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, false, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, false, nil // NULL: the SQL thread is not running
+		}
+		seconds, err := strconv.Atoi(string(values[i]))
+		if err != nil {
+			return 0, false, err
+		}
+		return time.Duration(seconds) * time.Second, true, nil
+	}
+	return 0, false, nil
+}
+
+// replicaMaxLag is the lag above which a replica leaves the read rotation
+var replicaMaxLag atomic.Int64
+
+// SetReplicaMaxLag changes the lag tolerated by a running lag monitor
+func SetReplicaMaxLag(maxLag time.Duration) {
+	replicaMaxLag.Store(int64(maxLag))
+}
+
+// StartReplicaLagMonitor checks the lag of every replica each interval until ctx is cancelled.
+// A replica lagging more than maxLag (see SetReplicaMaxLag), or with broken replication, is taken out of the read
+// rotation; reads fall back to the master once no replica is left.
+func StartReplicaLagMonitor(ctx context.Context, interval, maxLag time.Duration) {
+	log := logger.GetLogger()
+	log.Info("Replica lag monitor started", "interval", interval, "max_lag", maxLag)
+	SetReplicaMaxLag(maxLag)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fallback := false
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Replica lag monitor stopped")
+			return
+		case <-ticker.C:
+			checkReplicaLag(ctx)
+			if ReplicaFallback() != fallback {
+				if fallback = ReplicaFallback(); fallback {
+					log.Warn("No usable replica, reads fall back to master")
+				}
+			}
+		}
+	}
+}
+
+// checkReplicaLag takes the replicas lagging more than replicaMaxLag, or not replicating,
+// out of the read rotation and puts the ones that caught up back
+func checkReplicaLag(ctx context.Context) {
+	log := logger.GetLogger()
+	for _, r := range replicas {
+		if r.down.Load() {
+			continue // The health check owns ejected replicas
+		}
+
+		lag, ok, err := replicaLag(ctx, r.pool)
+		lagging := err != nil || !ok || lag > time.Duration(replicaMaxLag.Load())
+
+		if lagging != r.lagging.Swap(lagging) {
+			if lagging {
+				log.Warn("Replica lagging, out of read rotation", "replica", r.addr, "lag", lag, "replicating", ok, "error", err)
+			} else {
+				log.Info("Replica caught up, back in read rotation", "replica", r.addr, "lag", lag)
+			}
+		}
+	}
+}
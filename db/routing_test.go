@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
+)
+
+// openNode opens a SQLite database whose nodes table holds its name, so reads tell where they went
+func openNode(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	pool, err := sql.Open(sqlite.DriverName, filepath.Join(t.TempDir(), name+".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	if _, err := pool.Exec("CREATE TABLE nodes (name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec("INSERT INTO nodes (name) VALUES (?)", name); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// useReplicas makes pools the configured replicas and master for the duration of the test
+func useReplicas(t *testing.T, master *sql.DB, pools ...*sql.DB) []*replica {
+	t.Helper()
+	previousReplicas, previousMaster := replicas, masterPool
+	t.Cleanup(func() { replicas, masterPool = previousReplicas, previousMaster })
+
+	replicas = nil
+	for i, pool := range pools {
+		replicas = append(replicas, &replica{addr: fmt.Sprintf("replica-%d", i), weight: 1, pool: pool})
+	}
+	masterPool = master
+	return replicas
+}
+
+// openRoutedDB routes reads between a master and a replica database the way InitDB does
+func openRoutedDB(t *testing.T) (*gorm.DB, *replica) {
+	t.Helper()
+	masterNode, replicaNode := openNode(t, "master"), openNode(t, "replica")
+	configured := useReplicas(t, masterNode, replicaNode)
+
+	routed, err := gorm.Open(sqlite.Dialector{Conn: masterNode}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := newReplicaPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := routed.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{sqlite.Dialector{Conn: replicaNode}},
+		Policy:   policy,
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerRoutingCallbacks(routed); err != nil {
+		t.Fatal(err)
+	}
+	return routed, configured[0]
+}
+
+// readNode returns the name of the database a query and a raw read were served by
+func readNode(t *testing.T, tx *gorm.DB) string {
+	t.Helper()
+	var queried []string
+	if err := tx.Table("nodes").Pluck("name", &queried).Error; err != nil {
+		t.Fatal(err)
+	}
+	var raw string
+	if err := tx.Raw("SELECT name FROM nodes").Scan(&raw).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(queried) != 1 || queried[0] != raw {
+		t.Fatalf("query read %v, raw read %q", queried, raw)
+	}
+	return raw
+}
+
+func TestRoutingPinnedReadsGoToMaster(t *testing.T) {
+	routed, _ := openRoutedDB(t)
+	ctx := context.Background()
+
+	if node := readNode(t, routed.WithContext(ctx)); node != "replica" {
+		t.Fatalf("read went to %s, want replica", node)
+	}
+	if node := readNode(t, routed.WithContext(WithMaster(ctx))); node != "master" {
+		t.Fatalf("pinned read went to %s, want master", node)
+	}
+	if node := readNode(t, routed.WithContext(ctx).Clauses(dbresolver.Write)); node != "master" {
+		t.Fatalf("read with the write clause went to %s, want master", node)
+	}
+}
+
+func TestRoutingFallsBackWithoutUsableReplica(t *testing.T) {
+	routed, configured := openRoutedDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		down, lagging bool
+		want          string
+	}{
+		{name: "usable", want: "replica"},
+		{name: "lagging", lagging: true, want: "master"},
+		{name: "ejected", down: true, want: "master"},
+		{name: "recovered", want: "replica"},
+	}
+	for _, tt := range tests {
+		configured.down.Store(tt.down)
+		configured.lagging.Store(tt.lagging)
+		if fallback := ReplicaFallback(); fallback != (tt.want == "master") {
+			t.Errorf("%s: ReplicaFallback is %v", tt.name, fallback)
+		}
+		if node := readNode(t, routed.WithContext(ctx)); node != tt.want {
+			t.Errorf("%s: read went to %s, want %s", tt.name, node, tt.want)
+		}
+	}
+}
+
+func TestSessionPinning(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{server.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	t.Cleanup(func() { SetSessionPinWindow(0) })
+	ctx := context.Background()
+
+	// Disabled: writes leave no pin
+	SetSessionPinWindow(0)
+	pinSession(ctx, client, 7)
+	if server.Exists(sessionPinKey(7)) {
+		t.Fatal("session pinned with a zero window")
+	}
+
+	SetSessionPinWindow(5 * time.Second)
+	if PinnedToMaster(withSession(ctx, client, 7)) {
+		t.Fatal("user pinned before writing")
+	}
+	pinSession(ctx, client, 7)
+	if !PinnedToMaster(withSession(ctx, client, 7)) {
+		t.Fatal("user not pinned after writing")
+	}
+	if PinnedToMaster(withSession(ctx, client, 8)) {
+		t.Fatal("pin of user 7 applied to user 8")
+	}
+
+	// The pin lasts for the window only
+	server.FastForward(6 * time.Second)
+	if PinnedToMaster(withSession(ctx, client, 7)) {
+		t.Fatal("user still pinned after the window")
+	}
+
+	// Without Redis the pin state is unknown, reads stay consistent on the master
+	server.Close()
+	if !PinnedToMaster(withSession(ctx, client, 7)) {
+		t.Fatal("read not pinned while Redis is unreachable")
+	}
+}
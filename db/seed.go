@@ -1,10 +1,10 @@
 package db
 
 import (
-	"fmt"
-	"log"
 	"math/rand"
+	"red-packet-system/config"
 	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
 
 	"github.com/bxcodec/faker/v3"
 )
@@ -19,13 +19,17 @@ func SeedUsers(count int) {
 			Balance:  float64(rand.Intn(1000)) + rand.Float64(),
 		}
 		db.Create(&user)
-		fmt.Printf("Inserted User: %s with Balance: %.2f\n", user.Username, user.Balance)
+		logger.GetLogger().Info("Inserted user", "username", user.Username, "balance", user.Balance)
 	}
 }
 
 // SeedRedPackets generate fake red packets
 func SeedRedPackets(count int) {
 	db := GetDB()
+	grabMode := config.LoadConfig().DefaultGrabMode
+	if grabMode == "" {
+		grabMode = model.GrabModeStrict
+	}
 
 	for i := 0; i < count; i++ {
 		totalAmount := float64(rand.Intn(500) + 100)
@@ -36,16 +40,18 @@ func SeedRedPackets(count int) {
 			TotalCount:      totalCount,
 			RemainingCount:  totalCount,
 			Status:          1,
+			GrabMode:        grabMode,
 		}
 		db.Create(&redPacket)
-		fmt.Printf("Inserted Red Packet: TotalAmount: %.2f, TotalCount: %d\n", totalAmount, totalCount)
+		logger.GetLogger().Info("Inserted red packet", "total_amount", totalAmount, "total_count", totalCount, "grab_mode", grabMode)
 	}
 }
 
 // RunSeeds execute
 func RunSeeds() {
-	log.Println("Seeding database...")
+	log := logger.GetLogger()
+	log.Info("Seeding database")
 	SeedUsers(10)
 	SeedRedPackets(5)
-	log.Println("Seeding completed!")
+	log.Info("Seeding completed")
 }
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	"red-packet-system/pkg/logger"
+)
+
+// LogShardCount is the number of red_packet_logs shard tables. Changing it needs a migration
+// creating the new tables and moving the rows of every red packet to its new shard.
+const LogShardCount = 16
+
+// LegacyLogTable is the unsharded claim log table, kept until `shard-logs` has copied it
+const LegacyLogTable = "red_packet_logs"
+
+// ArchiveLogTable holds the claim logs of archived red packets, see model.ArchivedRedPacketLog
+const ArchiveLogTable = "red_packet_logs_archive"
+
+// ErrLogsUnsharded is returned while legacy claim logs are missing from their shards
+var ErrLogsUnsharded = errors.New("legacy claim logs are not sharded yet, run `shard-logs`")
+
+// LogTable returns the shard table holding the claim logs of a red packet
+func LogTable(redPacketID uint) string {
+	return fmt.Sprintf("%s_%02d", LegacyLogTable, redPacketID%LogShardCount)
+}
+
+// LogTables returns every shard table
+func LogTables() []string {
+	tables := make([]string, LogShardCount)
+	for i := range tables {
+		tables[i] = LogTable(uint(i))
+	}
+	return tables
+}
+
+// Logs scopes tx to the claim log shard of a red packet
+func Logs(tx *gorm.DB, redPacketID uint) *gorm.DB {
+	return tx.Table(LogTable(redPacketID))
+}
+
+// FanOutLogs runs query on every shard concurrently, for lookups not keyed by red packet
+// (e.g. per-user history). query must be safe for concurrent use; the first error is returned.
+func FanOutLogs(tx *gorm.DB, query func(shard *gorm.DB) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, table := range LogTables() {
+		wg.Add(1)
+		go func(table string) {
+			defer wg.Done()
+			if err := query(tx.Table(table)); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", table, err)
+				}
+				mu.Unlock()
+			}
+		}(table)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// ShardLegacyLogs copies red_packet_logs rows with an ID above fromID into their shards, in
+// batches of batchSize IDs. Rows keep their ID, which lies below every shard range, so history
+// cursors stay valid; rows already in their shard are skipped, so the copy can be resumed or
+// repeated.
+// It returns the last copied legacy ID.
+func ShardLegacyLogs(ctx context.Context, fromID uint64, batchSize int) (uint64, error) {
+	log := logger.GetLogger()
+	dbInstance := GetDB().WithContext(ctx)
+
+	var maxID uint64
+	if err := dbInstance.Table(LegacyLogTable).Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error; err != nil {
+		return fromID, err
+	}
+
+	lastID := fromID
+	for lastID < maxID {
+		batchEnd := lastID + uint64(batchSize)
+		copied := int64(0)
+		for shard := 0; shard < LogShardCount; shard++ {
+			result := dbInstance.Exec(fmt.Sprintf(
+				"INSERT IGNORE INTO %s (id, user_id, red_packet_id, claim_user_id, amount, created_at, updated_at) "+
+					"SELECT id, user_id, red_packet_id, claim_user_id, amount, created_at, updated_at FROM %s "+
+					"WHERE id > ? AND id <= ? AND red_packet_id %% ? = ?",
+				LogTable(uint(shard)), LegacyLogTable),
+				lastID, batchEnd, LogShardCount, shard)
+			if result.Error != nil {
+				return lastID, result.Error
+			}
+			copied += result.RowsAffected
+		}
+
+		lastID = batchEnd
+		if lastID > maxID {
+			lastID = maxID
+		}
+		log.Info("Sharded legacy claim logs", "last_id", lastID, "max_id", maxID, "copied", copied)
+	}
+	return lastID, nil
+}
+
+// CountUnshardedLogs returns the number of legacy rows missing from their shard
+func CountUnshardedLogs(ctx context.Context) (int64, error) {
+	dbInstance := GetDB().WithContext(ctx)
+	if !dbInstance.Migrator().HasTable(LegacyLogTable) {
+		return 0, nil // Dropped after sharding
+	}
+
+	var missing int64
+	for shard := 0; shard < LogShardCount; shard++ {
+		var count int64
+		if err := dbInstance.Raw(fmt.Sprintf(
+			"SELECT COUNT(*) FROM %s l LEFT JOIN %s s ON s.id = l.id "+
+				"WHERE l.red_packet_id %% ? = ? AND s.id IS NULL",
+			LegacyLogTable, LogTable(uint(shard))),
+			LogShardCount, shard).Scan(&count).Error; err != nil {
+			return 0, err
+		}
+		missing += count
+	}
+	return missing, nil
+}
+
+// CheckLogsSharded refuses to start while legacy claim logs are missing from their shards:
+// grabs, the fast mode state, the reconciler and the history would ignore those claims.
+func CheckLogsSharded(ctx context.Context) error {
+	missing, err := CountUnshardedLogs(ctx)
+	if err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("%w (%d rows missing)", ErrLogsUnsharded, missing)
+	}
+	return nil
+}
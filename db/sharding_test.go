@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"red-packet-system/model"
+)
+
+// useShardedDB makes an in-memory SQLite database with the legacy and shard claim log tables
+// the DB singleton for the duration of the test
+func useShardedDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	sqliteDB, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := sqliteDB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetMaxOpenConns(1) // One in-memory database for every statement
+	t.Cleanup(func() { pool.Close() })
+
+	for _, table := range append(LogTables(), LegacyLogTable) {
+		if err := sqliteDB.Table(table).AutoMigrate(&model.RedPacketLog{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// SQLite spells MySQL's INSERT IGNORE as INSERT OR IGNORE
+	if err := sqliteDB.Callback().Raw().Before("gorm:raw").Register("test:insert_ignore", func(tx *gorm.DB) {
+		statement := tx.Statement.SQL.String()
+		if strings.HasPrefix(statement, "INSERT IGNORE ") {
+			tx.Statement.SQL.Reset()
+			tx.Statement.SQL.WriteString("INSERT OR IGNORE " + strings.TrimPrefix(statement, "INSERT IGNORE "))
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := DB
+	DB = sqliteDB
+	t.Cleanup(func() { DB = previous })
+	return sqliteDB
+}
+
+// legacyLog inserts a claim log into the legacy table
+func legacyLog(t *testing.T, tx *gorm.DB, id, redPacketID uint) model.RedPacketLog {
+	t.Helper()
+	logEntry := model.RedPacketLog{ID: id, UserID: id + 100, RedPacketID: redPacketID, Amount: float64(id)}
+	if err := tx.Table(LegacyLogTable).Create(&logEntry).Error; err != nil {
+		t.Fatal(err)
+	}
+	return logEntry
+}
+
+func TestLogTable(t *testing.T) {
+	tests := map[uint]string{
+		0:  "red_packet_logs_00",
+		1:  "red_packet_logs_01",
+		15: "red_packet_logs_15",
+		16: "red_packet_logs_00",
+		33: "red_packet_logs_01",
+	}
+	for redPacketID, want := range tests {
+		if got := LogTable(redPacketID); got != want {
+			t.Errorf("red packet %d is in %s, want %s", redPacketID, got, want)
+		}
+	}
+
+	tables := LogTables()
+	seen := map[string]bool{}
+	for shard, table := range tables {
+		if table != LogTable(uint(shard)) {
+			t.Errorf("shard %d is %s, want %s", shard, table, LogTable(uint(shard)))
+		}
+		seen[table] = true
+	}
+	if len(tables) != LogShardCount || len(seen) != LogShardCount {
+		t.Fatalf("got %d tables, %d distinct, want %d", len(tables), len(seen), LogShardCount)
+	}
+}
+
+func TestLogShardIDRanges(t *testing.T) {
+	list, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := list[10]
+	if shards.Name != "red_packet_logs_shards" {
+		t.Fatalf("expected migration 11 to be red_packet_logs_shards, got %s", shards.Name)
+	}
+
+	// Each shard starts its own 10^12 wide range, above the legacy IDs, so IDs are unique across shards
+	const rangeSize = 1_000_000_000_000
+	autoIncrement := regexp.MustCompile(`ALTER TABLE (\w+) AUTO_INCREMENT = (\d+);`)
+	starts := map[string]uint64{}
+	for _, match := range autoIncrement.FindAllStringSubmatch(shards.up, -1) {
+		start, err := strconv.ParseUint(match[2], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		starts[match[1]] = start
+	}
+	for shard, table := range LogTables() {
+		want := uint64(shard) * rangeSize
+		if shard == 0 {
+			want = LogShardCount * rangeSize
+		}
+		if starts[table] != want {
+			t.Errorf("%s allocates IDs from %d, want %d", table, starts[table], want)
+		}
+	}
+	if len(starts) != LogShardCount {
+		t.Errorf("migration sets the ID range of %d tables, want %d", len(starts), LogShardCount)
+	}
+}
+
+func TestFanOutLogs(t *testing.T) {
+	sqliteDB := useShardedDB(t)
+	for redPacketID := uint(1); redPacketID <= 40; redPacketID++ {
+		logEntry := model.RedPacketLog{UserID: 7, RedPacketID: redPacketID, Amount: 1}
+		if err := Logs(sqliteDB, redPacketID).Create(&logEntry).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		tables = map[string]bool{}
+		total  int64
+	)
+	err := FanOutLogs(sqliteDB, func(shard *gorm.DB) error {
+		var count int64
+		if err := shard.Where("user_id = ?", 7).Count(&count).Error; err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		tables[shard.Statement.Table] = true
+		total += count
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != LogShardCount || total != 40 {
+		t.Fatalf("visited %d shards and counted %d logs, want %d shards and 40 logs", len(tables), total, LogShardCount)
+	}
+
+	// The failing shard is named in the error
+	failure := errors.New("shard offline")
+	err = FanOutLogs(sqliteDB, func(shard *gorm.DB) error {
+		if shard.Statement.Table == LogTable(5) {
+			return failure
+		}
+		return nil
+	})
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), LogTable(5)) {
+		t.Fatalf("got %v, want the failure of %s", err, LogTable(5))
+	}
+}
+
+func TestShardLegacyLogs(t *testing.T) {
+	sqliteDB := useShardedDB(t)
+	ctx := context.Background()
+	var legacy []model.RedPacketLog
+	for id := uint(1); id <= 5; id++ {
+		legacy = append(legacy, legacyLog(t, sqliteDB, id, id*7))
+	}
+	// Already copied by an interrupted run
+	if err := Logs(sqliteDB, legacy[1].RedPacketID).Create(&legacy[1]).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckLogsSharded(ctx); !errors.Is(err, ErrLogsUnsharded) || !strings.Contains(err.Error(), "4 rows missing") {
+		t.Fatalf("got %v, want ErrLogsUnsharded with 4 rows missing", err)
+	}
+
+	// Copied in batches of 2 IDs, then repeated from a checkpoint without duplicating rows
+	lastID, err := ShardLegacyLogs(ctx, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != 5 {
+		t.Fatalf("last copied ID is %d, want 5", lastID)
+	}
+	if _, err := ShardLegacyLogs(ctx, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, logEntry := range legacy {
+		var copied []model.RedPacketLog
+		if err := Logs(sqliteDB, logEntry.RedPacketID).Where("red_packet_id = ?", logEntry.RedPacketID).Find(&copied).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(copied) != 1 || copied[0].ID != logEntry.ID || copied[0].UserID != logEntry.UserID {
+			t.Fatalf("%s holds %+v for red packet %d, want the legacy row %d once", LogTable(logEntry.RedPacketID), copied, logEntry.RedPacketID, logEntry.ID)
+		}
+	}
+	if err := CheckLogsSharded(ctx); err != nil {
+		t.Fatalf("check failed after sharding: %v", err)
+	}
+
+	// Dropping the legacy table after sharding is fine
+	if err := sqliteDB.Migrator().DropTable(LegacyLogTable); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckLogsSharded(ctx); err != nil {
+		t.Fatalf("check failed without the legacy table: %v", err)
+	}
+}
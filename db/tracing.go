@@ -0,0 +1,44 @@
+package db
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"red-packet-system/pkg/tracing"
+)
+
+// querySpanKey stores the statement span in the GORM instance
+const querySpanKey = "red_packet:query_span"
+
+// registerTracingCallbacks wraps every GORM statement made within a trace in a client span
+func registerTracingCallbacks(db *gorm.DB) error {
+	start := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+				return
+			}
+			ctx, span := tracing.Start(ctx, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient))
+			tx.Statement.Context = ctx
+			tx.InstanceSet(querySpanKey, span)
+		}
+	}
+	end := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			value, ok := tx.InstanceGet(querySpanKey)
+			if !ok {
+				return
+			}
+			span := value.(trace.Span)
+			span.SetAttributes(
+				attribute.String("db.system", tx.Dialector.Name()),
+				attribute.String("db.operation.name", operation),
+				attribute.String("db.collection.name", statementTable(tx)),
+				attribute.String("db.query.text", tx.Statement.SQL.String()),
+				attribute.Int64("db.rows_affected", tx.RowsAffected),
+			)
+			tracing.End(span, statementError(tx))
+		}
+	}
+	return registerAroundStatements(db, "red_packet:tracing", start, end)
+}
@@ -0,0 +1,255 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Content types carried in the `content-type` Kafka header
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// contentTypeHeader is the Kafka header naming the message encoding
+const contentTypeHeader = "content-type"
+
+// Codec encodes and decodes event envelopes
+type Codec interface {
+	ContentType() string
+	Encode(event *Event) ([]byte, error)
+	Decode(data []byte) (*Event, error)
+}
+
+// JSONCodec encodes events as JSON documents
+type JSONCodec struct{}
+
+// ProtobufCodec encodes events using the wire format described in events.proto
+type ProtobufCodec struct{}
+
+// CodecFor returns the codec registered for a content type or format name
+func CodecFor(name string) (Codec, error) {
+	switch name {
+	case ContentTypeJSON, "json", "":
+		return JSONCodec{}, nil
+	case ContentTypeProtobuf, "protobuf", "proto":
+		return ProtobufCodec{}, nil
+	}
+	return nil, fmt.Errorf("unsupported event format %q", name)
+}
+
+// jsonEnvelope is the JSON representation of Event
+type jsonEnvelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// ContentType returns the JSON content type
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+// Encode serializes the event as JSON
+func (JSONCodec) Encode(event *Event) ([]byte, error) {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonEnvelope{
+		ID:            event.ID,
+		Type:          event.Type,
+		SchemaVersion: event.SchemaVersion,
+		OccurredAt:    event.OccurredAt,
+		Payload:       payload,
+	})
+}
+
+// Decode parses a JSON event, ignoring unknown fields for forward compatibility
+func (JSONCodec) Decode(data []byte) (*Event, error) {
+	var envelope jsonEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+
+	payload, err := newPayload(envelope.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(envelope.Payload, payload); err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		ID:            envelope.ID,
+		Type:          envelope.Type,
+		SchemaVersion: envelope.SchemaVersion,
+		OccurredAt:    envelope.OccurredAt,
+		Payload:       payload,
+	}, nil
+}
+
+// Envelope field numbers, see events.proto
+const (
+	envelopeFieldID            = 1
+	envelopeFieldType          = 2
+	envelopeFieldSchemaVersion = 3
+	envelopeFieldOccurredAt    = 4
+	envelopeFieldPayload       = 5
+
+	timestampFieldSeconds = 1
+	timestampFieldNanos   = 2
+)
+
+// ContentType returns the Protobuf content type
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+// Encode serializes the event as a Protobuf EventEnvelope message
+func (ProtobufCodec) Encode(event *Event) ([]byte, error) {
+	var ts []byte
+	ts = protowire.AppendTag(ts, timestampFieldSeconds, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(event.OccurredAt.Unix()))
+	ts = protowire.AppendTag(ts, timestampFieldNanos, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(event.OccurredAt.Nanosecond()))
+
+	var b []byte
+	b = protowire.AppendTag(b, envelopeFieldID, protowire.BytesType)
+	b = protowire.AppendString(b, event.ID)
+	b = protowire.AppendTag(b, envelopeFieldType, protowire.BytesType)
+	b = protowire.AppendString(b, event.Type)
+	b = protowire.AppendTag(b, envelopeFieldSchemaVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(event.SchemaVersion))
+	b = protowire.AppendTag(b, envelopeFieldOccurredAt, protowire.BytesType)
+	b = protowire.AppendBytes(b, ts)
+	b = protowire.AppendTag(b, envelopeFieldPayload, protowire.BytesType)
+	b = protowire.AppendBytes(b, event.Payload.MarshalProto())
+	return b, nil
+}
+
+// Decode parses a Protobuf EventEnvelope message, skipping unknown fields
+func (ProtobufCodec) Decode(data []byte) (*Event, error) {
+	event := &Event{}
+	var rawPayload []byte
+
+	err := walkProto(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch num {
+		case envelopeFieldID:
+			event.ID = string(value)
+		case envelopeFieldType:
+			event.Type = string(value)
+		case envelopeFieldSchemaVersion:
+			event.SchemaVersion = int(varint)
+		case envelopeFieldOccurredAt:
+			var seconds, nanos uint64
+			if err := walkProto(value, func(num protowire.Number, _ protowire.Type, _ []byte, v uint64) error {
+				switch num {
+				case timestampFieldSeconds:
+					seconds = v
+				case timestampFieldNanos:
+					nanos = v
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			event.OccurredAt = time.Unix(int64(seconds), int64(nanos)).UTC()
+		case envelopeFieldPayload:
+			rawPayload = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := newPayload(event.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := payload.UnmarshalProto(rawPayload); err != nil {
+		return nil, err
+	}
+	event.Payload = payload
+	return event, nil
+}
+
+// GrabbedPayload field numbers, see events.proto
+const (
+	grabbedFieldUserID      = 1
+	grabbedFieldRedPacketID = 2
+	grabbedFieldAmount      = 3
+	grabbedFieldCurrency    = 4
+)
+
+// MarshalProto encodes the payload as a Protobuf RedPacketGrabbed message
+func (p *GrabbedPayload) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, grabbedFieldUserID, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(p.UserID))
+	b = protowire.AppendTag(b, grabbedFieldRedPacketID, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(p.RedPacketID))
+	b = protowire.AppendTag(b, grabbedFieldAmount, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(p.Amount))
+	b = protowire.AppendTag(b, grabbedFieldCurrency, protowire.BytesType)
+	b = protowire.AppendString(b, p.Currency)
+	return b
+}
+
+// UnmarshalProto decodes a Protobuf RedPacketGrabbed message
+func (p *GrabbedPayload) UnmarshalProto(data []byte) error {
+	return walkProto(data, func(num protowire.Number, _ protowire.Type, value []byte, v uint64) error {
+		switch num {
+		case grabbedFieldUserID:
+			p.UserID = uint(v)
+		case grabbedFieldRedPacketID:
+			p.RedPacketID = uint(v)
+		case grabbedFieldAmount:
+			p.Amount = math.Float64frombits(v)
+		case grabbedFieldCurrency:
+			p.Currency = string(value)
+		}
+		return nil
+	})
+}
+
+// walkProto iterates over the fields of a Protobuf message.
+// Bytes fields are passed as value, varint and fixed fields as varint.
+func walkProto(data []byte, visit func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			varint, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			varint = uint64(v)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := visit(num, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	grabbed := &GrabbedPayload{UserID: 7, RedPacketID: 42, Amount: 12.34, Currency: DefaultCurrency}
+	events := []*Event{
+		NewEvent(EventTypeRedPacketGrabbed, grabbed),
+		NewEvent(EventTypeRedPacketClaimed, &ClaimedPayload{GrabbedPayload: *grabbed}),
+	}
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		for _, event := range events {
+			event.OccurredAt = time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+
+			data, err := codec.Encode(event)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.ContentType(), event.Type, err)
+			}
+			decoded, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.ContentType(), event.Type, err)
+			}
+			if !reflect.DeepEqual(decoded, event) {
+				t.Fatalf("%s: round trip mismatch:\n%+v\n%+v", codec.ContentType(), decoded, event)
+			}
+		}
+	}
+}
+
+func TestDecodeMessageByContentType(t *testing.T) {
+	event := NewEvent(EventTypeRedPacketGrabbed, &GrabbedPayload{UserID: 1, RedPacketID: 2, Amount: 3, Currency: DefaultCurrency})
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		data, _ := codec.Encode(event)
+		msg := &sarama.ConsumerMessage{
+			Value:   data,
+			Headers: []*sarama.RecordHeader{{Key: []byte(contentTypeHeader), Value: []byte(codec.ContentType())}},
+		}
+		decoded, err := decodeMessage(msg)
+		if err != nil || decoded.ID != event.ID {
+			t.Fatalf("%s: expected event %s, got %+v (%v)", codec.ContentType(), event.ID, decoded, err)
+		}
+	}
+}
+
+func TestDecodeLegacyMessage(t *testing.T) {
+	event, err := decodeMessage(&sarama.ConsumerMessage{Value: []byte("7,42,12.34\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &GrabbedPayload{UserID: 7, RedPacketID: 42, Amount: 12.34, Currency: DefaultCurrency}
+	if event.Type != EventTypeRedPacketGrabbed || event.SchemaVersion != 0 || !reflect.DeepEqual(event.Payload, want) {
+		t.Fatalf("unexpected legacy event %+v", event)
+	}
+}
+
+func TestDecodeRejectsInvalidMessages(t *testing.T) {
+	valid, _ := ProtobufCodec{}.Encode(NewEvent(EventTypeRedPacketGrabbed, &GrabbedPayload{UserID: 1}))
+	unknown, _ := ProtobufCodec{}.Encode(&Event{Type: "red_packet.refunded", Payload: &GrabbedPayload{}})
+
+	tests := []struct {
+		name        string
+		contentType string
+		value       string
+	}{
+		{"unknown content type", "application/xml", "<event/>"},
+		{"unknown json type", ContentTypeJSON, `{"type":"red_packet.refunded","payload":{}}`},
+		{"corrupt json", ContentTypeJSON, `{"type":"red_packet.grabbed","payload":`},
+		{"json payload of wrong shape", ContentTypeJSON, `{"type":"red_packet.grabbed","payload":{"user_id":"seven"}}`},
+		{"unknown protobuf type", ContentTypeProtobuf, string(unknown)},
+		{"truncated protobuf", ContentTypeProtobuf, string(valid[:len(valid)-3])},
+		{"legacy with missing fields", "", "7,42"},
+		{"legacy with bad numbers", "", "seven,42,1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Value: []byte(tt.value)}
+			if tt.contentType != "" {
+				msg.Headers = []*sarama.RecordHeader{{Key: []byte(contentTypeHeader), Value: []byte(tt.contentType)}}
+			}
+			if event, err := decodeMessage(msg); err == nil {
+				t.Fatalf("expected an error, decoded %+v", event)
+			}
+		})
+	}
+}
@@ -1,135 +1,230 @@
 package kafka
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
-	"red-packet-system/db"
-	"red-packet-system/model"
+	"go.opentelemetry.io/otel/attribute"
+	"red-packet-system/config"
 	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/pkg/tracing"
 )
 
 const consumerTimeout = 5 * time.Second // Set the maximum timeout for message processing
 
-// StartConsumer starts the Kafka Consumer (supports multi-partitions)
-func StartConsumer() {
-	log := logger.GetLogger()
+// Backoff between attempts at a message that could not be handled nor dead-lettered
+const (
+	redeliveryBackoff    = time.Second
+	maxRedeliveryBackoff = 30 * time.Second
+)
+
+// consumerClient holds the broker connections of the running consumer, probed by the readiness check
+var consumerClient atomic.Pointer[sarama.Client]
 
-	config := sarama.NewConfig()
-	config.Consumer.Return.Errors = true
-	consumer, err := sarama.NewConsumer([]string{"kafka:9092"}, config)
+// StartConsumer consumes the transactions topic as a member of the KAFKA_CONSUMER_GROUP consumer
+// group until ctx is cancelled. The offset of a message is committed once it is handled, so
+// messages published while no worker runs are consumed on start and a crash only redelivers
+// the message in progress, which the handlers skip.
+func StartConsumer(ctx context.Context) {
+	log := logger.GetLogger()
+	cfg := config.LoadConfig()
+	groupID := cfg.KafkaConsumerGroup
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Consumer.Return.Errors = true
+	saramaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest // A new group starts with the retained messages
+	saramaConfig.Consumer.Offsets.AutoCommit.Enable = false
+	client, err := sarama.NewClient(cfg.KafkaBrokers, saramaConfig)
 	if err != nil {
-		log.Fatalf("Failed to start Kafka consumer: %v", err)
+		logger.Fatal("Failed to start Kafka consumer", "error", err)
 	}
-	defer consumer.Close()
-
-	partitions, err := consumer.Partitions("red_packet_transactions")
+	defer client.Close()
+	group, err := sarama.NewConsumerGroupFromClient(groupID, client)
 	if err != nil {
-		log.Fatalf("Failed to get Kafka partitions: %v", err)
+		logger.Fatal("Failed to start Kafka consumer", "error", err)
 	}
-
-	var wg sync.WaitGroup
-	for _, partition := range partitions {
-		wg.Add(1)
-		go func(p int32) {
-			defer wg.Done()
-			consumePartition(consumer, p)
-		}(partition)
+	defer group.Close()
+
+	consumerClient.Store(&client)
+	defer consumerClient.Store(nil)
+
+	go func() {
+		for err := range group.Errors() {
+			log.Warn("Kafka consumer group error", "group", groupID, "error", err)
+		}
+	}()
+
+	log.Info("Kafka consumer started", "group", groupID, "topic", TransactionsTopic)
+	// Consume returns on every rebalance, join the group again until stopped
+	for ctx.Err() == nil {
+		if err := group.Consume(ctx, []string{TransactionsTopic}, consumerGroupHandler{}); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			log.Error("Kafka consumer group session failed", "group", groupID, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
 	}
-
-	wg.Wait()
+	log.Info("Kafka consumer stopped", "group", groupID)
 }
 
-// consumePartition consumes Kafka Partition
-func consumePartition(consumer sarama.Consumer, partition int32) {
-	pc, _ := consumer.ConsumePartition("red_packet_transactions", partition, sarama.OffsetNewest)
-	defer pc.Close()
+// consumerGroupHandler processes the partitions assigned to this worker
+type consumerGroupHandler struct{}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim
+func (consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error { return nil }
 
-	for msg := range pc.Messages() {
-		processKafkaMessage(msg)
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
+func (consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim processes the messages of one partition in order, committing each offset
+// after the message is written to MySQL or its dead letter copy is acknowledged. A message
+// that is neither is attempted again, holding back the partition, until the session ends.
+func (consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	log := logger.GetLogger()
+	lag := metrics.KafkaConsumerLag.WithLabelValues(claim.Topic(), strconv.Itoa(int(claim.Partition())))
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			backoff := redeliveryBackoff
+			for processKafkaMessage(msg) != nil {
+				log.Error("Kafka message neither handled nor dead-lettered, retrying", "partition", msg.Partition, "offset", msg.Offset, "backoff", backoff)
+				select {
+				case <-session.Context().Done():
+					return nil // Uncommitted, the next owner of the partition starts with it
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, maxRedeliveryBackoff)
+			}
+			session.MarkMessage(msg, "")
+			session.Commit()
+			lag.Set(float64(claim.HighWaterMarkOffset() - msg.Offset - 1))
+		case <-session.Context().Done():
+			return nil
+		}
 	}
 }
 
-// processKafkaMessage processes Kafka message
-func processKafkaMessage(msg *sarama.ConsumerMessage) {
+// EventHandler processes a decoded event; a returned error triggers a retry with backoff
+type EventHandler func(ctx context.Context, event *Event) error
+
+// handlers maps event types to their handlers, see RegisterHandler
+var handlers = map[string]EventHandler{}
+
+// RegisterHandler sets the handler of an event type. It must be called before StartConsumer.
+func RegisterHandler(eventType string, handler EventHandler) {
+	handlers[eventType] = handler
+}
+
+// processKafkaMessage processes Kafka message, continuing the trace of its producer.
+// It returns an error, and the offset must not be committed, when the message was neither
+// handled nor acknowledged by the dead letter topic.
+func processKafkaMessage(msg *sarama.ConsumerMessage) error {
 	log := logger.GetLogger()
-	log.Printf("Kafka message received: %s", string(msg.Value))
 
-	data := strings.Split(string(msg.Value), ",")
-	if len(data) != 3 {
-		log.Println("Kafka message format error")
-		log.Printf("Exiting processKafkaMessage, message format error")
-		return
-	}
+	ctx, span := startProcessSpan(msg)
+	var err error
+	defer func() { tracing.End(span, err) }()
 
-	userID, err1 := strconv.ParseUint(data[0], 10, 64)
-	log.Printf("Message parsing: userID=%d, err1=%v", userID, err1)
-	redPacketID, err2 := strconv.ParseUint(data[1], 10, 64)
-	log.Printf("Message parsing: redPacketID=%d, err2=%v", redPacketID, err2)
-	amount, err3 := strconv.ParseFloat(data[2], 64)
-	log.Printf("Message parsing: amount=%.2f, err3=%v", amount, err3)
-	if err1 != nil || err2 != nil || err3 != nil {
-		log.Println("Kafka message parsing error")
-		log.Printf("Exiting processKafkaMessage, message parsing error")
-		return
+	log.DebugContext(ctx, "Kafka message received", "partition", msg.Partition, "offset", msg.Offset)
+
+	event, err := decodeMessage(msg)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to decode Kafka message", "partition", msg.Partition, "offset", msg.Offset, "error", err)
+		metrics.KafkaConsumed.WithLabelValues("unknown", "invalid").Inc()
+		return sendToDLQ(msg, DLQReasonInvalid, err)
+	}
+	log.DebugContext(ctx, "Kafka event decoded", "event_id", event.ID, "type", event.Type, "schema_version", event.SchemaVersion)
+	span.SetAttributes(attribute.String("event.id", event.ID), attribute.String("event.type", event.Type))
+
+	handler, ok := handlers[event.Type]
+	if !ok {
+		log.WarnContext(ctx, "Ignoring event type without handler", "event_id", event.ID, "type", event.Type)
+		metrics.KafkaConsumed.WithLabelValues(event.Type, "unhandled").Inc()
+		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), consumerTimeout)
+	ctx, cancel := context.WithTimeout(ctx, consumerTimeout)
 	defer cancel()
 
-	err := retryWithBackoff(ctx, func() error {
-		log.Printf("retryWithBackoff: Calling updateUserBalance, userID=%d, redPacketID=%d, amount=%.2f", userID, redPacketID, amount)
-		updateErr := updateUserBalance(uint(userID), uint(redPacketID), amount)
-		log.Printf("retryWithBackoff: updateUserBalance returned, err=%v", updateErr)
-		return updateErr
-	}, 3)
+	err = retryWithBackoff(ctx, func() error {
+		return handler(ctx, event)
+	}, maxKafkaRetries)
 
+	if !event.OccurredAt.IsZero() { // Legacy messages carry no timestamp
+		metrics.KafkaConsumeDelay.WithLabelValues(event.Type).Observe(metrics.Since(event.OccurredAt))
+	}
 	if err != nil {
-		log.Printf("Kafka consumption failed: %v", err)
-	} else {
-		log.Printf("User %d grabbed %.2f Yuan (RedPacket %d)", userID, amount, redPacketID)
+		log.ErrorContext(ctx, "Kafka event handling failed", "event_id", event.ID, "type", event.Type, "error", err)
+		metrics.KafkaConsumed.WithLabelValues(event.Type, "failed").Inc()
+		return sendToDLQ(msg, DLQReasonFailed, err)
 	}
-	log.Printf("Exiting processKafkaMessage, message processing completed")
+	metrics.KafkaConsumed.WithLabelValues(event.Type, "ok").Inc()
+	log.DebugContext(ctx, "Kafka event handled", "event_id", event.ID, "type", event.Type)
+	return nil
 }
 
-// updateUserBalance updates user balance
-func updateUserBalance(userID uint, redPacketID uint, amount float64) error {
-	dbInstance := db.GetDB()
-	log := logger.GetLogger()
+// decodeMessage decodes a Kafka message into an event envelope.
+// Messages without a content-type header are decoded as JSON when they look like
+// a JSON document and as the legacy CSV format otherwise.
+func decodeMessage(msg *sarama.ConsumerMessage) (*Event, error) {
+	contentType := ""
+	for _, header := range msg.Headers {
+		if header != nil && string(header.Key) == contentTypeHeader {
+			contentType = string(header.Value)
+		}
+	}
 
-	log.Printf("Entering updateUserBalance, userID=%d, redPacketID=%d, amount=%.2f", userID, redPacketID, amount)
+	if contentType == "" {
+		if !bytes.HasPrefix(bytes.TrimSpace(msg.Value), []byte("{")) {
+			return decodeLegacyMessage(msg.Value)
+		}
+		contentType = ContentTypeJSON
+	}
 
-	if dbInstance == nil {
-		err := fmt.Errorf("Database connection not initialized")
-		log.Printf("Exiting updateUserBalance, database connection not initialized, err=%v", err)
-		return err
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return nil, err
 	}
+	return codec.Decode(msg.Value)
+}
 
-	var user model.User
-	log.Printf("updateUserBalance: Executing dbInstance.First(&user, userID), userID=%d", userID)
-	if err := dbInstance.First(&user, userID).Error; err != nil {
-		log.Printf("updateUserBalance: dbInstance.First(&user, userID) failed, userID=%d, err=%v", userID, err, err)
-		err = fmt.Errorf("User %d not found, err=%v", userID, err)
-		log.Printf("Exiting updateUserBalance, User not found, err=%v", err)
-		return err
+// decodeLegacyMessage converts a legacy `userID,redPacketID,amount` message into a grabbed event
+func decodeLegacyMessage(value []byte) (*Event, error) {
+	data := strings.Split(strings.TrimSpace(string(value)), ",")
+	if len(data) != 3 {
+		return nil, fmt.Errorf("legacy message must have 3 fields, got %d", len(data))
 	}
-	log.Printf("updateUserBalance: dbInstance.First(&user, userID) succeeded, found User: %+v", user)
 
-	user.Balance += amount
-	log.Printf("updateUserBalance: Preparing to update User Balance, userID=%d, newBalance=%.2f", userID, user.Balance)
-	err := dbInstance.Model(&user).Update("Balance", user.Balance).Error
-	if err != nil {
-		log.Printf("updateUserBalance: dbInstance.Model(&user).Update() failed, userID=%d, err=%v", userID, err, err)
-		log.Printf("Exiting updateUserBalance, update failed, err=%v", err)
-		return err
+	userID, err1 := strconv.ParseUint(data[0], 10, 64)
+	redPacketID, err2 := strconv.ParseUint(data[1], 10, 64)
+	amount, err3 := strconv.ParseFloat(data[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, fmt.Errorf("legacy message parsing error: %q", value)
 	}
 
-	log.Printf("User %d balance updated: %.2f Yuan", userID, user.Balance)
-	log.Printf("Exiting updateUserBalance, update successful")
-	return nil
+	return &Event{
+		Type:          EventTypeRedPacketGrabbed,
+		SchemaVersion: 0,
+		Payload: &GrabbedPayload{
+			UserID:      uint(userID),
+			RedPacketID: uint(redPacketID),
+			Amount:      amount,
+			Currency:    DefaultCurrency,
+		},
+	}, nil
 }
@@ -0,0 +1,190 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"red-packet-system/config"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/metrics"
+)
+
+// DeadLetterTopic receives the messages the worker gave up on, with the original
+// key, value and headers plus the dlq-* headers below
+const DeadLetterTopic = TransactionsTopic + ".dlq"
+
+// Reasons for moving a message to the dead letter topic
+const (
+	DLQReasonInvalid = "invalid" // The message could not be decoded
+	DLQReasonFailed  = "failed"  // The handler still failed after the retries
+)
+
+// Headers describing why and from where a message was dead-lettered
+const (
+	dlqReasonHeader    = "dlq-reason"
+	dlqErrorHeader     = "dlq-error"
+	dlqTopicHeader     = "dlq-source-topic"
+	dlqPartitionHeader = "dlq-source-partition"
+	dlqOffsetHeader    = "dlq-source-offset"
+)
+
+// sendToDLQ publishes a consumed message to the dead letter topic, keeping its key and headers,
+// and waits until Kafka acknowledges it. The offset of msg must not be committed if it fails.
+func sendToDLQ(msg *sarama.ConsumerMessage, reason string, cause error) error {
+	log := logger.GetLogger()
+
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(dlqReasonHeader), Value: []byte(reason)},
+		sarama.RecordHeader{Key: []byte(dlqErrorHeader), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(dlqTopicHeader), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(dlqPartitionHeader), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		sarama.RecordHeader{Key: []byte(dlqOffsetHeader), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+
+	message := &sarama.ProducerMessage{
+		Topic:   DeadLetterTopic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		message.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	err := publishAndWait(message)
+	metrics.DLQMessages.WithLabelValues(reason, metrics.Result(err)).Inc()
+	if err != nil {
+		log.Error("Failed to dead-letter message", "partition", msg.Partition, "offset", msg.Offset, "error", err)
+		return err
+	}
+	log.Warn("Message moved to the dead letter topic", "partition", msg.Partition, "offset", msg.Offset, "topic", DeadLetterTopic, "reason", reason, "cause", cause)
+	return nil
+}
+
+// replayMessage rebuilds a dead-lettered message for its source topic, without the dlq-* headers.
+// It returns nil if the message was dead-lettered for another reason; an empty reason matches all.
+func replayMessage(msg *sarama.ConsumerMessage, reason string) *sarama.ProducerMessage {
+	message := &sarama.ProducerMessage{Topic: TransactionsTopic, Value: sarama.ByteEncoder(msg.Value)}
+	if msg.Key != nil {
+		message.Key = sarama.ByteEncoder(msg.Key)
+	}
+	matched := reason == ""
+	for _, header := range msg.Headers {
+		if header == nil {
+			continue
+		}
+		switch string(header.Key) {
+		case dlqReasonHeader:
+			matched = matched || string(header.Value) == reason
+		case dlqTopicHeader:
+			message.Topic = string(header.Value)
+		case dlqErrorHeader, dlqPartitionHeader, dlqOffsetHeader:
+		default:
+			message.Headers = append(message.Headers, *header)
+		}
+	}
+	if !matched {
+		return nil
+	}
+	return message
+}
+
+// ReplayDLQ publishes the dead letter messages with the given reason back to their source topic,
+// once the cause was fixed. Its progress is committed for the KAFKA_CONSUMER_GROUP + ".dlq-replay"
+// group after each message, so a replay resumes where the previous one stopped and stops at the
+// end of the topic as it was when called. It returns the number of replayed messages.
+func ReplayDLQ(ctx context.Context, reason string) (int, error) {
+	log := logger.GetLogger()
+	cfg := config.LoadConfig()
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	saramaConfig.Consumer.Offsets.AutoCommit.Enable = false
+	client, err := sarama.NewClient(cfg.KafkaBrokers, saramaConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	offsets, err := sarama.NewOffsetManagerFromClient(cfg.KafkaConsumerGroup+".dlq-replay", client)
+	if err != nil {
+		return 0, err
+	}
+	defer offsets.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return 0, err
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(DeadLetterTopic)
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	for _, partition := range partitions {
+		n, err := replayPartition(ctx, client, offsets, consumer, partition, reason)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+		log.Info("Dead letter partition replayed", "partition", partition, "replayed", n)
+	}
+	return replayed, nil
+}
+
+// replayPartition replays one dead letter partition from its committed offset up to its current end
+func replayPartition(ctx context.Context, client sarama.Client, offsets sarama.OffsetManager, consumer sarama.Consumer, partition int32, reason string) (int, error) {
+	end, err := client.GetOffset(DeadLetterTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	progress, err := offsets.ManagePartition(DeadLetterTopic, partition)
+	if err != nil {
+		return 0, err
+	}
+	defer progress.Close()
+	next, _ := progress.NextOffset()
+	if next < 0 {
+		if next, err = client.GetOffset(DeadLetterTopic, partition, sarama.OffsetOldest); err != nil {
+			return 0, err
+		}
+	}
+	if next >= end {
+		return 0, nil
+	}
+
+	messages, err := consumer.ConsumePartition(DeadLetterTopic, partition, next)
+	if err != nil {
+		return 0, err
+	}
+	defer messages.Close()
+
+	replayed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return replayed, ctx.Err()
+		case err := <-messages.Errors():
+			return replayed, err
+		case msg := <-messages.Messages():
+			if message := replayMessage(msg, reason); message != nil {
+				if err := publishAndWait(message); err != nil {
+					return replayed, fmt.Errorf("replaying offset %d: %w", msg.Offset, err)
+				}
+				replayed++
+			}
+			progress.MarkOffset(msg.Offset+1, "")
+			offsets.Commit()
+			if msg.Offset+1 >= end {
+				return replayed, nil
+			}
+		}
+	}
+}
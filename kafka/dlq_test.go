@@ -0,0 +1,47 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestReplayMessage(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Key:   []byte("42"),
+		Value: []byte(`{"id":"e1"}`),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(contentTypeHeader), Value: []byte(ContentTypeJSON)},
+			{Key: []byte(dlqReasonHeader), Value: []byte(DLQReasonFailed)},
+			{Key: []byte(dlqErrorHeader), Value: []byte("deadlock")},
+			{Key: []byte(dlqTopicHeader), Value: []byte("source")},
+			{Key: []byte(dlqPartitionHeader), Value: []byte("3")},
+			{Key: []byte(dlqOffsetHeader), Value: []byte("17")},
+		},
+	}
+
+	// The copy goes back to its source topic with the original key, value and headers only
+	message := replayMessage(msg, DLQReasonFailed)
+	if message == nil {
+		t.Fatal("message with the requested reason not replayed")
+	}
+	if message.Topic != "source" {
+		t.Errorf("topic = %q, want source", message.Topic)
+	}
+	if key, _ := message.Key.Encode(); string(key) != "42" {
+		t.Errorf("key = %q, want 42", key)
+	}
+	if value, _ := message.Value.Encode(); string(value) != `{"id":"e1"}` {
+		t.Errorf("value = %s", value)
+	}
+	if len(message.Headers) != 1 || string(message.Headers[0].Key) != contentTypeHeader {
+		t.Errorf("headers = %v, want only %s", message.Headers, contentTypeHeader)
+	}
+
+	if replayMessage(msg, DLQReasonInvalid) != nil {
+		t.Error("message with another reason replayed")
+	}
+	if replayMessage(msg, "") == nil {
+		t.Error("empty reason should match every message")
+	}
+}
@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Event types published to Kafka
+const (
+	EventTypeRedPacketGrabbed = "red_packet.grabbed" // Strict mode grab, already persisted in MySQL
+	EventTypeRedPacketClaimed = "red_packet.claimed" // Fast mode claim, persisted by the worker
+)
+
+// EventSchemaVersion is the envelope version written by this build.
+// Version 0 denotes legacy CSV messages converted on read.
+const EventSchemaVersion = 1
+
+// DefaultCurrency is used for amounts until multi-currency packets exist
+const DefaultCurrency = "CNY"
+
+// Event is the versioned envelope wrapping every Kafka message
+type Event struct {
+	ID            string
+	Type          string
+	SchemaVersion int
+	OccurredAt    time.Time
+	Payload       EventPayload
+}
+
+// EventPayload is implemented by every event payload so it can be carried by both codecs.
+// PartitionKey is the Kafka message key: the user ID for balance events and the
+// red packet ID for packet lifecycle events.
+type EventPayload interface {
+	MarshalProto() []byte
+	UnmarshalProto(data []byte) error
+	PartitionKey() string
+}
+
+// GrabbedPayload describes a successful red packet grab
+type GrabbedPayload struct {
+	UserID      uint    `json:"user_id"`
+	RedPacketID uint    `json:"red_packet_id"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+}
+
+// PartitionKey keys grab events by user so balance updates for a user stay ordered
+func (p *GrabbedPayload) PartitionKey() string {
+	return strconv.FormatUint(uint64(p.UserID), 10)
+}
+
+// ClaimedPayload describes a fast mode claim made in Redis and not yet written to MySQL
+type ClaimedPayload struct {
+	GrabbedPayload
+}
+
+// PartitionKey keys claims by red packet so updates to a packet row stay ordered
+func (p *ClaimedPayload) PartitionKey() string {
+	return strconv.FormatUint(uint64(p.RedPacketID), 10)
+}
+
+// payloadFactories maps event types to their payload constructors
+var payloadFactories = map[string]func() EventPayload{
+	EventTypeRedPacketGrabbed: func() EventPayload { return &GrabbedPayload{} },
+	EventTypeRedPacketClaimed: func() EventPayload { return &ClaimedPayload{} },
+}
+
+// NewEvent wraps a payload in an envelope with a fresh ID and the current schema version
+func NewEvent(eventType string, payload EventPayload) *Event {
+	return &Event{
+		ID:            newEventID(),
+		Type:          eventType,
+		SchemaVersion: EventSchemaVersion,
+		OccurredAt:    time.Now().UTC(),
+		Payload:       payload,
+	}
+}
+
+// newPayload returns an empty payload for the given event type
+func newPayload(eventType string) (EventPayload, error) {
+	factory, ok := payloadFactories[eventType]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	return factory(), nil
+}
+
+// newEventID generates a random 128-bit hex identifier
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
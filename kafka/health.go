@@ -0,0 +1,110 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"red-packet-system/config"
+	"red-packet-system/pkg/health"
+)
+
+// probeTimeout bounds a broker probe when the check context has no deadline
+const probeTimeout = 2 * time.Second
+
+// Metadata refreshes of the producer and consumer clients, one in flight each
+var producerProbe, consumerProbe metadataProbe
+
+// ProducerHealthCheck returns the readiness check of the producer's brokers.
+// The producer connects on the first publish, until then the configured brokers are probed directly.
+func ProducerHealthCheck() health.Check {
+	return health.Check{Name: "kafka_producer", Run: func(ctx context.Context) error {
+		producerMu.RLock()
+		client, closed := producerClient, producerClosed
+		producerMu.RUnlock()
+
+		if closed {
+			return errors.New("Kafka Producer is closed")
+		}
+		if client == nil {
+			return probeBrokers(ctx, config.LoadConfig().KafkaBrokers)
+		}
+		return producerProbe.run(ctx, func() error { return client.RefreshMetadata() })
+	}}
+}
+
+// ConsumerHealthCheck returns the readiness check of the worker's consumer, ready once it
+// consumes its partitions and while its brokers answer
+func ConsumerHealthCheck() health.Check {
+	return health.Check{Name: "kafka_consumer", Run: func(ctx context.Context) error {
+		client := consumerClient.Load()
+		if client == nil {
+			return errors.New("Kafka consumer is not running")
+		}
+		return consumerProbe.run(ctx, func() error { return (*client).RefreshMetadata(TransactionsTopic) })
+	}}
+}
+
+// metadataProbe runs one client metadata refresh at a time. RefreshMetadata takes no context,
+// so a check stops waiting at its deadline and later checks wait for the refresh in flight
+// instead of piling up blocked goroutines while Kafka hangs.
+type metadataProbe struct {
+	mu      sync.Mutex
+	running *probeResult
+}
+
+// probeResult is the outcome of one refresh, err is set before done is closed
+type probeResult struct {
+	done chan struct{}
+	err  error
+}
+
+// run waits for the refresh in flight, or starts one, until ctx or probeTimeout expires
+func (p *metadataProbe) run(ctx context.Context, refresh func() error) error {
+	p.mu.Lock()
+	result := p.running
+	if result == nil {
+		result = &probeResult{done: make(chan struct{})}
+		p.running = result
+		go func() {
+			result.err = refresh()
+			p.mu.Lock()
+			p.running = nil
+			p.mu.Unlock()
+			close(result.done)
+		}()
+	}
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	select {
+	case <-result.done:
+		return result.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// probeBrokers opens a short-lived client, which fails unless a broker answers a metadata request
+func probeBrokers(ctx context.Context, brokers []string) error {
+	timeout := probeTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(time.Until(deadline), time.Millisecond)
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Net.DialTimeout = timeout
+	saramaConfig.Net.ReadTimeout = timeout
+	saramaConfig.Net.WriteTimeout = timeout
+	saramaConfig.Metadata.Retry.Max = 0
+	saramaConfig.Metadata.Full = false
+
+	client, err := sarama.NewClient(brokers, saramaConfig)
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
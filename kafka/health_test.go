@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetadataProbeSingleFlight(t *testing.T) {
+	var probe metadataProbe
+	var refreshes atomic.Int32
+	release := make(chan struct{})
+	hung := func() error {
+		refreshes.Add(1)
+		<-release
+		return errors.New("broker down")
+	}
+
+	// Checks against a hanging broker give up at their deadline without starting more refreshes
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := probe.run(ctx, hung); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected a deadline error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := refreshes.Load(); n != 1 {
+		t.Fatalf("expected 1 refresh in flight, got %d", n)
+	}
+
+	// A check waiting for the refresh in flight gets its result
+	waiting := make(chan error, 1)
+	go func() { waiting <- probe.run(context.Background(), hung) }()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-waiting; err == nil || err.Error() != "broker down" {
+		t.Fatalf("expected the refresh error, got %v", err)
+	}
+
+	// Once it finished, the next check refreshes again
+	if err := probe.run(context.Background(), func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if n := refreshes.Load(); n != 1 {
+		t.Fatalf("expected the hung refresh to run once, got %d", n)
+	}
+}
@@ -0,0 +1,277 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/db"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/redisclient"
+)
+
+const (
+	outboxBatchSize      = 100                    // Maximum events published per relay round
+	outboxPollInterval   = 500 * time.Millisecond // Delay between relay rounds
+	outboxMaxBackoff     = 30 * time.Second       // Longest delay after consecutive failed rounds
+	outboxLockKey        = "lock:outbox_relay"    // Ensures a single active relay across API instances
+	outboxLockTTL        = 8 * time.Second        // Relay lock expiry, extended while a batch is relayed
+	outboxPurgeInterval  = time.Hour              // Delay between purges of sent events
+	outboxPurgeBatchSize = 1000                   // Maximum sent events deleted per statement
+)
+
+// StartOutboxRelay publishes pending outbox events to Kafka until ctx is cancelled.
+// Events are marked as sent only after Kafka acknowledges them (at-least-once delivery).
+// An event failing maxAttempts times is marked dead and no longer relayed (0 retries forever),
+// sent events older than retention are purged every outboxPurgeInterval (0 keeps them).
+func StartOutboxRelay(ctx context.Context, maxAttempts int, retention time.Duration) {
+	log := logger.GetLogger()
+	log.Info("Outbox relay started", "max_attempts", maxAttempts, "retention", retention)
+
+	// Failed rounds back off, so a Kafka outage does not burn through the attempts of an event
+	delay := outboxPollInterval
+	relayTimer := time.NewTimer(delay)
+	defer relayTimer.Stop()
+	purgeTicker := time.NewTicker(outboxPurgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Outbox relay stopped")
+			return
+		case <-relayTimer.C:
+			if err := relayOutboxBatch(ctx, maxAttempts); err != nil {
+				delay = min(2*delay, outboxMaxBackoff)
+				log.Warn("Outbox relay round failed", "error", err, "backoff", delay)
+			} else {
+				delay = outboxPollInterval
+			}
+			relayTimer.Reset(delay)
+		case <-purgeTicker.C:
+			if retention <= 0 {
+				continue
+			}
+			dbInstance := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx)
+			purged, err := purgeSentEvents(dbInstance, time.Now().Add(-retention))
+			if err != nil {
+				log.Warn("Failed to purge sent outbox events", "purged", purged, "error", err)
+			} else if purged > 0 {
+				log.Info("Purged sent outbox events", "purged", purged, "retention", retention)
+			}
+		}
+	}
+}
+
+// relayOutboxBatch publishes one batch of pending events in insertion order
+func relayOutboxBatch(ctx context.Context, maxAttempts int) error {
+	log := logger.GetLogger()
+
+	// Only one relay may publish at a time, otherwise events are duplicated.
+	// The lock is extended before every message, so a slow batch cannot outlive it.
+	mutex := redisclient.GetRedlock().NewMutex(outboxLockKey, redsync.WithExpiry(outboxLockTTL))
+	if err := mutex.LockContext(ctx); err != nil {
+		return nil // Another instance is relaying
+	}
+	defer mutex.Unlock()
+	holdLock := func() bool {
+		if time.Until(mutex.Until()) > outboxLockTTL/2 {
+			return true
+		}
+		ok, err := mutex.ExtendContext(ctx)
+		return ok && err == nil
+	}
+
+	// Read from the master so freshly committed events are never missed
+	dbInstance := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx)
+
+	var events []model.OutboxEvent
+	if err := dbInstance.
+		Where("status = ?", model.OutboxStatusPending).
+		Order("id").
+		Limit(outboxBatchSize).
+		Find(&events).Error; err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	outcomes, timedOut := relayEvents(events, publishOutboxEvent, holdLock, producerTimeout)
+
+	// Record the outcome even if the relay is stopping, so acknowledged events are not resent
+	dbInstance = dbInstance.WithContext(context.WithoutCancel(ctx))
+
+	var sentIDs []uint
+	var firstErr error
+	for i, outcome := range outcomes {
+		switch {
+		case outcome.sent:
+			sentIDs = append(sentIDs, events[i].ID)
+			metrics.OutboxLag.Observe(metrics.Since(events[i].CreatedAt))
+		case outcome.err != nil:
+			if firstErr == nil {
+				firstErr = outcome.err
+			}
+			if err := recordFailure(dbInstance, &events[i], outcome.err, maxAttempts); err != nil {
+				log.Warn("Failed to record outbox publish failure", "event_id", events[i].ID, "error", err)
+			}
+		}
+	}
+
+	if len(sentIDs) > 0 {
+		if err := dbInstance.Model(&model.OutboxEvent{}).
+			Where("id IN ?", sentIDs).
+			Updates(map[string]interface{}{
+				"Status": model.OutboxStatusSent,
+				"SentAt": time.Now(),
+			}).Error; err != nil {
+			// The events will be published again, consumers must tolerate duplicates
+			log.Warn("Failed to mark outbox events as sent", "events", len(sentIDs), "error", err)
+			return err
+		}
+	}
+
+	if firstErr == nil && timedOut {
+		// Unacknowledged events stay pending and are published again next round
+		firstErr = errors.New("timed out waiting for Kafka acknowledgements")
+	}
+	return firstErr
+}
+
+// recordFailure counts a failed publish of event. The event is marked dead once it failed
+// maxAttempts times (0 never): the relay skips it, which lets the later events of its key through.
+func recordFailure(dbInstance *gorm.DB, event *model.OutboxEvent, cause error, maxAttempts int) error {
+	updates := map[string]interface{}{
+		"Attempts":  gorm.Expr("attempts + 1"),
+		"LastError": cause.Error(),
+	}
+	dead := maxAttempts > 0 && event.Attempts+1 >= maxAttempts
+	if dead {
+		updates["Status"] = model.OutboxStatusDead
+	}
+	if err := dbInstance.Model(event).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if dead {
+		metrics.OutboxDeadEvents.Inc()
+		logger.GetLogger().Error("Outbox event gave up after repeated publish failures",
+			"event_id", event.ID, "topic", event.Topic, "key", event.EventKey, "attempts", maxAttempts, "error", cause)
+	}
+	return nil
+}
+
+// purgeSentEvents deletes the events sent before cutoff in batches and returns how many it deleted.
+// Pending and dead events are kept whatever their age.
+func purgeSentEvents(dbInstance *gorm.DB, cutoff time.Time) (int64, error) {
+	var purged int64
+	for {
+		var ids []uint
+		if err := dbInstance.Model(&model.OutboxEvent{}).
+			Where("status = ? AND sent_at < ?", model.OutboxStatusSent, cutoff).
+			Order("id").
+			Limit(outboxPurgeBatchSize).
+			Pluck("id", &ids).Error; err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+
+		result := dbInstance.Where("id IN ?", ids).Delete(&model.OutboxEvent{})
+		if result.Error != nil {
+			return purged, result.Error
+		}
+		purged += result.RowsAffected
+		if len(ids) < outboxPurgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// relayOutcome is the result of publishing one outbox event. An event neither sent nor
+// failed was skipped or not acknowledged in time, it stays pending without counting an attempt.
+type relayOutcome struct {
+	sent bool
+	err  error
+}
+
+// relayEvents publishes events in order and waits up to timeout for their acknowledgements.
+// Each key stops at its first failure: its later events are not published, or not marked
+// sent if already in flight, so they are retried after the failed one and per-key order holds.
+// holdLock is checked before every message and before waiting; once it fails nothing more is published.
+func relayEvents(events []model.OutboxEvent, publish func(*model.OutboxEvent, func(error)) error, holdLock func() bool, timeout time.Duration) (outcomes []relayOutcome, timedOut bool) {
+	outcomes = make([]relayOutcome, len(events))
+	// One buffered channel per message: late callbacks never touch shared state
+	results := make([]chan error, len(events))
+	stopped := map[string]bool{} // Keys with a failed enqueue this round
+	lockLost := false
+
+	// Enqueue the batch so the async producer can batch and compress it
+	for i := range events {
+		key := events[i].EventKey
+		if lockLost || stopped[key] {
+			continue
+		}
+		if !holdLock() {
+			lockLost = true // The rest stays pending for the next lock holder
+			continue
+		}
+		result := make(chan error, 1)
+		if err := publish(&events[i], func(err error) { result <- err }); err != nil {
+			outcomes[i].err = err
+			stopped[key] = true
+			continue
+		}
+		results[i] = result
+	}
+	if !lockLost {
+		holdLock() // Keep the lock while waiting for acknowledgements
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	failed := map[string]bool{} // Keys with an earlier failed or unacknowledged event
+	for i, result := range results {
+		if result == nil {
+			continue // Failed to enqueue or skipped
+		}
+
+		var err error
+		acked := true
+		select {
+		case err = <-result:
+		default:
+			if timedOut {
+				acked = false
+				break
+			}
+			select {
+			case err = <-result:
+			case <-deadline.C:
+				timedOut, acked = true, false
+			}
+		}
+
+		key := events[i].EventKey
+		switch {
+		case failed[key]:
+			// Published after a failure of its key, published again next round
+		case !acked:
+			failed[key] = true
+		case err != nil:
+			outcomes[i].err = err
+			failed[key] = true
+		default:
+			outcomes[i].sent = true
+		}
+	}
+	return outcomes, timedOut
+}
@@ -0,0 +1,201 @@
+package kafka
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"red-packet-system/model"
+	"red-packet-system/pkg/testenv"
+)
+
+func outboxEvents(keys ...string) []model.OutboxEvent {
+	events := make([]model.OutboxEvent, len(keys))
+	for i, key := range keys {
+		events[i] = model.OutboxEvent{ID: uint(i + 1), EventKey: key}
+	}
+	return events
+}
+
+func holdLock() bool { return true }
+
+func TestRelayEventsStopsKeyAtFirstFailure(t *testing.T) {
+	events := outboxEvents("a", "a", "b", "c", "c", "a")
+	var published []uint
+	publish := func(event *model.OutboxEvent, done func(error)) error {
+		published = append(published, event.ID)
+		switch event.ID {
+		case 1:
+			return errors.New("producer closed") // Key a fails to enqueue
+		case 4:
+			done(errors.New("broker rejected")) // Key c fails on acknowledgement
+		default:
+			done(nil)
+		}
+		return nil
+	}
+
+	outcomes, timedOut := relayEvents(events, publish, holdLock, time.Second)
+	if timedOut {
+		t.Fatal("unexpected timeout")
+	}
+	// Events 2 and 6 follow a failed enqueue of their key and are never published
+	if want := []uint{1, 3, 4, 5}; !reflect.DeepEqual(published, want) {
+		t.Fatalf("expected %v published, got %v", want, published)
+	}
+	want := []relayOutcome{
+		{err: errors.New("producer closed")},
+		{},           // Skipped
+		{sent: true}, // Other key
+		{err: errors.New("broker rejected")},
+		{}, // Acknowledged after a failure of its key, stays pending
+		{}, // Skipped
+	}
+	for i := range want {
+		got := outcomes[i]
+		if got.sent != want[i].sent || (got.err == nil) != (want[i].err == nil) {
+			t.Errorf("event %d: expected %+v, got %+v", events[i].ID, want[i], got)
+		}
+	}
+}
+
+func TestRelayEventsTimeout(t *testing.T) {
+	events := outboxEvents("a", "b", "b")
+	var callbacks sync.WaitGroup
+	publish := func(event *model.OutboxEvent, done func(error)) error {
+		if event.ID == 1 {
+			done(nil)
+			return nil
+		}
+		// Acknowledged only after the relay gave up waiting
+		callbacks.Add(1)
+		go func() {
+			defer callbacks.Done()
+			time.Sleep(50 * time.Millisecond)
+			done(nil)
+		}()
+		return nil
+	}
+
+	outcomes, timedOut := relayEvents(events, publish, holdLock, 10*time.Millisecond)
+	callbacks.Wait() // Late callbacks must not race with the outcomes, run with -race
+	if !timedOut {
+		t.Fatal("expected a timeout")
+	}
+	if !outcomes[0].sent {
+		t.Fatalf("acknowledged event not sent: %+v", outcomes[0])
+	}
+	for _, outcome := range outcomes[1:] {
+		if outcome.sent || outcome.err != nil {
+			t.Fatalf("unacknowledged event must stay pending, got %+v", outcome)
+		}
+	}
+}
+
+func TestRelayEventsLostLock(t *testing.T) {
+	events := outboxEvents("a", "b", "c")
+	calls := 0
+	holdFirst := func() bool {
+		calls++
+		return calls == 1
+	}
+	publish := func(event *model.OutboxEvent, done func(error)) error {
+		done(nil)
+		return nil
+	}
+
+	outcomes, _ := relayEvents(events, publish, holdFirst, time.Second)
+	if !outcomes[0].sent || outcomes[1].sent || outcomes[2].sent {
+		t.Fatalf("expected only the event published under the lock to be sent, got %+v", outcomes)
+	}
+}
+
+func openOutboxDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database, err := testenv.OpenSQLite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestRecordFailureMarksDeadAtMaxAttempts(t *testing.T) {
+	database := openOutboxDB(t)
+	event := model.OutboxEvent{Topic: "transactions", EventKey: "1", Payload: []byte("{}"), ContentType: "application/json"}
+	if err := database.Create(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		var stored model.OutboxEvent
+		database.First(&stored, event.ID)
+		if stored.Status != model.OutboxStatusPending {
+			t.Fatalf("event is %d before attempt %d, want pending", stored.Status, attempt)
+		}
+		if err := recordFailure(database, &stored, errors.New("broker rejected"), 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stored model.OutboxEvent
+	database.First(&stored, event.ID)
+	if stored.Status != model.OutboxStatusDead || stored.Attempts != 3 || stored.LastError != "broker rejected" {
+		t.Fatalf("got status %d, %d attempts, last error %q, want dead after 3 attempts", stored.Status, stored.Attempts, stored.LastError)
+	}
+}
+
+func TestRecordFailureWithoutLimitStaysPending(t *testing.T) {
+	database := openOutboxDB(t)
+	event := model.OutboxEvent{Topic: "transactions", EventKey: "1", Payload: []byte("{}"), ContentType: "application/json", Attempts: 100}
+	if err := database.Create(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recordFailure(database, &event, errors.New("broker rejected"), 0); err != nil {
+		t.Fatal(err)
+	}
+	var stored model.OutboxEvent
+	database.First(&stored, event.ID)
+	if stored.Status != model.OutboxStatusPending || stored.Attempts != 101 {
+		t.Fatalf("got status %d after %d attempts, want pending", stored.Status, stored.Attempts)
+	}
+}
+
+func TestPurgeSentEvents(t *testing.T) {
+	database := openOutboxDB(t)
+	now := time.Now()
+	old, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	events := []model.OutboxEvent{
+		{Status: model.OutboxStatusSent, SentAt: &old},
+		{Status: model.OutboxStatusSent, SentAt: &recent},
+		{Status: model.OutboxStatusPending},
+		{Status: model.OutboxStatusDead},
+	}
+	for i := 0; i < outboxPurgeBatchSize; i++ {
+		events = append(events, model.OutboxEvent{Status: model.OutboxStatusSent, SentAt: &old})
+	}
+	for i := range events {
+		events[i].Topic, events[i].EventKey, events[i].Payload, events[i].ContentType = "transactions", "1", []byte("{}"), "application/json"
+	}
+	if err := database.CreateInBatches(&events, 200).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := purgeSentEvents(database, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(outboxPurgeBatchSize + 1); purged != want {
+		t.Fatalf("purged %d events, want %d", purged, want)
+	}
+
+	// Recently sent, pending and dead events are kept
+	var kept []uint
+	database.Model(&model.OutboxEvent{}).Order("id").Pluck("id", &kept)
+	if want := []uint{events[1].ID, events[2].ID, events[3].ID}; !reflect.DeepEqual(kept, want) {
+		t.Fatalf("kept events %v, want %v", kept, want)
+	}
+}
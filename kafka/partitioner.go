@@ -0,0 +1,31 @@
+package kafka
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+
+	"github.com/Shopify/sarama"
+)
+
+// Supported values of KAFKA_PARTITIONER. All of them hash the message key,
+// so every event with the same key lands on the same partition and keeps its order
+// regardless of how many partitions the topic has.
+const (
+	PartitionerHash      = "hash"      // FNV-1a, sarama default
+	PartitionerReference = "reference" // FNV-1a with Java client compatible sign handling
+	PartitionerCRC32     = "crc32"     // CRC32 (IEEE) hash
+)
+
+// partitionerFor returns the sarama partitioner constructor for a configured name
+func partitionerFor(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case PartitionerHash, "":
+		return sarama.NewHashPartitioner, nil
+	case PartitionerReference:
+		return sarama.NewReferenceHashPartitioner, nil
+	case PartitionerCRC32:
+		return sarama.NewCustomHashPartitioner(func() hash.Hash32 { return crc32.NewIEEE() }), nil
+	}
+	return nil, fmt.Errorf("unsupported Kafka partitioner %q", name)
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func partitionOf(t *testing.T, partitioner sarama.Partitioner, key string, partitions int32) int32 {
+	t.Helper()
+	partition, err := partitioner.Partition(&sarama.ProducerMessage{Topic: "transactions", Key: sarama.StringEncoder(key)}, partitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partition < 0 || partition >= partitions {
+		t.Fatalf("key %q went to partition %d of %d", key, partition, partitions)
+	}
+	return partition
+}
+
+func TestPartitionerKeepsKeysTogether(t *testing.T) {
+	for _, name := range []string{PartitionerHash, PartitionerReference, PartitionerCRC32} {
+		t.Run(name, func(t *testing.T) {
+			constructor, err := partitionerFor(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			partitioner := constructor("transactions")
+			if !partitioner.RequiresConsistency() {
+				t.Fatal("partitioner does not require consistency, keyed events could be reordered")
+			}
+
+			// Every event of a key lands on one partition, and the keys spread over the topic
+			used := map[int32]bool{}
+			for id := 1; id <= 100; id++ {
+				key := strconv.Itoa(id)
+				partition := partitionOf(t, partitioner, key, 8)
+				for i := 0; i < 3; i++ {
+					if again := partitionOf(t, partitioner, key, 8); again != partition {
+						t.Fatalf("key %q went to partitions %d and %d", key, partition, again)
+					}
+				}
+				used[partition] = true
+			}
+			if len(used) < 4 {
+				t.Fatalf("100 keys used only %d of 8 partitions", len(used))
+			}
+		})
+	}
+}
+
+func TestPartitionerFor(t *testing.T) {
+	// Unset defaults to sarama's hash partitioner
+	defaultConstructor, err := partitionerFor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashConstructor, _ := partitionerFor(PartitionerHash)
+	for id := 1; id <= 20; id++ {
+		key := strconv.Itoa(id)
+		if got, want := partitionOf(t, defaultConstructor("transactions"), key, 8), partitionOf(t, hashConstructor("transactions"), key, 8); got != want {
+			t.Fatalf("key %q went to partition %d by default, %d with hash", key, got, want)
+		}
+	}
+
+	if _, err := partitionerFor("random"); err == nil {
+		t.Fatal("unsupported partitioner accepted")
+	}
+}
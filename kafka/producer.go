@@ -2,71 +2,335 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel/trace"
 	"red-packet-system/config"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/pkg/tracing"
 )
 
 const producerTimeout = 5 * time.Second // Define message timeout
 
+// TransactionsTopic is the topic carrying red packet grab events
+const TransactionsTopic = "red_packet_transactions"
+
 // KafkaProducerSingleton ensures a single instance of Kafka producer
 var (
-	producer     sarama.SyncProducer
-	producerOnce sync.Once
+	producer       sarama.AsyncProducer
+	producerClient sarama.Client // Broker connections of the producer, probed by the readiness check
+	producerOnce   sync.Once
+	producerMu     sync.RWMutex   // Guards Input() against a concurrent CloseProducer, and producerClient
+	producerClosed bool           // Set once CloseProducer has run
+	producerDrain  sync.WaitGroup // Tracks the success/error drain goroutines
 )
 
-// initProducer initializes Kafka Sync Producer
+// ProducerStats is a snapshot of async producer counters
+type ProducerStats struct {
+	Enqueued  uint64 // Messages accepted into the producer buffer
+	Succeeded uint64 // Messages acknowledged by Kafka
+	Failed    uint64 // Messages rejected by Kafka after sarama retries
+	Rejected  uint64 // Messages refused because the buffer stayed full or the producer was closed
+}
+
+var producerStats struct {
+	enqueued, succeeded, failed, rejected atomic.Uint64
+}
+
+func init() {
+	metrics.ProducerMessages("enqueued", producerStats.enqueued.Load)
+	metrics.ProducerMessages("succeeded", producerStats.succeeded.Load)
+	metrics.ProducerMessages("failed", producerStats.failed.Load)
+	metrics.ProducerMessages("rejected", producerStats.rejected.Load)
+}
+
+// GetProducerStats returns the current async producer counters
+func GetProducerStats() ProducerStats {
+	return ProducerStats{
+		Enqueued:  producerStats.enqueued.Load(),
+		Succeeded: producerStats.succeeded.Load(),
+		Failed:    producerStats.failed.Load(),
+		Rejected:  producerStats.rejected.Load(),
+	}
+}
+
+// initProducer initializes Kafka Async Producer with batching and compression
 func initProducer(cfg *config.Config) error {
+	log := logger.GetLogger()
 	var err error
 	producerOnce.Do(func() {
 		saramaConfig := sarama.NewConfig()
 		saramaConfig.Producer.Return.Successes = true
+		saramaConfig.Producer.Return.Errors = true
 		saramaConfig.Producer.Retry.Max = 5
+		saramaConfig.Producer.RequiredAcks = sarama.WaitForLocal
+		saramaConfig.Net.MaxOpenRequests = 1 // Keep per-partition order across retries
 
-		producer, err = sarama.NewSyncProducer(cfg.KafkaBrokers, saramaConfig)
+		// Batch messages and bound the in-memory buffer
+		saramaConfig.Producer.Flush.Frequency = cfg.KafkaFlushFrequency
+		saramaConfig.Producer.Flush.Messages = cfg.KafkaFlushMessages
+		saramaConfig.ChannelBufferSize = cfg.KafkaBufferSize
+
+		saramaConfig.Producer.Compression, err = compressionFor(cfg.KafkaCompression)
+		if err != nil {
+			logger.Fatal("Failed to initialize Kafka Producer", "error", err)
+		}
+		if saramaConfig.Producer.Compression == sarama.CompressionZSTD {
+			saramaConfig.Version = sarama.V2_1_0_0 // ZSTD requires Kafka 2.1+
+		}
+
+		// Partition by message key so per-user ordering survives any partition count
+		saramaConfig.Producer.Partitioner, err = partitionerFor(cfg.KafkaPartitioner)
+		if err != nil {
+			logger.Fatal("Failed to initialize Kafka Producer", "error", err)
+		}
+
+		var client sarama.Client
+		client, err = sarama.NewClient(cfg.KafkaBrokers, saramaConfig)
+		if err == nil {
+			producer, err = sarama.NewAsyncProducerFromClient(client)
+		}
+		producerMu.Lock()
+		producerClient = client
+		producerMu.Unlock()
 		if err != nil {
-			log.Fatalf("Failed to initialize Kafka Producer: %v", err)
+			logger.Fatal("Failed to initialize Kafka Producer", "error", err)
 		} else {
-			log.Println("Kafka Producer successfully initialized")
+			log.Info("Kafka Producer initialized", "brokers", cfg.KafkaBrokers)
 		}
+
+		// Drain acknowledgements into counters and per-message callbacks
+		producerDrain.Add(2)
+		go func() {
+			defer producerDrain.Done()
+			for msg := range producer.Successes() {
+				producerStats.succeeded.Add(1)
+				acknowledge(msg, nil)
+			}
+		}()
+		go func() {
+			defer producerDrain.Done()
+			for producerErr := range producer.Errors() {
+				producerStats.failed.Add(1)
+				log.Error("Failed to send Kafka message", "topic", producerErr.Msg.Topic, "error", producerErr.Err)
+				acknowledge(producerErr.Msg, producerErr.Err)
+			}
+		}()
 	})
 
 	return err
 }
 
-// SendToKafka sends a message to Kafka topic `red_packet_transactions`
-func SendToKafka(userID, redPacketID int64, amount float64) error {
-	cfg := config.LoadConfig()
-	err := initProducer(cfg)
-	if err != nil {
+// CloseProducer flushes buffered messages and waits for their acknowledgements.
+// It must be called on shutdown, after the last publisher has stopped.
+func CloseProducer() error {
+	producerMu.Lock()
+	defer producerMu.Unlock()
+
+	if producer == nil || producerClosed {
+		return nil
+	}
+	producerClosed = true
+
+	err := producer.Close()
+	producerDrain.Wait()
+	// A producer built from a client leaves it open
+	if closeErr := producerClient.Close(); err == nil {
+		err = closeErr
+	}
+	stats := GetProducerStats()
+	logger.GetLogger().Info("Kafka Producer flushed and closed",
+		"enqueued", stats.Enqueued, "succeeded", stats.Succeeded, "failed", stats.Failed, "rejected", stats.Rejected)
+	return err
+}
+
+// compressionFor maps KAFKA_COMPRESSION to a sarama codec
+func compressionFor(name string) (sarama.CompressionCodec, error) {
+	switch name {
+	case "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy", "":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	}
+	return sarama.CompressionNone, fmt.Errorf("unsupported Kafka compression %q", name)
+}
+
+// acknowledge invokes the completion callback stored in the message metadata
+func acknowledge(msg *sarama.ProducerMessage, err error) {
+	if done, ok := msg.Metadata.(func(error)); ok {
+		done(err)
+	}
+}
+
+// publishAsync enqueues a message without waiting for Kafka.
+// done is invoked exactly once with the delivery result, unless publishAsync returns an error.
+func publishAsync(message *sarama.ProducerMessage, done func(error)) error {
+	if err := initProducer(config.LoadConfig()); err != nil {
 		return fmt.Errorf("Failed to initialize Kafka Producer: %v", err)
 	}
 
-	// Construct Kafka message
-	message := &sarama.ProducerMessage{
-		Topic:     "red_packet_transactions",
-		Partition: int32(userID % 5), // Distribute across partitions
-		Value:     sarama.StringEncoder(fmt.Sprintf("%d,%d,%.2f", userID, redPacketID, amount)),
+	producerMu.RLock()
+	defer producerMu.RUnlock()
+	if producerClosed {
+		producerStats.rejected.Add(1)
+		return errors.New("Kafka Producer is closed")
+	}
+
+	enqueuedAt := time.Now()
+	message.Metadata = func(err error) {
+		metrics.KafkaProduceDuration.WithLabelValues(message.Topic, metrics.Result(err)).Observe(metrics.Since(enqueuedAt))
+		done(err)
+	}
+
+	// Bounded buffering: give up instead of piling up blocked goroutines
+	timer := time.NewTimer(producerTimeout)
+	defer timer.Stop()
+	select {
+	case producer.Input() <- message:
+		producerStats.enqueued.Add(1)
+		return nil
+	case <-timer.C:
+		producerStats.rejected.Add(1)
+		return errors.New("Kafka Producer buffer is full")
 	}
+}
+
+// NewGrabOutboxEvent builds the outbox row announcing a successful grab,
+// encoded with the configured event format and carrying the trace context of ctx
+func NewGrabOutboxEvent(ctx context.Context, userID, redPacketID uint, amount float64) (model.OutboxEvent, error) {
+	event := NewEvent(EventTypeRedPacketGrabbed, &GrabbedPayload{
+		UserID:      userID,
+		RedPacketID: redPacketID,
+		Amount:      amount,
+		Currency:    DefaultCurrency,
+	})
+	return newOutboxEvent(ctx, event)
+}
+
+// newOutboxEvent encodes an event into a pending outbox row keyed by its partition key
+func newOutboxEvent(ctx context.Context, event *Event) (model.OutboxEvent, error) {
+	payload, contentType, err := encodeEvent(event)
+	if err != nil {
+		return model.OutboxEvent{}, err
+	}
+
+	return model.OutboxEvent{
+		Topic:        TransactionsTopic,
+		EventKey:     event.Payload.PartitionKey(),
+		Payload:      payload,
+		ContentType:  contentType,
+		TraceContext: tracing.Inject(ctx),
+		Status:       model.OutboxStatusPending,
+	}, nil
+}
+
+// encodeEvent encodes an event with the configured event format
+func encodeEvent(event *Event) ([]byte, string, error) {
+	codec, err := CodecFor(config.LoadConfig().KafkaEventFormat)
+	if err != nil {
+		return nil, "", err
+	}
+
+	payload, err := codec.Encode(event)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode %s event: %v", event.Type, err)
+	}
+	return payload, codec.ContentType(), nil
+}
+
+// newMessage builds a keyed Kafka message carrying its content type header
+func newMessage(topic, key string, payload []byte, contentType string) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(payload),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(contentTypeHeader), Value: []byte(contentType)},
+		},
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), producerTimeout)
-	defer cancel()
+// publishOutboxEvent enqueues a single outbox event; done receives the delivery result.
+// The message continues the trace of the request that wrote the event.
+func publishOutboxEvent(event *model.OutboxEvent, done func(error)) error {
+	message := newMessage(event.Topic, event.EventKey, event.Payload, event.ContentType)
+	span := startPublishSpan(tracing.Extract(context.Background(), event.TraceContext), message)
+	return publishTraced(message, span, done)
+}
+
+// publishTraced enqueues a message like publishAsync, ending span with the delivery result
+func publishTraced(message *sarama.ProducerMessage, span trace.Span, done func(error)) error {
+	err := publishAsync(message, func(err error) {
+		tracing.End(span, err)
+		done(err)
+	})
+	if err != nil {
+		tracing.End(span, err)
+	}
+	return err
+}
+
+// ErrDeliveryUnknown is returned when the caller stopped waiting before Kafka answered;
+// the message may still be delivered.
+var ErrDeliveryUnknown = errors.New("Kafka delivery outcome unknown")
 
-	// Retry mechanism
-	err = retryWithBackoff(ctx, func() error {
-		_, _, err := producer.SendMessage(message)
+// publishAndWait publishes a message and waits until Kafka acknowledges it,
+// returning ErrDeliveryUnknown after producerTimeout without an answer
+func publishAndWait(message *sarama.ProducerMessage) error {
+	acked := make(chan error, 1)
+	if err := publishAsync(message, func(err error) { acked <- err }); err != nil {
 		return err
-	}, 3)
+	}
 
+	timer := time.NewTimer(producerTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-acked:
+		return err
+	case <-timer.C:
+		return ErrDeliveryUnknown
+	}
+}
+
+// PublishClaimedEvent publishes a fast mode claim and waits until Kafka acknowledges it.
+// The claim only exists in Redis until the worker consumes this event.
+func PublishClaimedEvent(ctx context.Context, userID, redPacketID uint, amount float64) error {
+	event := NewEvent(EventTypeRedPacketClaimed, &ClaimedPayload{GrabbedPayload{
+		UserID:      userID,
+		RedPacketID: redPacketID,
+		Amount:      amount,
+		Currency:    DefaultCurrency,
+	}})
+
+	payload, contentType, err := encodeEvent(event)
 	if err != nil {
-		log.Printf("Failed to send Kafka message: %v", err)
 		return err
 	}
 
-	log.Printf("Kafka message sent: UserID=%d, RedPacketID=%d, Amount=%.2f", userID, redPacketID, amount)
-	return nil
+	acked := make(chan error, 1)
+	message := newMessage(TransactionsTopic, event.Payload.PartitionKey(), payload, contentType)
+	span := startPublishSpan(ctx, message)
+	if err := publishTraced(message, span, func(err error) { acked <- err }); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-acked:
+		return err
+	case <-ctx.Done():
+		return ErrDeliveryUnknown
+	}
 }
@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"red-packet-system/pkg/tracing"
+)
+
+// producerHeaders carries the trace context in the headers of a produced message
+type producerHeaders struct {
+	message *sarama.ProducerMessage
+}
+
+func (h producerHeaders) Get(key string) string {
+	for _, header := range h.message.Headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (h producerHeaders) Set(key, value string) {
+	for i, header := range h.message.Headers {
+		if string(header.Key) == key {
+			h.message.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	h.message.Headers = append(h.message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (h producerHeaders) Keys() []string {
+	keys := make([]string, 0, len(h.message.Headers))
+	for _, header := range h.message.Headers {
+		keys = append(keys, string(header.Key))
+	}
+	return keys
+}
+
+// consumerHeaders reads the trace context from the headers of a consumed message
+type consumerHeaders []*sarama.RecordHeader
+
+func (h consumerHeaders) Get(key string) string {
+	for _, header := range h {
+		if header != nil && string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (h consumerHeaders) Set(string, string) {}
+
+func (h consumerHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for _, header := range h {
+		if header != nil {
+			keys = append(keys, string(header.Key))
+		}
+	}
+	return keys
+}
+
+// startPublishSpan starts a producer span within the trace of ctx and writes its context
+// into the message headers. The span must be ended with the delivery result.
+func startPublishSpan(ctx context.Context, message *sarama.ProducerMessage) trace.Span {
+	ctx, span := tracing.StartInTrace(ctx, "kafka.publish "+message.Topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", message.Topic),
+		))
+	otel.GetTextMapPropagator().Inject(ctx, producerHeaders{message})
+	return span
+}
+
+// startProcessSpan starts the consumer span of a message, continuing the trace found in its headers
+func startProcessSpan(msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), consumerHeaders(msg.Headers))
+	return tracing.Start(ctx, "kafka.process "+msg.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", msg.Topic),
+			attribute.Int64("messaging.kafka.destination.partition", int64(msg.Partition)),
+			attribute.Int64("messaging.kafka.message.offset", msg.Offset),
+		))
+}
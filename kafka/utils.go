@@ -19,7 +19,7 @@ func retryWithBackoff(ctx context.Context, operation func() error, maxKafkaRetri
 	for i := 0; i < maxKafkaRetries; i++ {
 		select {
 		case <-ctx.Done():
-			log.Println("Context timeout reached, stopping retries")
+			log.WarnContext(ctx, "Context done, stopping retries", "error", ctx.Err())
 			return ctx.Err()
 		default:
 			err = operation()
@@ -27,7 +27,7 @@ func retryWithBackoff(ctx context.Context, operation func() error, maxKafkaRetri
 				return nil // Operation succeeded
 			}
 
-			log.Printf("Operation failed (attempt %d/%d): %v", i+1, maxKafkaRetries, err)
+			log.WarnContext(ctx, "Operation failed", "attempt", i+1, "max_attempts", maxKafkaRetries, "error", err)
 			time.Sleep(backoff)
 			backoff *= 2 // Double the backoff duration for each retry
 		}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// ArchivedRedPacket is a finished red packet moved out of the hot red_packets table
+type ArchivedRedPacket struct {
+	RedPacket  `gorm:"embedded"`
+	ArchivedAt time.Time
+}
+
+// TableName keeps archived red packets in their own table
+func (ArchivedRedPacket) TableName() string {
+	return "red_packets_archive"
+}
+
+// ArchivedRedPacketLog is a claim log of an archived red packet
+type ArchivedRedPacketLog struct {
+	RedPacketLog `gorm:"embedded"`
+}
+
+// TableName keeps the claim logs of every shard in one archive table
+func (ArchivedRedPacketLog) TableName() string {
+	return "red_packet_logs_archive"
+}
+
+// ArchiveCheckpoint records how far an archive job got, so it resumes after a restart
+type ArchiveCheckpoint struct {
+	Job       string    `gorm:"primaryKey"`
+	LastID    uint      `gorm:"not null;default:0"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
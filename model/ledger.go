@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// Ledger account types
+const (
+	AccountTypeUser      = "user"       // Spendable wallet of a user, mirrored in User.Balance
+	AccountTypeRedPacket = "red_packet" // Escrow holding the unclaimed funds of a red packet
+	AccountTypeExternal  = "external"   // Money entering or leaving the system
+	AccountTypePayout    = "payout"     // Withdrawals of a user awaiting provider settlement
+)
+
+// Journal entry types
+const (
+	EntryTypeOpeningBalance = "opening_balance" // Backfill of balances that predate the ledger
+	EntryTypePacketFunding  = "packet_funding"  // Sender wallet -> red packet escrow
+	EntryTypeGrabCredit     = "grab_credit"     // Red packet escrow -> grabber wallet
+	EntryTypePacketRefund   = "packet_refund"   // Red packet escrow -> sender wallet on expiry
+	EntryTypeDeposit        = "deposit"         // External -> user wallet once the provider settles
+	EntryTypeWithdrawalHold = "withdrawal_hold" // User wallet -> payout hold when a withdrawal starts
+	EntryTypeWithdrawal     = "withdrawal"      // Payout hold -> external once the provider settles
+	EntryTypeWithdrawalVoid = "withdrawal_void" // Payout hold -> user wallet when the payout fails
+)
+
+// LedgerAccount is identified by its type and owner (user ID, red packet ID, or 0 for external)
+type LedgerAccount struct {
+	ID        uint      `gorm:"primaryKey"`
+	Type      string    `gorm:"not null;uniqueIndex:uk_ledger_accounts_type_owner"`
+	OwnerID   uint      `gorm:"not null;uniqueIndex:uk_ledger_accounts_type_owner"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// JournalEntry groups postings that sum to zero. Reference is unique so each
+// business event (e.g. `grab:<red_packet_id>:<user_id>`) is posted once.
+type JournalEntry struct {
+	ID        uint      `gorm:"primaryKey"`
+	Type      string    `gorm:"not null"`
+	Reference string    `gorm:"unique;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// Posting moves Amount cents into (positive) or out of (negative) an account
+type Posting struct {
+	ID             uint      `gorm:"primaryKey"`
+	JournalEntryID uint      `gorm:"not null"`
+	AccountID      uint      `gorm:"not null"`
+	Amount         int64     `gorm:"not null"`
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+}
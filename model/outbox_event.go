@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Outbox event delivery states
+const (
+	OutboxStatusPending = 0
+	OutboxStatusSent    = 1
+	OutboxStatusDead    = 2 // Gave up after OUTBOX_MAX_ATTEMPTS failures, requeue by resetting status and attempts
+)
+
+// OutboxEvent is a Kafka message written in the same transaction as the
+// business change, published later by the outbox relay.
+type OutboxEvent struct {
+	ID           uint   `gorm:"primaryKey"`
+	Topic        string `gorm:"not null"`
+	EventKey     string `gorm:"not null"`
+	Payload      []byte `gorm:"type:blob;not null"`
+	ContentType  string `gorm:"not null"`
+	TraceContext string `gorm:"type:varchar(512);not null;default:''"` // Trace context of the writing request, see tracing.Inject
+	Status       int    `gorm:"default:0"`
+	Attempts     int    `gorm:"default:0"`
+	LastError    string `gorm:"type:text"`
+	SentAt       *time.Time
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
@@ -2,13 +2,30 @@ package model
 
 import "time"
 
+// Red packet statuses
+const (
+	RedPacketStatusExpired = 0
+	RedPacketStatusActive  = 1
+)
+
+// Grab modes selectable per red packet
+const (
+	GrabModeStrict = "strict" // Redlock + MySQL transaction on every grab
+	GrabModeFast   = "fast"   // Redis Lua claim, MySQL persisted by the Kafka worker
+)
+
 type RedPacket struct {
-	ID              uint      `gorm:"primaryKey"`
-	TotalAmount     float64   `gorm:"not null"`
-	RemainingAmount float64   `gorm:"not null"`
-	TotalCount      int       `gorm:"not null"`
-	RemainingCount  int       `gorm:"not null"`
-	Status          int       `gorm:"default:1"`
+	ID              uint    `gorm:"primaryKey"`
+	SenderID        uint    `gorm:"default:0"` // 0 for system funded packets
+	TotalAmount     float64 `gorm:"not null"`
+	RemainingAmount float64 `gorm:"not null"`
+	TotalCount      int     `gorm:"not null"`
+	RemainingCount  int     `gorm:"not null"`
+	Status          int     `gorm:"default:1"`
+	GrabMode        string  `gorm:"default:strict"`
+	RefundedAmount  float64 `gorm:"default:0"`
+	RefundedAt      *time.Time
+	Version         int64     `gorm:"not null;default:0"` // Optimistic concurrency version, bumped by every counter update
 	CreatedAt       time.Time `gorm:"autoCreateTime"`
 	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
 }
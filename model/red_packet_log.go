@@ -2,10 +2,13 @@ package model
 
 import "time"
 
+// RedPacketLog rows live in the red_packet_logs_NN shard of their red packet,
+// access them through db.Logs and db.FanOutLogs
 type RedPacketLog struct {
 	ID          uint      `gorm:"primaryKey"`
 	UserID      uint      `gorm:"not null"`
 	RedPacketID uint      `gorm:"not null"`
+	ClaimUserID *uint     // Set on fast mode claims only, unique per red packet
 	Amount      float64   `gorm:"not null"`
 	CreatedAt   time.Time `gorm:"autoCreateTime"`
 	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
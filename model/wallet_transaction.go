@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Wallet transaction types
+const (
+	WalletTransactionDeposit    = "deposit"
+	WalletTransactionWithdrawal = "withdrawal"
+)
+
+// Wallet transaction states
+const (
+	WalletStatusPending = "pending" // Waiting for the payment provider callback
+	WalletStatusSettled = "settled"
+	WalletStatusFailed  = "failed"
+)
+
+// WalletTransaction tracks a deposit or withdrawal through a payment provider.
+// Reference is our idempotency key, echoed back by the provider in callbacks.
+type WalletTransaction struct {
+	ID                uint      `gorm:"primaryKey"`
+	UserID            uint      `gorm:"not null"`
+	Type              string    `gorm:"not null"`
+	Amount            float64   `gorm:"not null"`
+	Status            string    `gorm:"not null;default:pending"`
+	Provider          string    `gorm:"not null"`
+	Reference         string    `gorm:"unique;not null"`
+	ProviderReference string    `gorm:"default:''"`
+	FailureReason     string    `gorm:"default:''"`
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time `gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,152 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// FakeProviderName is the name of the in-process fake provider
+const FakeProviderName = "fake"
+
+// fakeSignatureHeader carries the HMAC-SHA256 of the callback body
+const fakeSignatureHeader = "X-Fake-Signature"
+
+// FakeProvider is an in-process provider for tests and local runs. Operations stay
+// pending until Complete is called, or settle immediately with AutoSettle.
+type FakeProvider struct {
+	secret []byte
+
+	// AutoSettle delivers a settled callback to Notify right after each Charge/Payout
+	AutoSettle bool
+	// Notify receives callbacks produced by Complete and AutoSettle
+	Notify func(ctx context.Context, callback *Callback) error
+
+	mu       sync.Mutex
+	pending  map[string]Request // Provider reference -> request
+	failNext error
+	sequence atomic.Uint64
+}
+
+// NewFakeProvider creates a fake provider signing callbacks with secret
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{
+		secret:  []byte(secret),
+		pending: make(map[string]Request),
+	}
+}
+
+// Name returns FakeProviderName
+func (p *FakeProvider) Name() string { return FakeProviderName }
+
+// FailNext makes the next Charge/Payout return err, a refusal if it wraps ErrDeclined
+func (p *FakeProvider) FailNext(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failNext = err
+}
+
+// Charge records a pending deposit
+func (p *FakeProvider) Charge(ctx context.Context, req Request) (string, error) {
+	return p.start(ctx, "ch", req)
+}
+
+// Payout records a pending withdrawal
+func (p *FakeProvider) Payout(ctx context.Context, req Request) (string, error) {
+	return p.start(ctx, "po", req)
+}
+
+// start records a pending operation and settles it right away with AutoSettle
+func (p *FakeProvider) start(ctx context.Context, prefix string, req Request) (string, error) {
+	p.mu.Lock()
+	if err := p.failNext; err != nil {
+		p.failNext = nil
+		p.mu.Unlock()
+		return "", err
+	}
+	providerReference := fmt.Sprintf("fake_%s_%d", prefix, p.sequence.Add(1))
+	p.pending[providerReference] = req
+	p.mu.Unlock()
+
+	if p.AutoSettle {
+		// May run before the caller stores the provider reference. The callback carries
+		// it, and the wallet transaction it settles exists before Charge/Payout is called.
+		go p.Complete(context.WithoutCancel(ctx), providerReference, StatusSettled, "")
+	}
+	return providerReference, nil
+}
+
+// Pending returns the requests still waiting for a callback, by provider reference
+func (p *FakeProvider) Pending() map[string]Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := make(map[string]Request, len(p.pending))
+	for ref, req := range p.pending {
+		pending[ref] = req
+	}
+	return pending
+}
+
+// Complete finishes a pending operation and delivers its callback to Notify
+func (p *FakeProvider) Complete(ctx context.Context, providerReference, status, reason string) error {
+	p.mu.Lock()
+	req, ok := p.pending[providerReference]
+	delete(p.pending, providerReference)
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown fake payment %q", providerReference)
+	}
+	if p.Notify == nil {
+		return nil
+	}
+	return p.Notify(ctx, &Callback{
+		Reference:         req.Reference,
+		ProviderReference: providerReference,
+		Status:            status,
+		Reason:            reason,
+	})
+}
+
+// SignCallback returns the body and signature header value of a callback, as the
+// provider would send it over HTTP
+func (p *FakeProvider) SignCallback(callback *Callback) ([]byte, string, error) {
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, p.sign(body), nil
+}
+
+// ParseCallback verifies the HMAC signature and decodes the JSON body
+func (p *FakeProvider) ParseCallback(r *http.Request) (*Callback, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, ErrInvalidCallback
+	}
+
+	if !hmac.Equal([]byte(r.Header.Get(fakeSignatureHeader)), []byte(p.sign(body))) {
+		return nil, ErrInvalidCallback
+	}
+
+	var callback Callback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, ErrInvalidCallback
+	}
+	return &callback, nil
+}
+
+// sign computes the hex HMAC-SHA256 of body
+func (p *FakeProvider) sign(body []byte) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
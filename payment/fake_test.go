@@ -0,0 +1,126 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// callbackRequest builds the HTTP request the provider would send
+func callbackRequest(body []byte, signature string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/payments/fake/callback", bytes.NewReader(body))
+	req.Header.Set(fakeSignatureHeader, signature)
+	return req
+}
+
+func TestFakeProviderCallbackSignature(t *testing.T) {
+	provider := NewFakeProvider("secret")
+	callback := &Callback{Reference: "dep_1", ProviderReference: "fake_ch_1", Status: StatusSettled}
+	body, signature, err := provider.SignCallback(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := provider.ParseCallback(callbackRequest(body, signature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *callback {
+		t.Fatalf("expected %+v, got %+v", callback, parsed)
+	}
+
+	tampered := bytes.Replace(body, []byte(StatusSettled), []byte(StatusFailed), 1)
+	_, otherSignature, _ := NewFakeProvider("other").SignCallback(callback)
+	for name, req := range map[string]*http.Request{
+		"tampered body":  callbackRequest(tampered, signature),
+		"other secret":   callbackRequest(body, otherSignature),
+		"missing header": callbackRequest(body, ""),
+		"malformed body": callbackRequest([]byte("{"), provider.sign([]byte("{"))),
+	} {
+		if _, err := provider.ParseCallback(req); !errors.Is(err, ErrInvalidCallback) {
+			t.Errorf("%s: expected ErrInvalidCallback, got %v", name, err)
+		}
+	}
+}
+
+func TestFakeProviderComplete(t *testing.T) {
+	provider := NewFakeProvider("secret")
+	var delivered []Callback
+	provider.Notify = func(ctx context.Context, callback *Callback) error {
+		delivered = append(delivered, *callback)
+		return nil
+	}
+
+	ctx := context.Background()
+	charge, err := provider.Charge(ctx, Request{Reference: "dep_1", UserID: 1, Amount: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payout, err := provider.Payout(ctx, Request{Reference: "wd_1", UserID: 1, Amount: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.Pending()) != 2 {
+		t.Fatalf("expected 2 pending operations, got %v", provider.Pending())
+	}
+
+	if err := provider.Complete(ctx, charge, StatusSettled, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Complete(ctx, payout, StatusFailed, "card declined"); err != nil {
+		t.Fatal(err)
+	}
+	want := []Callback{
+		{Reference: "dep_1", ProviderReference: charge, Status: StatusSettled},
+		{Reference: "wd_1", ProviderReference: payout, Status: StatusFailed, Reason: "card declined"},
+	}
+	if len(delivered) != len(want) || delivered[0] != want[0] || delivered[1] != want[1] {
+		t.Fatalf("expected callbacks %+v, got %+v", want, delivered)
+	}
+
+	// A completed operation cannot be completed again
+	if err := provider.Complete(ctx, charge, StatusFailed, ""); err == nil {
+		t.Fatal("expected an error completing twice")
+	}
+	if len(delivered) != 2 || len(provider.Pending()) != 0 {
+		t.Fatalf("second completion was delivered: %+v, pending %v", delivered, provider.Pending())
+	}
+}
+
+func TestFakeProviderFailNextAndAutoSettle(t *testing.T) {
+	provider := NewFakeProvider("secret")
+	provider.AutoSettle = true
+	delivered := make(chan *Callback, 1)
+	provider.Notify = func(ctx context.Context, callback *Callback) error {
+		delivered <- callback
+		return nil
+	}
+
+	ctx := context.Background()
+	declined := errors.New("declined")
+	provider.FailNext(declined)
+	if _, err := provider.Charge(ctx, Request{Reference: "dep_1"}); !errors.Is(err, declined) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+	if len(provider.Pending()) != 0 {
+		t.Fatal("a failed charge must not stay pending")
+	}
+
+	// Only the next call fails
+	charge, err := provider.Charge(ctx, Request{Reference: "dep_2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case callback := <-delivered:
+		if callback.Reference != "dep_2" || callback.ProviderReference != charge || callback.Status != StatusSettled {
+			t.Fatalf("unexpected callback %+v", callback)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AutoSettle did not deliver a callback")
+	}
+}
@@ -0,0 +1,49 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Final states reported by provider callbacks
+const (
+	StatusSettled = "settled"
+	StatusFailed  = "failed"
+)
+
+// ErrInvalidCallback is returned when a callback cannot be verified or decoded
+var ErrInvalidCallback = errors.New("invalid payment callback")
+
+// ErrDeclined is wrapped by Charge and Payout errors when the provider definitely refused the
+// operation. Any other error leaves its outcome to the callback.
+var ErrDeclined = errors.New("payment declined")
+
+// Request asks a provider to move money for a wallet transaction
+type Request struct {
+	Reference string // Wallet transaction reference, echoed back in the callback
+	UserID    uint
+	Amount    float64
+	Currency  string
+}
+
+// Callback is the asynchronous outcome of a charge or payout
+type Callback struct {
+	Reference         string `json:"reference"`
+	ProviderReference string `json:"provider_reference"`
+	Status            string `json:"status"`
+	Reason            string `json:"reason,omitempty"`
+}
+
+// Provider abstracts a payment service provider. Charge and Payout only start the
+// operation; the final state always arrives through a callback, unless they return ErrDeclined.
+type Provider interface {
+	// Name identifies the provider in wallet transactions and callback URLs
+	Name() string
+	// Charge collects a deposit from the user's external payment method
+	Charge(ctx context.Context, req Request) (providerReference string, err error)
+	// Payout sends a withdrawal to the user's external payment method
+	Payout(ctx context.Context, req Request) (providerReference string, err error)
+	// ParseCallback verifies and decodes a callback request sent by the provider
+	ParseCallback(r *http.Request) (*Callback, error)
+}
@@ -0,0 +1,120 @@
+// Package health serves the liveness and readiness endpoints of the API server and the Kafka worker.
+// Liveness only tells that the process answers; readiness runs dependency checks, each within a timeout.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Readiness statuses
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"    // An optional dependency failed, traffic is still served
+	StatusUnavailable = "unavailable" // A required dependency failed
+)
+
+// Check is one dependency probe
+type Check struct {
+	Name string
+	// Optional checks report their failures without failing readiness, e.g. a read replica
+	// whose reads fall back to the master
+	Optional bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+	Optional bool    `json:"optional,omitempty"`
+}
+
+// Report is the body served by the readiness endpoint
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Run runs the checks concurrently, each bounded by timeout
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := run(ctx, timeout, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			switch {
+			case result.Status == StatusOK:
+			case check.Optional:
+				if report.Status == StatusOK {
+					report.Status = StatusDegraded
+				}
+			default:
+				report.Status = StatusUnavailable
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+// run runs a single check, giving up when the timeout expires even if the probe ignores ctx
+func run(ctx context.Context, timeout time.Duration, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check.Run(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{Status: StatusOK, Duration: float64(time.Since(start).Microseconds()) / 1000, Optional: check.Optional}
+	if err != nil {
+		result.Status, result.Error = StatusUnavailable, err.Error()
+	}
+	return result
+}
+
+// LiveHandler answers 200 as long as the process serves HTTP
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+	})
+}
+
+// ReadyHandler runs the checks on every request and answers 503 when a required one fails
+func ReadyHandler(timeout time.Duration, checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), timeout, checks)
+		code := http.StatusOK
+		if report.Status == StatusUnavailable {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,63 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) error     { return nil }
+func failed(context.Context) error { return errors.New("connection refused") }
+
+// hang ignores its context, as a client without deadlines would
+func hang(context.Context) error {
+	time.Sleep(time.Second)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []Check
+		status string
+	}{
+		{"all ok", []Check{{Name: "a", Run: ok}, {Name: "b", Run: ok}}, StatusOK},
+		{"optional failed", []Check{{Name: "a", Run: ok}, {Name: "b", Optional: true, Run: failed}}, StatusDegraded},
+		{"required failed", []Check{{Name: "a", Run: failed}, {Name: "b", Optional: true, Run: failed}}, StatusUnavailable},
+		{"timed out", []Check{{Name: "a", Run: hang}}, StatusUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			report := Run(context.Background(), 50*time.Millisecond, tt.checks)
+			if report.Status != tt.status {
+				t.Fatalf("expected %s, got %+v", tt.status, report)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("expected %d results, got %+v", len(tt.checks), report.Checks)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Fatalf("checks were not bounded by the timeout, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	for _, tc := range []struct {
+		check Check
+		code  int
+	}{
+		{Check{Name: "replica", Optional: true, Run: failed}, http.StatusOK},
+		{Check{Name: "master", Run: failed}, http.StatusServiceUnavailable},
+	} {
+		recorder := httptest.NewRecorder()
+		ReadyHandler(time.Second, tc.check).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if recorder.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.check.Name, tc.code, recorder.Code, recorder.Body)
+		}
+	}
+}
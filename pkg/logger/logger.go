@@ -1,19 +1,152 @@
+// Package logger provides the process-wide structured logger, built on log/slog.
+// Records are written as text or JSON, the level can be changed at runtime, and records
+// logged with a context carry its request ID and trace IDs.
 package logger
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
-	"sync"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// Log formats (LOG_FORMAT)
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configures the logger, see Init
+type Options struct {
+	Format string    // text (default) or json
+	Level  string    // debug, info (default), warn or error
+	Output io.Writer // os.Stdout when nil
+	// Sampled loggers keep the first SampleFirst records of a message every second,
+	// then one in SampleThereafter (0 drops the rest). Warnings and errors are never sampled.
+	SampleFirst      int
+	SampleThereafter int
+}
+
 var (
-	instance *log.Logger
-	once     sync.Once
+	level    = new(slog.LevelVar)
+	instance atomic.Pointer[slog.Logger]
+	sampled  atomic.Pointer[slog.Logger]
 )
 
-func GetLogger() *log.Logger {
-	once.Do(func() {
-		instance = log.New(os.Stdout, "[LOG] ", log.Ldate|log.Ltime|log.Lshortfile)
+func init() {
+	Init(Options{})
+}
+
+// Init replaces the process logger. It also becomes the slog and log package default,
+// so libraries writing to the standard logger end up in the same output.
+func Init(opts Options) error {
+	lvl, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	if opts.SampleFirst <= 0 {
+		opts.SampleFirst = 100
+	}
+	if opts.SampleThereafter < 0 {
+		opts.SampleThereafter = 0
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case FormatText, "":
+		handler = slog.NewTextHandler(output, handlerOptions)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(output, handlerOptions)
+	default:
+		return fmt.Errorf("unsupported log format %q", opts.Format)
+	}
+	handler = contextHandler{handler}
+
+	level.Set(lvl)
+	logger := slog.New(handler)
+	instance.Store(logger)
+	sampled.Store(slog.New(newSamplingHandler(handler, opts.SampleFirst, opts.SampleThereafter, time.Second)))
+	slog.SetDefault(logger)
+	return nil
+}
+
+// GetLogger returns the process logger
+func GetLogger() *slog.Logger {
+	return instance.Load()
+}
+
+// Sampled returns the logger of high-volume paths such as grabs, see Options
+func Sampled() *slog.Logger {
+	return sampled.Load()
+}
+
+// Fatal logs msg at error level and exits
+func Fatal(msg string, args ...any) {
+	GetLogger().Error(msg, args...)
+	os.Exit(1)
+}
+
+// ParseLevel parses debug, info, warn or error ("" is info)
+func ParseLevel(name string) (slog.Level, error) {
+	var lvl slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := lvl.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unsupported log level %q", name)
+	}
+	return lvl, nil
+}
+
+// SetLevel changes the level of every logger at runtime
+func SetLevel(name string) error {
+	lvl, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+	return nil
+}
+
+// Level returns the current level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// LevelHandler reports the level on GET and changes it on PUT with a {"level": "debug"} body
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid body", http.StatusBadRequest)
+				return
+			}
+			if err := SetLevel(body.Level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			GetLogger().Warn("Log level changed", "level", Level().String())
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": Level().String()})
 	})
-	return instance
 }
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestContextFieldsAndLevel(t *testing.T) {
+	var out bytes.Buffer
+	if err := Init(Options{Format: FormatJSON, Level: "warn", Output: &out}); err != nil {
+		t.Fatal(err)
+	}
+	defer Init(Options{})
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	GetLogger().InfoContext(ctx, "dropped")
+	GetLogger().WarnContext(ctx, "kept")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", out.String(), err)
+	}
+	if record["msg"] != "kept" || record["request_id"] != "req-1" {
+		t.Fatalf("unexpected record %v", record)
+	}
+
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	if Level() != slog.LevelDebug {
+		t.Fatalf("expected debug level, got %s", Level())
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+}
+
+func TestSampling(t *testing.T) {
+	var out bytes.Buffer
+	if err := Init(Options{Output: &out, SampleFirst: 2, SampleThereafter: 3}); err != nil {
+		t.Fatal(err)
+	}
+	defer Init(Options{})
+
+	for i := 0; i < 8; i++ {
+		Sampled().Info("grab")
+	}
+	Sampled().Error("grab failed")
+
+	// First 2, then the 3rd and 6th of the remaining 6, and every error
+	if lines := strings.Count(out.String(), "\n"); lines != 5 {
+		t.Fatalf("expected 5 records, got %d:\n%s", lines, out.String())
+	}
+}
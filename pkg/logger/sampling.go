@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sampler counts records per message over a tick, shared by the handlers derived with WithAttrs
+type sampler struct {
+	first, thereafter int
+	tick              time.Duration
+
+	mu      sync.Mutex
+	resetAt time.Time
+	counts  map[string]int
+}
+
+// allow reports whether the nth record of message in the current tick is kept
+func (s *sampler) allow(message string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.After(s.resetAt) {
+		s.resetAt = now.Add(s.tick)
+		clear(s.counts)
+	}
+	s.counts[message]++
+	n := s.counts[message]
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// samplingHandler drops info and debug records over the sampling budget of their message
+type samplingHandler struct {
+	slog.Handler
+	sampler *sampler
+}
+
+func newSamplingHandler(handler slog.Handler, first, thereafter int, tick time.Duration) samplingHandler {
+	return samplingHandler{
+		Handler: handler,
+		sampler: &sampler{first: first, thereafter: thereafter, tick: tick, counts: map[string]int{}},
+	}
+}
+
+func (h samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn && !h.sampler.allow(r.Message, r.Time) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return samplingHandler{Handler: h.Handler.WithAttrs(attrs), sampler: h.sampler}
+}
+
+func (h samplingHandler) WithGroup(name string) slog.Handler {
+	return samplingHandler{Handler: h.Handler.WithGroup(name), sampler: h.sampler}
+}
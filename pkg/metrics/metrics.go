@@ -0,0 +1,141 @@
+// Package metrics defines the Prometheus collectors of the API server and the Kafka worker.
+// They are registered with the default registry and served by Handler on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "red_packet"
+
+// Latency buckets from 0.5ms to 10s, the range of Redis calls up to timed out requests
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	// HTTPRequestDuration is the API latency per route and status code
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "API request latency by route and status code.",
+		Buckets:   latencyBuckets,
+	}, []string{"method", "route", "code"})
+
+	// GrabResults counts grabs by outcome (success, empty, busy, not_found, duplicate, error)
+	GrabResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grabs_total",
+		Help:      "Red packet grabs by result.",
+	}, []string{"result"})
+
+	// LockAcquireDuration is the time spent acquiring a Redlock, by result (acquired, failed)
+	LockAcquireDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redlock_acquire_duration_seconds",
+		Help:      "Time to acquire a Redlock.",
+		Buckets:   latencyBuckets,
+	}, []string{"result"})
+
+	// LuaScriptDuration is the latency of the Redis Lua scripts, by script and result (ok, error)
+	LuaScriptDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_lua_duration_seconds",
+		Help:      "Redis Lua script latency.",
+		Buckets:   latencyBuckets,
+	}, []string{"script", "result"})
+
+	// DBQueryDuration is the GORM statement latency, by operation, table and result (ok, error)
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "GORM statement latency.",
+		Buckets:   latencyBuckets,
+	}, []string{"operation", "table", "result"})
+
+	// KafkaProduceDuration is the time from enqueueing a message to its acknowledgement
+	KafkaProduceDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kafka_produce_duration_seconds",
+		Help:      "Time from enqueueing a Kafka message to its acknowledgement, by topic and result.",
+		Buckets:   latencyBuckets,
+	}, []string{"topic", "result"})
+
+	// OutboxLag is the time an outbox event waited between its commit and its acknowledgement by Kafka
+	OutboxLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "outbox_lag_seconds",
+		Help:      "Time from committing an outbox event to its acknowledgement by Kafka.",
+		Buckets:   latencyBuckets,
+	})
+
+	// OutboxDeadEvents counts outbox events the relay gave up on after OUTBOX_MAX_ATTEMPTS failures
+	OutboxDeadEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_dead_events_total",
+		Help:      "Outbox events marked dead after repeated publish failures.",
+	})
+
+	// KafkaConsumerLag is the number of messages a partition consumer is behind the high water mark
+	KafkaConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag_messages",
+		Help:      "Messages between the last consumed offset and the partition high water mark.",
+	}, []string{"topic", "partition"})
+
+	// KafkaConsumeDelay is the time from producing an event to the end of its handling
+	KafkaConsumeDelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kafka_consume_delay_seconds",
+		Help:      "Time from an event occurring to its handling by the worker, by event type.",
+		Buckets:   latencyBuckets,
+	}, []string{"type"})
+
+	// KafkaConsumed counts consumed messages by event type and result (ok, failed, invalid, unhandled)
+	KafkaConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumed_messages_total",
+		Help:      "Consumed Kafka messages by event type and result.",
+	}, []string{"type", "result"})
+
+	// DLQMessages counts messages sent to the dead letter topic, by reason (invalid, failed) and result (ok, error)
+	DLQMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_dlq_messages_total",
+		Help:      "Messages moved to the dead letter topic by reason and publish result.",
+	}, []string{"reason", "result"})
+)
+
+// Handler serves the default registry
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Since returns the seconds elapsed since start, for Observe
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Result labels an outcome "ok" or "error"
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// ProducerMessages exposes an async producer counter, read on every scrape,
+// as red_packet_kafka_producer_messages_total{result}
+func ProducerMessages(result string, value func() uint64) {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "kafka_producer_messages_total",
+		Help:        "Async producer messages by result.",
+		ConstLabels: prometheus.Labels{"result": result},
+	}, func() float64 {
+		return float64(value())
+	})
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scrape returns the exposition served by Handler
+func scrape(t *testing.T) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestResult(t *testing.T) {
+	if got := Result(nil); got != "ok" {
+		t.Fatalf("Result(nil) is %q, want ok", got)
+	}
+	if got := Result(errors.New("timeout")); got != "error" {
+		t.Fatalf("Result(error) is %q, want error", got)
+	}
+}
+
+func TestCollectorsFollowNamingConventions(t *testing.T) {
+	// Vectors are only exposed once they have a child
+	HTTPRequestDuration.WithLabelValues("POST", "/red-packets/:id/grab", "200").Observe(0.01)
+	GrabResults.WithLabelValues("success").Inc()
+	LockAcquireDuration.WithLabelValues("acquired").Observe(0.001)
+	LuaScriptDuration.WithLabelValues("decr_stock", "ok").Observe(0.001)
+	DBQueryDuration.WithLabelValues("query", "red_packets", "ok").Observe(0.002)
+	KafkaProduceDuration.WithLabelValues("transactions", "ok").Observe(0.02)
+	OutboxLag.Observe(0.1)
+	OutboxDeadEvents.Add(0)
+	KafkaConsumerLag.WithLabelValues("transactions", "0").Set(0)
+	KafkaConsumeDelay.WithLabelValues("red_packet.claimed").Observe(0.05)
+	KafkaConsumed.WithLabelValues("red_packet.claimed", "ok").Inc()
+	DLQMessages.WithLabelValues("failed", "ok").Inc()
+
+	problems, err := testutil.GatherAndLint(prometheus.DefaultGatherer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		if strings.HasPrefix(problem.Metric, namespace+"_") {
+			t.Errorf("%s: %s", problem.Metric, problem.Text)
+		}
+	}
+
+	body := scrape(t)
+	for _, want := range []string{
+		`red_packet_http_request_duration_seconds_bucket{code="200",method="POST",route="/red-packets/:id/grab",le="0.01"} `,
+		`red_packet_grabs_total{result="success"} `,
+		`red_packet_outbox_dead_events_total 0`,
+		`red_packet_kafka_dlq_messages_total{reason="failed",result="ok"} `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics does not expose %s", want)
+		}
+	}
+}
+
+func TestProducerMessagesReadOnScrape(t *testing.T) {
+	var sent atomic.Uint64
+	ProducerMessages("test", sent.Load)
+
+	sent.Store(3)
+	if body := scrape(t); !strings.Contains(body, `red_packet_kafka_producer_messages_total{result="test"} 3`) {
+		t.Fatal("scrape does not report the producer counter")
+	}
+	sent.Store(5)
+	if body := scrape(t); !strings.Contains(body, `red_packet_kafka_producer_messages_total{result="test"} 5`) {
+		t.Fatal("scrape does not read the producer counter again")
+	}
+}
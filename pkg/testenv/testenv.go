@@ -0,0 +1,72 @@
+// Package testenv provides in-process replacements for MySQL and the Redis cluster,
+// so the grab flow can run under `go test` without Docker.
+package testenv
+
+import (
+	"fmt"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"red-packet-system/db"
+	"red-packet-system/model"
+)
+
+// OpenSQLite opens an in-memory SQLite database with the tables of the MySQL migrations.
+// It keeps a single connection: the database lives as long as that connection, and
+// SQLite serializes writers anyway.
+func OpenSQLite() (*gorm.DB, error) {
+	sqliteDB, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		TranslateError: true, // Same as db.InitDB
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	pool, err := sqliteDB.DB()
+	if err != nil {
+		return nil, err
+	}
+	pool.SetMaxOpenConns(1)
+	pool.SetConnMaxLifetime(0)
+
+	if err := sqliteDB.AutoMigrate(
+		&model.User{},
+		&model.RedPacket{},
+		&model.OutboxEvent{},
+		&model.LedgerAccount{},
+		&model.JournalEntry{},
+		&model.Posting{},
+		&model.WalletTransaction{},
+		&model.ArchivedRedPacket{},
+		&model.ArchivedRedPacketLog{},
+		&model.ArchiveCheckpoint{},
+	); err != nil {
+		return nil, err
+	}
+
+	// Claim log shards, with the unique (red_packet_id, claim_user_id) key of migration 000014
+	for _, table := range append(db.LogTables(), db.LegacyLogTable) {
+		if err := sqliteDB.Table(table).AutoMigrate(&model.RedPacketLog{}); err != nil {
+			return nil, err
+		}
+		if err := sqliteDB.Exec(fmt.Sprintf("CREATE UNIQUE INDEX uk_%s_fast_claim ON %s (red_packet_id, claim_user_id)", table, table)).Error; err != nil {
+			return nil, err
+		}
+	}
+	return sqliteDB, nil
+}
+
+// StartRedis starts an in-process Redis server and returns it with a cluster client
+// connected to it, the client type used by redisclient. Lua scripts run on the server.
+// Call server.Close when done.
+func StartRedis() (*miniredis.Miniredis, *redis.ClusterClient, error) {
+	server, err := miniredis.Run()
+	if err != nil {
+		return nil, nil, err
+	}
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{server.Addr()}})
+	return server, client, nil
+}
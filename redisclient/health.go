@@ -0,0 +1,49 @@
+package redisclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"red-packet-system/pkg/health"
+)
+
+// HealthCheck returns the readiness check of the Redis Cluster: the cluster state must be ok
+// and every master must answer PING
+func HealthCheck() health.Check {
+	return health.Check{Name: "redis_cluster", Run: checkCluster}
+}
+
+// checkCluster reads cluster_state from CLUSTER INFO and pings every master
+func checkCluster(ctx context.Context) error {
+	if redisClient == nil {
+		return errors.New("Redis Cluster is not initialized")
+	}
+
+	info, err := redisClient.ClusterInfo(ctx).Result()
+	if err != nil {
+		return err
+	}
+	if state := clusterState(info); state != "ok" {
+		return fmt.Errorf("cluster_state is %q", state)
+	}
+
+	return redisClient.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		if err := client.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("%s: %w", client.Options().Addr, err)
+		}
+		return nil
+	})
+}
+
+// clusterState returns the cluster_state field of a CLUSTER INFO reply
+func clusterState(info string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "cluster_state:"); ok {
+			return value
+		}
+	}
+	return "unknown"
+}
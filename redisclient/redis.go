@@ -2,7 +2,6 @@ package redisclient
 
 import (
 	"context"
-	"log"
 	"strconv"
 	"time"
 
@@ -10,6 +9,7 @@ import (
 	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
 	"github.com/redis/go-redis/v9"
 	"red-packet-system/config"
+	"red-packet-system/pkg/logger"
 )
 
 const maxRedisRetries = 5
@@ -19,6 +19,7 @@ var redisClient *redis.ClusterClient
 
 // InitRedis initializes Redis Cluster with retry mechanism.
 func InitRedis(cfg *config.Config) *redis.ClusterClient {
+	log := logger.GetLogger()
 	opts := &redis.ClusterOptions{
 		Addrs:    cfg.RedisCluster,
 		Password: cfg.RedisPassword,
@@ -27,24 +28,25 @@ func InitRedis(cfg *config.Config) *redis.ClusterClient {
 	var err error
 	for i := 0; i < maxRedisRetries; i++ {
 		redisClient = redis.NewClusterClient(opts)
+		redisClient.AddHook(TracingHook())
 		_, err = redisClient.Ping(ctx).Result()
 		if err == nil {
-			log.Println("[INFO] Redis Cluster connected successfully")
+			log.Info("Redis Cluster connected")
 			return redisClient
 		}
 
-		log.Printf("[WARN] Redis connection attempt %d failed: %v", i+1, err)
+		log.Warn("Redis connection attempt failed", "attempt", i+1, "error", err)
 		time.Sleep(2 * time.Second)
 	}
 
-	log.Fatalf("[ERROR] Redis Cluster connection failed after retries: %v", err)
+	logger.Fatal("Redis Cluster connection failed after retries", "error", err)
 	return nil
 }
 
 // GetRedisClient returns the initialized Redis Cluster client.
 func GetRedisClient() *redis.ClusterClient {
 	if redisClient == nil {
-		log.Fatal("[ERROR] Redis Cluster is not initialized")
+		logger.Fatal("Redis Cluster is not initialized")
 	}
 	return redisClient
 }
@@ -52,7 +54,7 @@ func GetRedisClient() *redis.ClusterClient {
 // GetRedlock initializes a Redlock distributed lock for concurrency control.
 func GetRedlock() *redsync.Redsync {
 	if redisClient == nil {
-		log.Fatal("[ERROR] Redis Cluster is not initialized, cannot create Redlock")
+		logger.Fatal("Redis Cluster is not initialized, cannot create Redlock")
 	}
 
 	pool := goredis.NewPool(redisClient)
@@ -64,7 +66,7 @@ func ExistsInBloomFilter(client *redis.ClusterClient, redPacketID uint) bool {
 	bloomKey := "bloom_filter:red_packets"
 	exists, err := client.Exists(ctx, bloomKey, strconv.FormatUint(uint64(redPacketID), 10)).Result()
 	if err != nil {
-		log.Printf("[WARN] Error checking Bloom Filter: %v", err)
+		logger.GetLogger().Warn("Failed to check the Bloom Filter", "error", err)
 		return true // Assume it exists to prevent unnecessary DB queries.
 	}
 	return exists > 0
@@ -3,21 +3,65 @@ package routes
 import (
 	"net/http"
 	"red-packet-system/api"
+	"red-packet-system/config"
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/pkg/health"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/redisclient"
+	"red-packet-system/service"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRouter sets up the Gin router
-func SetupRouter() *gin.Engine {
-	router := gin.Default()
+func SetupRouter(redPacketService *service.RedPacketService) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery(), api.Metrics())
 
-	// Health check endpoint
+	// Probes, registered before the tracing and access log middleware so they are neither traced nor logged
+	router.GET("/healthz", gin.WrapH(health.LiveHandler()))
+	router.GET("/readyz", gin.WrapH(health.ReadyHandler(config.LoadConfig().HealthCheckTimeout, readinessChecks()...)))
+	router.Use(api.Tracing(), api.RequestID(), api.AccessLog())
+
+	// Static welcome message, see /healthz and /readyz for probes
 	router.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "Red Packet System is running!"})
 	})
 
-	// Register `/grab` endpoint
-	router.GET("/grab", api.GrabRedPacketHandler)
+	// Register `/grab` endpoint, rate limited per instance by GRAB_RATE_LIMIT
+	router.GET("/grab", api.GrabRateLimit(), api.GrabRedPacketHandler(redPacketService))
+
+	// Register `/red-packets` endpoint, funded from the sender balance
+	router.POST("/red-packets", api.CreateRedPacketHandler)
+
+	// Register `/red-packets/:id` endpoint, also serves archived red packets
+	router.GET("/red-packets/:id", api.GetRedPacketHandler)
+
+	// Register `/users/:id/transactions` endpoint, served from the read replica
+	// unless the user wrote recently (read-your-writes)
+	router.GET("/users/:id/transactions", api.ReadYourWrites(), api.ListTransactionsHandler)
+
+	// Register wallet endpoints, settled asynchronously by payment provider callbacks
+	router.POST("/users/:id/deposits", api.DepositHandler)
+	router.POST("/users/:id/withdrawals", api.WithdrawHandler)
+	router.POST("/payments/:provider/callback", api.PaymentCallbackHandler)
 
 	return router
 }
+
+// SetupAdminRouter serves the Prometheus scrape endpoint and the runtime log level. It is
+// unauthenticated and listens on ADMIN_ADDR, which must stay off the public network.
+func SetupAdminRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/debug/log-level", logger.LevelHandler())
+	return mux
+}
+
+// readinessChecks returns the dependencies the API needs to serve grabs: MySQL, Redis and Kafka
+func readinessChecks() []health.Check {
+	checks := db.HealthChecks()
+	return append(checks, redisclient.HealthCheck(), kafka.ProducerHealthCheck())
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/pkg/metrics"
+)
+
+// redlockProvider is the Redlock LockProvider
+type redlockProvider struct {
+	redlock *redsync.Redsync
+}
+
+// NewRedlockProvider returns a LockProvider backed by Redlock
+func NewRedlockProvider(redlock *redsync.Redsync) LockProvider {
+	return &redlockProvider{redlock: redlock}
+}
+
+func (p *redlockProvider) Lock(ctx context.Context, key string) (func(), error) {
+	mutex := p.redlock.NewMutex(key)
+	start := time.Now()
+	if err := mutex.LockContext(ctx); err != nil {
+		metrics.LockAcquireDuration.WithLabelValues("failed").Observe(metrics.Since(start))
+		return nil, err
+	}
+	metrics.LockAcquireDuration.WithLabelValues("acquired").Observe(metrics.Since(start))
+	return func() { mutex.Unlock() }, nil
+}
+
+// masterSessionPinner is the SessionPinner storing pins in Redis, see db.PinSession
+type masterSessionPinner struct{}
+
+// NewMasterSessionPinner returns a SessionPinner backed by db.PinSession
+func NewMasterSessionPinner() SessionPinner {
+	return masterSessionPinner{}
+}
+
+func (masterSessionPinner) PinSession(ctx context.Context, userID uint) {
+	db.PinSession(ctx, userID)
+}
+
+// kafkaEventPublisher is the Kafka EventPublisher
+type kafkaEventPublisher struct{}
+
+// NewKafkaEventPublisher returns an EventPublisher backed by the Kafka producer
+func NewKafkaEventPublisher() EventPublisher {
+	return kafkaEventPublisher{}
+}
+
+func (kafkaEventPublisher) PublishClaimed(ctx context.Context, userID, redPacketID uint, amount float64) error {
+	return kafka.PublishClaimedEvent(ctx, userID, redPacketID, amount)
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/db"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/redisclient"
+)
+
+const (
+	archiveJob       = "red_packets"
+	archiveBatchSize = 200
+	archiveLockKey   = "lock:archiver"
+)
+
+// ErrRedPacketNotFound means the red packet is neither in the hot nor in the archive tables
+var ErrRedPacketNotFound = errors.New("red packet not found")
+
+// RedPacketDetail is a red packet with its claims, served from the hot or archive tables
+type RedPacketDetail struct {
+	RedPacket  model.RedPacket      `json:"red_packet"`
+	Claims     []model.RedPacketLog `json:"claims"`
+	Archived   bool                 `json:"archived"`
+	ArchivedAt *time.Time           `json:"archived_at,omitempty"`
+}
+
+// GetRedPacket returns a red packet and its claims, falling back to the archive tables
+// for red packets the archive job moved out
+func GetRedPacket(ctx context.Context, redPacketID uint) (*RedPacketDetail, error) {
+	dbInstance := db.GetDB().Clauses(dbresolver.Read).WithContext(ctx)
+
+	var redPacket model.RedPacket
+	err := dbInstance.First(&redPacket, redPacketID).Error
+	if err == nil {
+		detail := &RedPacketDetail{RedPacket: redPacket, Claims: []model.RedPacketLog{}}
+		if err := db.Logs(dbInstance, redPacketID).Where("red_packet_id = ?", redPacketID).
+			Order("id").Find(&detail.Claims).Error; err != nil {
+			return nil, err
+		}
+		return detail, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var archived model.ArchivedRedPacket
+	if err := dbInstance.First(&archived, redPacketID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRedPacketNotFound
+		}
+		return nil, err
+	}
+
+	var archivedLogs []model.ArchivedRedPacketLog
+	if err := dbInstance.Where("red_packet_id = ?", redPacketID).Order("id").Find(&archivedLogs).Error; err != nil {
+		return nil, err
+	}
+
+	detail := &RedPacketDetail{
+		RedPacket:  archived.RedPacket,
+		Claims:     make([]model.RedPacketLog, 0, len(archivedLogs)),
+		Archived:   true,
+		ArchivedAt: &archived.ArchivedAt,
+	}
+	for _, archivedLog := range archivedLogs {
+		detail.Claims = append(detail.Claims, archivedLog.RedPacketLog)
+	}
+	return detail, nil
+}
+
+// ArchiveRedPackets moves finished (expired or exhausted) red packets created more than
+// retention ago, with their claim logs, to the archive tables. Red packets are visited in ID
+// order from the job checkpoint; each one is moved and checkpointed in a single transaction,
+// so an interrupted run resumes where it stopped. Red packets past the retention window that
+// are still active are skipped and left behind the checkpoint; each run first archives those
+// that finished since. It returns the number of archived red packets.
+func ArchiveRedPackets(ctx context.Context, retention time.Duration) (int, error) {
+	return archiveRedPackets(ctx, redisclient.GetRedisClient(), redisclient.GetRedlock(), retention)
+}
+
+// archiveRedPackets is ArchiveRedPackets with its Redis clients
+func archiveRedPackets(ctx context.Context, redisClient *redis.ClusterClient, redlock *redsync.Redsync, retention time.Duration) (int, error) {
+	log := logger.GetLogger()
+	dbInstance := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx)
+
+	var checkpoint model.ArchiveCheckpoint
+	if err := dbInstance.Where("job = ?", archiveJob).Limit(1).Find(&checkpoint).Error; err != nil {
+		return 0, err
+	}
+
+	// Archived rows are deleted, so the red packets behind the checkpoint are the skipped ones
+	archived := 0
+	var lastID uint
+	for {
+		var redPackets []model.RedPacket
+		if err := dbInstance.
+			Where("id > ? AND id <= ? AND (status <> ? OR remaining_count <= 0)", lastID, checkpoint.LastID, model.RedPacketStatusActive).
+			Order("id").
+			Limit(archiveBatchSize).
+			Find(&redPackets).Error; err != nil {
+			return archived, err
+		}
+		if len(redPackets) == 0 {
+			break
+		}
+
+		for i := range redPackets {
+			if err := archiveRedPacket(ctx, redisClient, redlock, &redPackets[i], checkpoint.LastID); err != nil {
+				return archived, fmt.Errorf("red packet %d: %v", redPackets[i].ID, err)
+			}
+			archived++
+		}
+		lastID = redPackets[len(redPackets)-1].ID
+	}
+
+	skipped := 0
+	lastID = checkpoint.LastID
+	cutoff := time.Now().Add(-retention)
+	for {
+		var redPackets []model.RedPacket
+		if err := dbInstance.
+			Where("id > ? AND created_at < ?", lastID, cutoff).
+			Order("id").
+			Limit(archiveBatchSize).
+			Find(&redPackets).Error; err != nil {
+			return archived, err
+		}
+		if len(redPackets) == 0 {
+			if skipped > 0 {
+				log.Info("Skipped red packets still active past the retention window", "skipped", skipped)
+			}
+			return archived, nil
+		}
+
+		for i := range redPackets {
+			redPacket := &redPackets[i]
+			if redPacket.Status == model.RedPacketStatusActive && redPacket.RemainingCount > 0 {
+				skipped++
+				continue
+			}
+
+			if err := archiveRedPacket(ctx, redisClient, redlock, redPacket, redPacket.ID); err != nil {
+				return archived, fmt.Errorf("red packet %d: %v", redPacket.ID, err)
+			}
+			archived++
+		}
+		lastID = redPackets[len(redPackets)-1].ID
+	}
+}
+
+// archiveRedPacket moves one red packet and its claim logs, setting the job checkpoint to
+// checkpointID in the same transaction, then drops its Redis keys
+func archiveRedPacket(ctx context.Context, redisClient *redis.ClusterClient, redlock *redsync.Redsync, redPacket *model.RedPacket, checkpointID uint) error {
+	// Same lock as grabs and refunds, nothing may touch the red packet while it moves
+	mutex := redlock.NewMutex(fmt.Sprintf("lock:red_packet_%d", redPacket.ID))
+	if err := mutex.LockContext(ctx); err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var logs []model.RedPacketLog
+		if err := db.Logs(tx, redPacket.ID).Where("red_packet_id = ?", redPacket.ID).Find(&logs).Error; err != nil {
+			return err
+		}
+
+		archivedLogs := make([]model.ArchivedRedPacketLog, 0, len(logs))
+		for _, logEntry := range logs {
+			archivedLogs = append(archivedLogs, model.ArchivedRedPacketLog{RedPacketLog: logEntry})
+		}
+		if len(archivedLogs) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&archivedLogs, archiveBatchSize).Error; err != nil {
+				return err
+			}
+		}
+
+		archived := model.ArchivedRedPacket{RedPacket: *redPacket, ArchivedAt: time.Now()}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&archived).Error; err != nil {
+			return err
+		}
+
+		if err := db.Logs(tx, redPacket.ID).Where("red_packet_id = ?", redPacket.ID).Delete(&model.RedPacketLog{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&model.RedPacket{}, redPacket.ID).Error; err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).
+			Create(&model.ArchiveCheckpoint{Job: archiveJob, LastID: checkpointID}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// Leftover cache entries would let grabs reach a red packet that is gone.
+	// Keys are deleted one by one, they live in different cluster slots.
+	for _, key := range []string{
+		fmt.Sprintf("red_packet_%d", redPacket.ID),
+		grabModeKey(redPacket.ID),
+		FastStateKey(redPacket.ID),
+		FastGrabbersKey(redPacket.ID),
+		FastPendingKey(redPacket.ID),
+	} {
+		redisClient.Del(ctx, key)
+	}
+	return nil
+}
+
+// StartArchiver archives finished red packets every interval until ctx is cancelled
+func StartArchiver(ctx context.Context, interval, retention time.Duration) {
+	log := logger.GetLogger()
+	log.Info("Red packet archiver started", "interval", interval, "retention", retention)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Red packet archiver stopped")
+			return
+		case <-ticker.C:
+			mutex := redisclient.GetRedlock().NewMutex(archiveLockKey, redsync.WithExpiry(interval), redsync.WithTries(1))
+			if err := mutex.LockContext(ctx); err != nil {
+				continue // Another instance is archiving
+			}
+
+			archived, err := ArchiveRedPackets(ctx, retention)
+			if err != nil {
+				log.Error("Red packet archiving failed", "archived", archived, "error", err)
+			} else if archived > 0 {
+				log.Info("Archived red packets", "archived", archived)
+			}
+			mutex.Unlock()
+		}
+	}
+}
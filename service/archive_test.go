@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
+	"gorm.io/gorm"
+	"red-packet-system/db"
+	"red-packet-system/model"
+)
+
+// createOldRedPacket creates a red packet created two days ago, finished unless active
+func createOldRedPacket(t *testing.T, tx *gorm.DB, active bool) model.RedPacket {
+	t.Helper()
+	redPacket := createRedPacket(t, tx, 4, 2, model.GrabModeStrict)
+	updates := map[string]interface{}{"CreatedAt": time.Now().Add(-48 * time.Hour)}
+	if !active {
+		updates["Status"] = model.RedPacketStatusExpired
+	}
+	if err := tx.Model(&redPacket).Updates(updates).Error; err != nil {
+		t.Fatal(err)
+	}
+	return redPacket
+}
+
+// archiveCheckpoint returns the last red packet ID checkpointed by the archive job
+func archiveCheckpoint(t *testing.T, tx *gorm.DB) uint {
+	t.Helper()
+	var checkpoint model.ArchiveCheckpoint
+	if err := tx.Where("job = ?", archiveJob).Limit(1).Find(&checkpoint).Error; err != nil {
+		t.Fatal(err)
+	}
+	return checkpoint.LastID
+}
+
+func TestArchiveSkipsActiveRedPackets(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	client := newTestRedis(t)
+	redlock := redsync.New(goredis.NewPool(client))
+
+	first := createOldRedPacket(t, sqliteDB, false)
+	active := createOldRedPacket(t, sqliteDB, true)
+	third := createOldRedPacket(t, sqliteDB, false)
+	recent := createRedPacket(t, sqliteDB, 4, 2, model.GrabModeStrict)
+	sqliteDB.Model(&recent).Update("Status", model.RedPacketStatusExpired)
+
+	// The active red packet does not hold back the one after it
+	archived, err := archiveRedPackets(ctx, client, redlock, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 2 || archiveCheckpoint(t, sqliteDB) != third.ID {
+		t.Fatalf("archived %d up to %d, want 2 up to %d", archived, archiveCheckpoint(t, sqliteDB), third.ID)
+	}
+	for _, id := range []uint{active.ID, recent.ID} {
+		if err := sqliteDB.First(&model.RedPacket{}, id).Error; err != nil {
+			t.Fatalf("red packet %d left the hot table: %v", id, err)
+		}
+	}
+	if err := sqliteDB.First(&model.RedPacket{}, first.ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("red packet %d still in the hot table: %v", first.ID, err)
+	}
+
+	// Once finished, the skipped red packet is archived behind the checkpoint, which stays put
+	sqliteDB.Model(&active).Update("Status", model.RedPacketStatusExpired)
+	archived, err = archiveRedPackets(ctx, client, redlock, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 1 || archiveCheckpoint(t, sqliteDB) != third.ID {
+		t.Fatalf("archived %d up to %d, want 1 up to %d", archived, archiveCheckpoint(t, sqliteDB), third.ID)
+	}
+
+	// Nothing is left to resume
+	if archived, err := archiveRedPackets(ctx, client, redlock, 24*time.Hour); err != nil || archived != 0 {
+		t.Fatalf("rerun archived %d, %v, want 0", archived, err)
+	}
+}
+
+func TestGetArchivedRedPacket(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	client := newTestRedis(t)
+	redlock := redsync.New(goredis.NewPool(client))
+
+	redPacket := createOldRedPacket(t, sqliteDB, false)
+	for userID := uint(1); userID <= 2; userID++ {
+		claim := model.RedPacketLog{UserID: userID, RedPacketID: redPacket.ID, Amount: 2}
+		if err := db.Logs(sqliteDB, redPacket.ID).Create(&claim).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.Set(ctx, grabModeKey(redPacket.ID), model.GrabModeStrict, 0)
+
+	hot, err := GetRedPacket(ctx, redPacket.ID)
+	if err != nil || hot.Archived || len(hot.Claims) != 2 {
+		t.Fatalf("hot lookup returned %+v, %v", hot, err)
+	}
+
+	if _, err := archiveRedPackets(ctx, client, redlock, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var logs int64
+	db.Logs(sqliteDB, redPacket.ID).Where("red_packet_id = ?", redPacket.ID).Count(&logs)
+	if logs != 0 {
+		t.Fatalf("%d claim logs left in the shard", logs)
+	}
+	if cached, _ := client.Exists(ctx, grabModeKey(redPacket.ID)).Result(); cached != 0 {
+		t.Fatal("grab mode of the archived red packet still cached")
+	}
+
+	detail, err := GetRedPacket(ctx, redPacket.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !detail.Archived || detail.ArchivedAt == nil || detail.RedPacket.ID != redPacket.ID || len(detail.Claims) != 2 {
+		t.Fatalf("archived lookup returned %+v", detail)
+	}
+	if detail.Claims[0].UserID != 1 || detail.Claims[1].UserID != 2 {
+		t.Fatalf("archived claims out of order: %+v", detail.Claims)
+	}
+
+	if _, err := GetRedPacket(ctx, redPacket.ID+100); !errors.Is(err, ErrRedPacketNotFound) {
+		t.Fatalf("expected ErrRedPacketNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+)
+
+// ErrClaimRejected is returned for a fast mode claim of a red packet that is no longer active
+// or has no share left in MySQL; the event goes to the dead letter topic for inspection
+var ErrClaimRejected = errors.New("red packet no longer accepts claims")
+
+// RegisterEventHandlers wires the Kafka worker to the balance handlers below
+func RegisterEventHandlers() {
+	kafka.RegisterHandler(kafka.EventTypeRedPacketGrabbed, HandleGrabbedEvent)
+	kafka.RegisterHandler(kafka.EventTypeRedPacketClaimed, HandleClaimedEvent)
+}
+
+// grabReference is the journal entry reference of a fast mode claim credit, unique per user
+// and red packet
+func grabReference(redPacketID, userID uint) string {
+	return fmt.Sprintf("grab:%d:%d", redPacketID, userID)
+}
+
+// grabEventReference is the journal entry reference of a strict mode grab credit. A user may
+// grab a strict mode red packet repeatedly, so the credit is unique per event instead; legacy
+// events carry no ID and keep the reference of their user and red packet.
+func grabEventReference(event *kafka.Event, payload *kafka.GrabbedPayload) string {
+	if event.ID == "" {
+		return grabReference(payload.RedPacketID, payload.UserID)
+	}
+	return "grab:event:" + event.ID
+}
+
+// HandleGrabbedEvent credits a strict mode grab from the red packet escrow to the user wallet.
+// The grab itself is already in MySQL; redelivered events are skipped by the journal reference.
+func HandleGrabbedEvent(ctx context.Context, event *kafka.Event) error {
+	log := logger.GetLogger()
+	payload, ok := event.Payload.(*kafka.GrabbedPayload)
+	if !ok {
+		return fmt.Errorf("unexpected payload %T for %s", event.Payload, event.Type)
+	}
+
+	cents := ToCents(payload.Amount)
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return PostEntry(tx, model.EntryTypeGrabCredit, grabEventReference(event, payload),
+			RedPacketLine(payload.RedPacketID, -cents),
+			UserLine(payload.UserID, cents),
+		)
+	})
+
+	if errors.Is(err, ErrDuplicateEntry) {
+		log.InfoContext(ctx, "Grab already credited, skipping", "user_id", payload.UserID, "red_packet_id", payload.RedPacketID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.DebugContext(ctx, "Grab credited", "user_id", payload.UserID, "red_packet_id", payload.RedPacketID, "amount", payload.Amount)
+	return nil
+}
+
+// HandleClaimedEvent persists a fast mode claim made in Redis: the claim log, the red packet
+// counters and the grab credit are written in one transaction. Redelivered claims hit the
+// unique (red_packet_id, claim_user_id) index and are skipped; a claim of an expired or
+// exhausted red packet is rejected with ErrClaimRejected.
+func HandleClaimedEvent(ctx context.Context, event *kafka.Event) error {
+	log := logger.GetLogger()
+	payload, ok := event.Payload.(*kafka.ClaimedPayload)
+	if !ok {
+		return fmt.Errorf("unexpected payload %T for %s", event.Payload, event.Type)
+	}
+
+	cents := ToCents(payload.Amount)
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		logEntry := model.RedPacketLog{
+			UserID:      payload.UserID,
+			RedPacketID: payload.RedPacketID,
+			ClaimUserID: &payload.UserID,
+			Amount:      payload.Amount,
+		}
+		if err := db.Logs(tx, payload.RedPacketID).Create(&logEntry).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&model.RedPacket{ID: payload.RedPacketID}).
+			Where("status = ? AND remaining_count > 0", model.RedPacketStatusActive).
+			Updates(map[string]interface{}{
+				"RemainingAmount": gorm.Expr("remaining_amount - ?", payload.Amount),
+				"RemainingCount":  gorm.Expr("remaining_count - 1"),
+				"Version":         gorm.Expr("version + 1"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrClaimRejected
+		}
+
+		return PostEntry(tx, model.EntryTypeGrabCredit, grabReference(payload.RedPacketID, payload.UserID),
+			RedPacketLine(payload.RedPacketID, -cents),
+			UserLine(payload.UserID, cents),
+		)
+	})
+
+	if errors.Is(err, gorm.ErrDuplicatedKey) || errors.Is(err, ErrDuplicateEntry) {
+		log.InfoContext(ctx, "Claim already persisted, skipping", "user_id", payload.UserID, "red_packet_id", payload.RedPacketID)
+		return nil
+	}
+	if errors.Is(err, ErrClaimRejected) {
+		log.ErrorContext(ctx, "Claim of an expired or exhausted red packet rejected", "user_id", payload.UserID, "red_packet_id", payload.RedPacketID, "amount", payload.Amount)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.DebugContext(ctx, "Claim persisted to MySQL", "user_id", payload.UserID, "red_packet_id", payload.RedPacketID, "amount", payload.Amount)
+	return nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+)
+
+// claimedEvent returns the fast mode claim event of a user
+func claimedEvent(userID, redPacketID uint, amount float64) *kafka.Event {
+	return kafka.NewEvent(kafka.EventTypeRedPacketClaimed, &kafka.ClaimedPayload{GrabbedPayload: kafka.GrabbedPayload{
+		UserID:      userID,
+		RedPacketID: redPacketID,
+		Amount:      amount,
+	}})
+}
+
+func TestHandleClaimedEvent(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	redPacket := createRedPacket(t, sqliteDB, 4, 2, model.GrabModeFast)
+	if err := PostEntry(sqliteDB, model.EntryTypePacketFunding, "fund:1", ExternalLine(-400), RedPacketLine(redPacket.ID, 400)); err != nil {
+		t.Fatal(err)
+	}
+	for userID := uint(1); userID <= 3; userID++ {
+		createUser(t, sqliteDB, userID, 0)
+	}
+
+	// A redelivered claim is skipped
+	for i := 0; i < 2; i++ {
+		if err := HandleClaimedEvent(ctx, claimedEvent(1, redPacket.ID, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := HandleClaimedEvent(ctx, claimedEvent(2, redPacket.ID, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The red packet is exhausted, a third claim cannot take a share MySQL does not have
+	if err := HandleClaimedEvent(ctx, claimedEvent(3, redPacket.ID, 1)); !errors.Is(err, ErrClaimRejected) {
+		t.Fatalf("expected ErrClaimRejected, got %v", err)
+	}
+	var stored model.RedPacket
+	sqliteDB.First(&stored, redPacket.ID)
+	if stored.RemainingCount != 0 || ToCents(stored.RemainingAmount) != 0 {
+		t.Fatalf("red packet left at count=%d amount=%.2f, want 0", stored.RemainingCount, stored.RemainingAmount)
+	}
+	var logs int64
+	db.Logs(sqliteDB, redPacket.ID).Where("red_packet_id = ?", redPacket.ID).Count(&logs)
+	if logs != 2 {
+		t.Fatalf("%d claims logged, want 2", logs)
+	}
+	if balance := userBalance(t, sqliteDB, 3); balance != 0 {
+		t.Fatalf("rejected claim credited %d cents", balance)
+	}
+	if escrow := ledgerBalance(t, sqliteDB, model.AccountTypeRedPacket, redPacket.ID); escrow != 0 {
+		t.Fatalf("expected an empty escrow, got %d", escrow)
+	}
+}
+
+func TestHandleClaimedEventRejectsExpiredRedPacket(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	createUser(t, sqliteDB, 1, 0)
+	redPacket := createRedPacket(t, sqliteDB, 4, 2, model.GrabModeFast)
+	sqliteDB.Model(&redPacket).Update("Status", model.RedPacketStatusExpired)
+
+	if err := HandleClaimedEvent(ctx, claimedEvent(1, redPacket.ID, 2)); !errors.Is(err, ErrClaimRejected) {
+		t.Fatalf("expected ErrClaimRejected, got %v", err)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 0 {
+		t.Fatalf("claim of an expired red packet credited %d cents", balance)
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/pkg/tracing"
+)
+
+// FastStateKey returns the Redis hash holding fast mode stock and remaining amount (cents)
+func FastStateKey(redPacketID uint) string {
+	return fmt.Sprintf("red_packet:{%d}:state", redPacketID)
+}
+
+// FastGrabbersKey returns the Redis set of users who claimed a fast mode red packet
+func FastGrabbersKey(redPacketID uint) string {
+	return fmt.Sprintf("red_packet:{%d}:grabbers", redPacketID)
+}
+
+// FastPendingKey returns the Redis hash of fast mode claims whose Kafka delivery is unknown,
+// user ID to cents, republished by the reconciler
+func FastPendingKey(redPacketID uint) string {
+	return fmt.Sprintf("red_packet:{%d}:pending", redPacketID)
+}
+
+// grabModeKey caches the grab mode of a red packet
+func grabModeKey(redPacketID uint) string {
+	return fmt.Sprintf("red_packet_mode_%d", redPacketID)
+}
+
+// grabMode returns the grab mode of a red packet, cached in the stock cache
+func (s *RedPacketService) grabMode(ctx context.Context, redPacketID uint) (string, error) {
+	if mode, ok := s.stock.GrabMode(ctx, redPacketID); ok {
+		return mode, nil
+	}
+
+	redPacket, err := s.packets.GetRedPacket(ctx, redPacketID)
+	if err != nil {
+		return "", ErrRedPacketNotExist
+	}
+
+	mode := redPacket.GrabMode
+	if mode == "" {
+		mode = model.GrabModeStrict
+	}
+
+	// Randomized TTL to prevent cache avalanche
+	ttl := time.Duration(600+rand.Intn(60)) * time.Second
+	s.stock.SetGrabMode(ctx, redPacketID, mode, ttl)
+	return mode, nil
+}
+
+// grabRedPacketFast claims a share with a single Lua script and leaves MySQL to the Kafka worker.
+// No Redlock and no MySQL access happen on this path once the state is loaded.
+func (s *RedPacketService) grabRedPacketFast(ctx context.Context, userID uint, redPacketID uint) (float64, error) {
+	log := logger.Sampled()
+
+	stepCtx, span := tracing.Start(ctx, "grab.claim_fast")
+	share, err := s.stock.ClaimFast(stepCtx, redPacketID, userID)
+	tracing.End(span, err)
+	if err != nil {
+		log.ErrorContext(ctx, "Redis operation failed", "red_packet_id", redPacketID, "error", err)
+		return 0, errSystem
+	}
+
+	// State not in Redis yet, load it from MySQL and retry once
+	if share == StockNotCached {
+		stepCtx, span = tracing.Start(ctx, "grab.load_fast_state")
+		err = loadFastState(stepCtx, s.packets, s.stock, redPacketID)
+		tracing.End(span, err)
+		if err != nil {
+			return 0, err
+		}
+		stepCtx, span = tracing.Start(ctx, "grab.claim_fast")
+		share, err = s.stock.ClaimFast(stepCtx, redPacketID, userID)
+		tracing.End(span, err)
+		if err != nil {
+			log.ErrorContext(ctx, "Redis operation failed", "red_packet_id", redPacketID, "error", err)
+			return 0, errSystem
+		}
+	}
+
+	switch share {
+	case StockEmpty:
+		log.DebugContext(ctx, "Red packet is already empty", "red_packet_id", redPacketID)
+		return 0, ErrRedPacketEmpty
+	case StockNotCached:
+		return 0, ErrRedPacketNotExist
+	case StockClaimed:
+		log.DebugContext(ctx, "User already grabbed the red packet", "user_id", userID, "red_packet_id", redPacketID)
+		return 0, ErrAlreadyGrabbed
+	}
+
+	amount := float64(share) / 100
+	stepCtx, span = tracing.Start(ctx, "grab.publish_claimed")
+	span.SetAttributes(attribute.Float64("grab.amount", amount))
+	err = s.events.PublishClaimed(stepCtx, userID, redPacketID, amount)
+	tracing.End(span, err)
+	if errors.Is(err, kafka.ErrDeliveryUnknown) {
+		// The event may still arrive: keep the claim, so the user cannot claim twice, and tell
+		// the user the grab is pending rather than failed. The reconciler publishes pending
+		// claims again, so a lost event is still persisted.
+		log.WarnContext(ctx, "Claim event delivery unknown", "user_id", userID, "red_packet_id", redPacketID)
+		if err := s.stock.MarkPendingFast(context.WithoutCancel(ctx), redPacketID, userID, share); err != nil {
+			log.ErrorContext(ctx, "Failed to mark claim pending", "user_id", userID, "red_packet_id", redPacketID, "error", err)
+		}
+		return amount, ErrGrabPending
+	}
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to publish claim event, rolling back Redis", "user_id", userID, "red_packet_id", redPacketID, "error", err)
+		s.stock.RollbackFast(context.WithoutCancel(ctx), redPacketID, userID, share)
+		return 0, errSystem
+	}
+
+	log.InfoContext(ctx, "Red packet claimed", "user_id", userID, "red_packet_id", redPacketID, "amount", amount, "mode", model.GrabModeFast)
+	return amount, nil
+}
+
+// loadFastState copies the stored state of a red packet into the stock cache
+func loadFastState(ctx context.Context, packets PacketRepository, stock StockCache, redPacketID uint) error {
+	redPacket, err := packets.GetRedPacket(ctx, redPacketID)
+	if err != nil {
+		return ErrRedPacketNotExist
+	}
+
+	grabberIDs, err := packets.ListGrabbers(ctx, redPacketID)
+	if err != nil {
+		return errSystem
+	}
+
+	if err := stock.LoadFast(ctx, redPacketID, redPacket.RemainingCount, int64(math.Round(redPacket.RemainingAmount*100)), grabberIDs); err != nil {
+		return errSystem
+	}
+	return nil
+}
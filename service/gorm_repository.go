@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+)
+
+// gormPacketRepository is the MySQL PacketRepository
+type gormPacketRepository struct {
+	db       *gorm.DB
+	sessions SessionPinner
+}
+
+// NewGormPacketRepository returns a PacketRepository backed by MySQL, pinning the sessions
+// of grabbing users with sessions
+func NewGormPacketRepository(dbInstance *gorm.DB, sessions SessionPinner) PacketRepository {
+	return &gormPacketRepository{db: dbInstance, sessions: sessions}
+}
+
+// GetRedPacket reads from the master, the row feeds the stock cache
+func (r *gormPacketRepository) GetRedPacket(ctx context.Context, redPacketID uint) (*model.RedPacket, error) {
+	var redPacket model.RedPacket
+	err := r.db.Clauses(dbresolver.Write).WithContext(ctx).First(&redPacket, redPacketID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRedPacketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &redPacket, nil
+}
+
+// ListGrabbers reads from the master because the worker may have just persisted claims
+func (r *gormPacketRepository) ListGrabbers(ctx context.Context, redPacketID uint) ([]uint, error) {
+	var grabberIDs []uint
+	err := db.Logs(r.db.Clauses(dbresolver.Write).WithContext(ctx), redPacketID).
+		Where("red_packet_id = ?", redPacketID).
+		Pluck("user_id", &grabberIDs).Error
+	return grabberIDs, err
+}
+
+// PersistGrab writes one strict mode grab. The counters are updated only if the row still has
+// the version it was read with, so a grab whose Redlock expired cannot overwrite another one.
+func (r *gormPacketRepository) PersistGrab(ctx context.Context, userID, redPacketID uint, share func(*model.RedPacket) float64) (float64, error) {
+	log := logger.GetLogger()
+	var amount float64
+
+	err := r.db.Clauses(dbresolver.Write).WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Retrieve red packet data from MySQL
+		var redPacket model.RedPacket
+		if err := tx.First(&redPacket, redPacketID).Error; err != nil {
+			log.WarnContext(ctx, "Red packet does not exist, rolling back Redis", "red_packet_id", redPacketID, "error", err)
+			return ErrRedPacketNotExist
+		}
+		if redPacket.RemainingCount <= 0 {
+			return ErrRedPacketEmpty
+		}
+
+		// **Calculate amount to grab**
+		amount = share(&redPacket)
+
+		result := tx.Model(&model.RedPacket{}).
+			Where("id = ? AND version = ? AND remaining_count > 0", redPacketID, redPacket.Version).
+			Updates(map[string]interface{}{
+				"RemainingAmount": FromCents(ToCents(redPacket.RemainingAmount) - ToCents(amount)),
+				"RemainingCount":  redPacket.RemainingCount - 1,
+				"Version":         redPacket.Version + 1,
+			})
+		if result.Error != nil {
+			log.ErrorContext(ctx, "Red packet update failed", "red_packet_id", redPacketID, "error", result.Error)
+			return errors.New("red packet update failed")
+		}
+		if result.RowsAffected == 0 {
+			return errVersionConflict
+		}
+
+		// **Log red packet transaction**
+		logEntry := model.RedPacketLog{
+			UserID:      userID,
+			RedPacketID: redPacketID,
+			Amount:      amount,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		if err := db.Logs(tx, redPacketID).Create(&logEntry).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrAlreadyGrabbed
+			}
+			log.ErrorContext(ctx, "Failed to log red packet grab", "red_packet_id", redPacketID, "user_id", userID, "error", err)
+			return errors.New("failed to log red packet grab")
+		}
+
+		// **Record Kafka event in the outbox, published by the relay after commit**
+		outboxEvent, err := kafka.NewGrabOutboxEvent(ctx, userID, redPacketID, amount)
+		if err != nil {
+			log.ErrorContext(ctx, "Failed to encode grab event", "red_packet_id", redPacketID, "error", err)
+			return errors.New("failed to record grab event")
+		}
+		if err := tx.Create(&outboxEvent).Error; err != nil {
+			log.ErrorContext(ctx, "Failed to write outbox event", "red_packet_id", redPacketID, "error", err)
+			return errors.New("failed to record grab event")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the user's reads on the master until the replica has the grab
+	r.sessions.PinSession(ctx, userID)
+	return amount, nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"red-packet-system/db"
+	"red-packet-system/model"
+	"red-packet-system/pkg/testenv"
+	"red-packet-system/pkg/tracing"
+)
+
+// newTestDB returns an in-memory SQLite database with the production schema
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	sqliteDB, err := testenv.OpenSQLite()
+	if err != nil {
+		t.Fatalf("failed to open SQLite: %v", err)
+	}
+	t.Cleanup(func() {
+		if pool, err := sqliteDB.DB(); err == nil {
+			pool.Close()
+		}
+	})
+	return sqliteDB
+}
+
+// createRedPacket inserts an active red packet
+func createRedPacket(t *testing.T, tx *gorm.DB, amount float64, count int, mode string) model.RedPacket {
+	t.Helper()
+	redPacket := model.RedPacket{
+		TotalAmount:     amount,
+		RemainingAmount: amount,
+		TotalCount:      count,
+		RemainingCount:  count,
+		Status:          model.RedPacketStatusActive,
+		GrabMode:        mode,
+	}
+	if err := tx.Create(&redPacket).Error; err != nil {
+		t.Fatalf("failed to create red packet: %v", err)
+	}
+	return redPacket
+}
+
+// newStoreBackends wires RedPacketService to SQLite and an in-process Redis server
+func newStoreBackends(t *testing.T, sqliteDB *gorm.DB) *RedPacketService {
+	t.Helper()
+	client := newTestRedis(t)
+	// The existence check only looks for the filter key, see redisclient.ExistsInBloomFilter
+	if err := client.Set(context.Background(), "bloom_filter:red_packets", 1, 0).Err(); err != nil {
+		t.Fatalf("failed to seed the existence filter: %v", err)
+	}
+	return NewRedPacketService(NewGormPacketRepository(sqliteDB, &MemorySessionPinner{}), NewRedisStockCache(client), NewMemoryLockProvider(), &MemoryEventPublisher{})
+}
+
+func TestGormPacketRepositoryPersistGrab(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	sessions := &MemorySessionPinner{}
+	repo := NewGormPacketRepository(sqliteDB, sessions)
+	redPacket := createRedPacket(t, sqliteDB, 10, 2, model.GrabModeStrict)
+
+	amount, err := repo.PersistGrab(ctx, 7, redPacket.ID, evenShare)
+	if err != nil || amount != 5 {
+		t.Fatalf("PersistGrab = %.2f, %v, want 5.00", amount, err)
+	}
+
+	stored, err := repo.GetRedPacket(ctx, redPacket.ID)
+	if err != nil {
+		t.Fatalf("GetRedPacket failed: %v", err)
+	}
+	if stored.RemainingCount != 1 || stored.RemainingAmount != 5 || stored.Version != 1 {
+		t.Fatalf("red packet is count=%d amount=%.2f version=%d, want 1/5.00/1",
+			stored.RemainingCount, stored.RemainingAmount, stored.Version)
+	}
+
+	grabberIDs, err := repo.ListGrabbers(ctx, redPacket.ID)
+	if err != nil || len(grabberIDs) != 1 || grabberIDs[0] != 7 {
+		t.Fatalf("ListGrabbers = %v, %v, want [7]", grabberIDs, err)
+	}
+
+	if pinned := sessions.Pinned(); len(pinned) != 1 || pinned[0] != 7 {
+		t.Fatalf("pinned sessions %v, want [7]", pinned)
+	}
+
+	var outboxEvents int64
+	sqliteDB.Model(&model.OutboxEvent{}).Where("status = ?", model.OutboxStatusPending).Count(&outboxEvents)
+	if outboxEvents != 1 {
+		t.Fatalf("got %d pending outbox events, want 1", outboxEvents)
+	}
+
+	// Only fast mode claims are unique per user, a strict mode packet can be grabbed again
+	if amount, err := repo.PersistGrab(ctx, 7, redPacket.ID, evenShare); err != nil || amount != 5 {
+		t.Fatalf("second PersistGrab by the same user = %.2f, %v, want 5.00", amount, err)
+	}
+	if stored, _ := repo.GetRedPacket(ctx, redPacket.ID); stored.RemainingCount != 0 || stored.Version != 2 {
+		t.Fatalf("red packet is count=%d version=%d after a second grab, want 0/2", stored.RemainingCount, stored.Version)
+	}
+}
+
+func TestGormPacketRepositoryGetRedPacketNotFound(t *testing.T) {
+	repo := NewGormPacketRepository(newTestDB(t), &MemorySessionPinner{})
+
+	if _, err := repo.GetRedPacket(context.Background(), 42); !errors.Is(err, ErrRedPacketNotFound) {
+		t.Fatalf("GetRedPacket returned %v, want ErrRedPacketNotFound", err)
+	}
+}
+
+func TestGrabRedPacketStoreConcurrentNoOversell(t *testing.T) {
+	sqliteDB := newTestDB(t)
+	svc := newStoreBackends(t, sqliteDB)
+	redPacket := createRedPacket(t, sqliteDB, 50, 20, model.GrabModeStrict)
+
+	results := grabConcurrently(svc, redPacket.ID, 100)
+
+	if _, count := successes(results); count != 20 {
+		t.Fatalf("got %d successful grabs, want 20", count)
+	}
+
+	var logs []model.RedPacketLog
+	if err := db.Logs(sqliteDB, redPacket.ID).Find(&logs).Error; err != nil {
+		t.Fatalf("failed to read claim logs: %v", err)
+	}
+	var paid float64
+	for _, logEntry := range logs {
+		paid += logEntry.Amount
+	}
+	if len(logs) != 20 || math.Abs(paid-50) > 0.005 {
+		t.Fatalf("got %d claim logs paying %.2f, want 20 paying 50.00", len(logs), paid)
+	}
+
+	var stored model.RedPacket
+	sqliteDB.First(&stored, redPacket.ID)
+	if stored.RemainingCount != 0 || stored.Version != 20 {
+		t.Fatalf("red packet is count=%d version=%d, want 0/20", stored.RemainingCount, stored.Version)
+	}
+}
+
+func TestGrabRedPacketStoreRollbackOnDBFailure(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	svc := newStoreBackends(t, sqliteDB)
+	redPacket := createRedPacket(t, sqliteDB, 10, 2, model.GrabModeStrict)
+
+	// Losing the claim log shard fails the transaction after the counters were updated
+	if err := sqliteDB.Migrator().RenameTable(db.LogTable(redPacket.ID), "red_packet_logs_broken"); err != nil {
+		t.Fatalf("failed to break the claim log shard: %v", err)
+	}
+	if _, err := svc.GrabRedPacket(ctx, 7, redPacket.ID); err == nil {
+		t.Fatal("grab succeeded although the transaction failed")
+	}
+
+	var stored model.RedPacket
+	sqliteDB.First(&stored, redPacket.ID)
+	if stored.RemainingCount != 2 || stored.Version != 0 {
+		t.Fatalf("red packet is count=%d version=%d after rollback, want 2/0", stored.RemainingCount, stored.Version)
+	}
+
+	// Redis got the share back, so both shares can still be claimed
+	if err := sqliteDB.Migrator().RenameTable("red_packet_logs_broken", db.LogTable(redPacket.ID)); err != nil {
+		t.Fatalf("failed to restore the claim log shard: %v", err)
+	}
+	results := grabConcurrently(svc, redPacket.ID, 5)
+	if _, count := successes(results); count != 2 {
+		t.Fatalf("got %d successful grabs after recovery, want 2", count)
+	}
+}
+
+func TestGrabRedPacketStoreTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	sqliteDB := newTestDB(t)
+	svc := newStoreBackends(t, sqliteDB)
+	redPacket := createRedPacket(t, sqliteDB, 10, 2, model.GrabModeStrict)
+
+	ctx, span := tracing.Start(context.Background(), "request")
+	if _, err := svc.GrabRedPacket(ctx, 7, redPacket.ID); err != nil {
+		t.Fatalf("grab failed: %v", err)
+	}
+	span.End()
+
+	names := map[string]bool{}
+	for _, recorded := range recorder.Ended() {
+		if recorded.SpanContext().TraceID() != span.SpanContext().TraceID() {
+			t.Fatalf("span %q is outside the request trace", recorded.Name())
+		}
+		names[recorded.Name()] = true
+	}
+	for _, name := range []string{"service.GrabRedPacket", "grab.lock", "grab.decr_stock", "grab.persist"} {
+		if !names[name] {
+			t.Errorf("missing span %q, got %v", name, names)
+		}
+	}
+
+	// The relay publishes the event in the trace of the request that wrote it
+	var outboxEvent model.OutboxEvent
+	if err := sqliteDB.First(&outboxEvent).Error; err != nil {
+		t.Fatalf("failed to read the outbox event: %v", err)
+	}
+	relayCtx := tracing.Extract(context.Background(), outboxEvent.TraceContext)
+	if got := trace.SpanContextFromContext(relayCtx).TraceID(); got != span.SpanContext().TraceID() {
+		t.Fatalf("outbox event carries trace %s, want %s", got, span.SpanContext().TraceID())
+	}
+}
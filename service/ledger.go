@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/db"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+)
+
+var (
+	ErrUnbalancedEntry     = errors.New("journal entry postings must sum to zero")
+	ErrDuplicateEntry      = errors.New("journal entry already posted")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// LedgerLine is one posting of a journal entry; Amount is in cents and positive credits the account
+type LedgerLine struct {
+	AccountType string
+	OwnerID     uint
+	Amount      int64
+}
+
+// UserLine moves cents into (positive) or out of (negative) a user wallet
+func UserLine(userID uint, cents int64) LedgerLine {
+	return LedgerLine{AccountType: model.AccountTypeUser, OwnerID: userID, Amount: cents}
+}
+
+// RedPacketLine moves cents into (positive) or out of (negative) a red packet escrow
+func RedPacketLine(redPacketID uint, cents int64) LedgerLine {
+	return LedgerLine{AccountType: model.AccountTypeRedPacket, OwnerID: redPacketID, Amount: cents}
+}
+
+// ExternalLine moves cents into (positive) or out of (negative) the system
+func ExternalLine(cents int64) LedgerLine {
+	return LedgerLine{AccountType: model.AccountTypeExternal, Amount: cents}
+}
+
+// senderLine is the funding side of a red packet: the sender wallet, or external for system packets
+func senderLine(senderID uint, cents int64) LedgerLine {
+	if senderID == 0 {
+		return ExternalLine(cents)
+	}
+	return UserLine(senderID, cents)
+}
+
+// ToCents converts a Yuan amount to integer cents
+func ToCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// FromCents converts integer cents to a Yuan amount
+func FromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// PostEntry records a balanced journal entry inside tx. User wallet postings also update
+// the cached User.Balance, refusing to take it below zero (ErrInsufficientBalance).
+// A reference that was already posted returns ErrDuplicateEntry, so callers are idempotent.
+func PostEntry(tx *gorm.DB, entryType, reference string, lines ...LedgerLine) error {
+	var sum int64
+	for _, line := range lines {
+		sum += line.Amount
+	}
+	if sum != 0 || len(lines) < 2 {
+		return ErrUnbalancedEntry
+	}
+
+	entry := model.JournalEntry{Type: entryType, Reference: reference}
+	if err := tx.Create(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrDuplicateEntry
+		}
+		return err
+	}
+
+	for _, line := range lines {
+		accountID, err := ledgerAccountID(tx, line.AccountType, line.OwnerID)
+		if err != nil {
+			return err
+		}
+
+		posting := model.Posting{JournalEntryID: entry.ID, AccountID: accountID, Amount: line.Amount}
+		if err := tx.Create(&posting).Error; err != nil {
+			return err
+		}
+
+		if line.AccountType == model.AccountTypeUser {
+			if err := applyUserBalance(tx, line.OwnerID, line.Amount); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyUserBalance mirrors a wallet posting into User.Balance
+func applyUserBalance(tx *gorm.DB, userID uint, cents int64) error {
+	update := tx.Model(&model.User{}).Where("id = ?", userID)
+	if cents < 0 {
+		update = update.Where("balance >= ?", FromCents(-cents))
+	}
+
+	result := update.Update("Balance", gorm.Expr("balance + ?", FromCents(cents)))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if cents < 0 {
+			return ErrInsufficientBalance
+		}
+		return fmt.Errorf("user %d not found", userID)
+	}
+	return nil
+}
+
+// ledgerAccountID returns the account of an owner, creating it on first use
+func ledgerAccountID(tx *gorm.DB, accountType string, ownerID uint) (uint, error) {
+	account := model.LedgerAccount{Type: accountType, OwnerID: ownerID}
+	err := tx.Where(&account, "Type", "OwnerID").FirstOrCreate(&account).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Created concurrently, read the winner
+		err = tx.Where(&account, "Type", "OwnerID").First(&account).Error
+	}
+	return account.ID, err
+}
+
+// LedgerBalance returns the balance of an account in cents, derived from its postings
+func LedgerBalance(tx *gorm.DB, accountType string, ownerID uint) (int64, error) {
+	var balance int64
+	err := tx.Model(&model.Posting{}).
+		Select("COALESCE(SUM(postings.amount), 0)").
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = postings.account_id").
+		Where("ledger_accounts.type = ? AND ledger_accounts.owner_id = ?", accountType, ownerID).
+		Scan(&balance).Error
+	return balance, err
+}
+
+// BalanceMismatch reports a user whose cached balance differs from the ledger
+type BalanceMismatch struct {
+	UserID        uint    `json:"user_id"`
+	Balance       float64 `json:"balance"`
+	LedgerBalance float64 `json:"ledger_balance"`
+	Repaired      bool    `json:"repaired"`
+}
+
+// VerifyBalances compares every User.Balance with the sum of its wallet postings.
+// With repair set, a mismatching user is locked and compared again in a transaction, so
+// the cached balance is only overwritten with a ledger value no concurrent posting changed.
+func VerifyBalances(ctx context.Context, repair bool) ([]BalanceMismatch, error) {
+	dbInstance := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx)
+
+	var mismatches []BalanceMismatch
+	var users []model.User
+	err := dbInstance.FindInBatches(&users, reconcileBatchSize, func(batch *gorm.DB, _ int) error {
+		for _, user := range users {
+			ledgerBalance, err := LedgerBalance(dbInstance, model.AccountTypeUser, user.ID)
+			if err != nil {
+				return err
+			}
+			if ToCents(user.Balance) == ledgerBalance {
+				continue
+			}
+
+			mismatch := BalanceMismatch{UserID: user.ID, Balance: user.Balance, LedgerBalance: FromCents(ledgerBalance)}
+			if repair {
+				if mismatch, err = repairBalance(dbInstance, user.ID); err != nil {
+					return err
+				}
+				if !mismatch.Repaired {
+					continue // A posting in flight explained the difference
+				}
+			}
+			mismatches = append(mismatches, mismatch)
+		}
+		return nil
+	}).Error
+	return mismatches, err
+}
+
+// repairBalance sets User.Balance to the ledger balance under the user's row lock.
+// PostEntry updates the balance in the transaction of its postings, so while the lock is
+// held no posting of the user can commit and both values are read consistently.
+func repairBalance(dbInstance *gorm.DB, userID uint) (BalanceMismatch, error) {
+	mismatch := BalanceMismatch{UserID: userID}
+	err := dbInstance.Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		ledgerBalance, err := LedgerBalance(tx, model.AccountTypeUser, userID)
+		if err != nil {
+			return err
+		}
+		mismatch.Balance, mismatch.LedgerBalance = user.Balance, FromCents(ledgerBalance)
+		if ToCents(user.Balance) == ledgerBalance {
+			return nil
+		}
+
+		if err := tx.Model(&user).Update("Balance", FromCents(ledgerBalance)).Error; err != nil {
+			return err
+		}
+		mismatch.Repaired = true
+		return nil
+	})
+	return mismatch, err
+}
+
+// BackfillLedger posts opening entries so balances that predate the ledger (seeded users,
+// system funded red packets) are backed by postings. Each account is backfilled once.
+func BackfillLedger(ctx context.Context) error {
+	log := logger.GetLogger()
+	dbInstance := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx)
+
+	var users []model.User
+	if err := dbInstance.FindInBatches(&users, reconcileBatchSize, func(batch *gorm.DB, _ int) error {
+		for _, user := range users {
+			if err := backfillAccount(dbInstance, model.AccountTypeUser, user.ID, ToCents(user.Balance)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error; err != nil {
+		return err
+	}
+
+	var redPackets []model.RedPacket
+	if err := dbInstance.Where("status = ?", model.RedPacketStatusActive).
+		FindInBatches(&redPackets, reconcileBatchSize, func(batch *gorm.DB, _ int) error {
+			for _, redPacket := range redPackets {
+				if err := backfillAccount(dbInstance, model.AccountTypeRedPacket, redPacket.ID, ToCents(redPacket.RemainingAmount)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error; err != nil {
+		return err
+	}
+
+	log.InfoContext(ctx, "Ledger backfill completed")
+	return nil
+}
+
+// backfillAccount funds an account from external up to the expected balance.
+// The cached user balance is already correct, so it is not touched.
+func backfillAccount(dbInstance *gorm.DB, accountType string, ownerID uint, expected int64) error {
+	return dbInstance.Transaction(func(tx *gorm.DB) error {
+		balance, err := LedgerBalance(tx, accountType, ownerID)
+		if err != nil || balance == expected {
+			return err
+		}
+
+		diff := expected - balance
+		reference := fmt.Sprintf("opening:%s:%d", accountType, ownerID)
+		var posted int64
+		if err := tx.Model(&model.JournalEntry{}).Where("reference = ?", reference).Count(&posted).Error; err != nil || posted > 0 {
+			return err // Already backfilled, remaining drift is reported by VerifyBalances
+		}
+
+		entry := model.JournalEntry{Type: model.EntryTypeOpeningBalance, Reference: reference}
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+
+		for _, line := range []LedgerLine{{accountType, ownerID, diff}, ExternalLine(-diff)} {
+			accountID, err := ledgerAccountID(tx, line.AccountType, line.OwnerID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&model.Posting{JournalEntryID: entry.ID, AccountID: accountID, Amount: line.Amount}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+)
+
+// useTestDB makes sqliteDB the db.GetDB singleton for the duration of the test
+func useTestDB(t *testing.T, sqliteDB *gorm.DB) {
+	t.Helper()
+	previous := db.DB
+	db.DB = sqliteDB
+	t.Cleanup(func() { db.DB = previous })
+}
+
+// createUser inserts a user with a cached balance and no postings
+func createUser(t *testing.T, tx *gorm.DB, id uint, balance float64) {
+	t.Helper()
+	if err := tx.Create(&model.User{ID: id, Username: fmt.Sprintf("user%d", id), Balance: balance}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+}
+
+// userBalance returns the cached balance of a user in cents
+func userBalance(t *testing.T, tx *gorm.DB, id uint) int64 {
+	t.Helper()
+	var user model.User
+	if err := tx.First(&user, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ToCents(user.Balance)
+}
+
+// ledgerBalance returns the ledger balance of an account in cents
+func ledgerBalance(t *testing.T, tx *gorm.DB, accountType string, ownerID uint) int64 {
+	t.Helper()
+	balance, err := LedgerBalance(tx, accountType, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func TestEvenShareAddsUpToTheFunding(t *testing.T) {
+	for cents := int64(1); cents <= 300; cents++ {
+		for count := 1; int64(count) <= cents && count <= 12; count++ {
+			redPacket := model.RedPacket{RemainingAmount: FromCents(cents), RemainingCount: count}
+			var paid int64
+			for redPacket.RemainingCount > 0 {
+				share := ToCents(evenShare(&redPacket))
+				if share < 1 {
+					t.Fatalf("%d cents over %d: empty share", cents, count)
+				}
+				paid += share
+				redPacket.RemainingAmount = FromCents(ToCents(redPacket.RemainingAmount) - share)
+				redPacket.RemainingCount--
+			}
+			if paid != cents {
+				t.Fatalf("%d cents over %d shares paid %d", cents, count, paid)
+			}
+		}
+	}
+}
+
+func TestPostEntry(t *testing.T) {
+	sqliteDB := newTestDB(t)
+	createUser(t, sqliteDB, 1, 0)
+
+	if err := PostEntry(sqliteDB, model.EntryTypeDeposit, "dep:1", ExternalLine(-500), UserLine(1, 400)); !errors.Is(err, ErrUnbalancedEntry) {
+		t.Fatalf("expected ErrUnbalancedEntry, got %v", err)
+	}
+	if err := PostEntry(sqliteDB, model.EntryTypeDeposit, "dep:1", ExternalLine(-500), UserLine(1, 500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := PostEntry(sqliteDB, model.EntryTypeDeposit, "dep:1", ExternalLine(-500), UserLine(1, 500)); !errors.Is(err, ErrDuplicateEntry) {
+		t.Fatalf("expected ErrDuplicateEntry, got %v", err)
+	}
+
+	// Overdrawing the wallet fails and the transaction leaves nothing behind
+	err := sqliteDB.Transaction(func(tx *gorm.DB) error {
+		return PostEntry(tx, model.EntryTypeWithdrawalHold, "wd:1:hold", UserLine(1, -600), payoutLine(1, 600))
+	})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance, got %v", err)
+	}
+
+	if balance := userBalance(t, sqliteDB, 1); balance != 500 {
+		t.Fatalf("expected a cached balance of 500, got %d", balance)
+	}
+	if balance := ledgerBalance(t, sqliteDB, model.AccountTypeUser, 1); balance != 500 {
+		t.Fatalf("expected a ledger balance of 500, got %d", balance)
+	}
+	if balance := ledgerBalance(t, sqliteDB, model.AccountTypeExternal, 0); balance != -500 {
+		t.Fatalf("expected an external balance of -500, got %d", balance)
+	}
+}
+
+func TestStrictGrabCreditsMatchTheFunding(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	repo := NewGormPacketRepository(sqliteDB, &MemorySessionPinner{})
+
+	// 0.14 over 3 shares used to credit 5+5+5 cents
+	redPacket := createRedPacket(t, sqliteDB, 0.14, 3, model.GrabModeStrict)
+	if err := PostEntry(sqliteDB, model.EntryTypePacketFunding, "fund:1", ExternalLine(-14), RedPacketLine(redPacket.ID, 14)); err != nil {
+		t.Fatal(err)
+	}
+
+	var credited int64
+	for userID := uint(1); userID <= 3; userID++ {
+		createUser(t, sqliteDB, userID, 0)
+		amount, err := repo.PersistGrab(ctx, userID, redPacket.ID, evenShare)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := &kafka.Event{ID: fmt.Sprintf("event-%d", userID), Type: kafka.EventTypeRedPacketGrabbed,
+			Payload: &kafka.GrabbedPayload{UserID: userID, RedPacketID: redPacket.ID, Amount: amount}}
+		if err := HandleGrabbedEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+		credited += userBalance(t, sqliteDB, userID)
+	}
+
+	if credited != 14 {
+		t.Fatalf("credited %d cents for 14 funded", credited)
+	}
+	if escrow := ledgerBalance(t, sqliteDB, model.AccountTypeRedPacket, redPacket.ID); escrow != 0 {
+		t.Fatalf("expected an empty escrow, got %d", escrow)
+	}
+	stored, _ := repo.GetRedPacket(ctx, redPacket.ID)
+	if stored.RemainingCount != 0 || ToCents(stored.RemainingAmount) != 0 {
+		t.Fatalf("expected an empty red packet, got count=%d amount=%.2f", stored.RemainingCount, stored.RemainingAmount)
+	}
+}
+
+func TestVerifyBalancesRepair(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	createUser(t, sqliteDB, 1, 0)
+	createUser(t, sqliteDB, 2, 0)
+	if err := PostEntry(sqliteDB, model.EntryTypeDeposit, "dep:1", ExternalLine(-700), UserLine(1, 700)); err != nil {
+		t.Fatal(err)
+	}
+	sqliteDB.Model(&model.User{ID: 1}).Update("Balance", 9.99) // Drifted cache
+
+	mismatches, err := VerifyBalances(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].UserID != 1 || mismatches[0].LedgerBalance != 7 || mismatches[0].Repaired {
+		t.Fatalf("expected an unrepaired mismatch of user 1, got %+v", mismatches)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 999 {
+		t.Fatalf("verification without repair changed the balance to %d", balance)
+	}
+
+	mismatches, err = VerifyBalances(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || !mismatches[0].Repaired {
+		t.Fatalf("expected a repaired mismatch, got %+v", mismatches)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 700 {
+		t.Fatalf("expected the ledger balance 700 after repair, got %d", balance)
+	}
+	if mismatches, _ := VerifyBalances(ctx, false); len(mismatches) != 0 {
+		t.Fatalf("expected no mismatch after repair, got %+v", mismatches)
+	}
+}
+
+func TestBackfillLedger(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	createUser(t, sqliteDB, 1, 12.5)
+	createUser(t, sqliteDB, 2, 0)
+	redPacket := createRedPacket(t, sqliteDB, 3, 2, model.GrabModeStrict)
+
+	for i := 0; i < 2; i++ { // A second run posts nothing
+		if err := BackfillLedger(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if balance := ledgerBalance(t, sqliteDB, model.AccountTypeUser, 1); balance != 1250 {
+		t.Fatalf("expected user 1 backfilled with 1250, got %d", balance)
+	}
+	if balance := ledgerBalance(t, sqliteDB, model.AccountTypeRedPacket, redPacket.ID); balance != 300 {
+		t.Fatalf("expected the escrow backfilled with 300, got %d", balance)
+	}
+	if balance := ledgerBalance(t, sqliteDB, model.AccountTypeExternal, 0); balance != -1550 {
+		t.Fatalf("expected external to fund 1550, got %d", balance)
+	}
+	var entries int64
+	sqliteDB.Model(&model.JournalEntry{}).Count(&entries)
+	if entries != 2 {
+		t.Fatalf("expected 2 opening entries, got %d", entries)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 1250 {
+		t.Fatalf("backfill changed the cached balance to %d", balance)
+	}
+	if mismatches, _ := VerifyBalances(ctx, false); len(mismatches) != 0 {
+		t.Fatalf("expected balances to match the ledger, got %+v", mismatches)
+	}
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"red-packet-system/model"
+)
+
+// In-memory implementations of the grab dependencies, for tests and local simulations.
+// They follow the semantics of the MySQL, Redis and Kafka implementations.
+
+// MemoryPacketRepository is an in-memory PacketRepository
+type MemoryPacketRepository struct {
+	mu         sync.Mutex
+	redPackets map[uint]*model.RedPacket
+	logs       []model.RedPacketLog
+
+	// FailPersist is returned by PersistGrab, without writing anything, when set
+	FailPersist error
+}
+
+// NewMemoryPacketRepository returns an empty MemoryPacketRepository
+func NewMemoryPacketRepository() *MemoryPacketRepository {
+	return &MemoryPacketRepository{redPackets: map[uint]*model.RedPacket{}}
+}
+
+// Add stores a red packet
+func (r *MemoryPacketRepository) Add(redPacket model.RedPacket) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redPackets[redPacket.ID] = &redPacket
+}
+
+// Logs returns the claims of a red packet
+func (r *MemoryPacketRepository) Logs(redPacketID uint) []model.RedPacketLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var logs []model.RedPacketLog
+	for _, logEntry := range r.logs {
+		if logEntry.RedPacketID == redPacketID {
+			logs = append(logs, logEntry)
+		}
+	}
+	return logs
+}
+
+func (r *MemoryPacketRepository) GetRedPacket(ctx context.Context, redPacketID uint) (*model.RedPacket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	redPacket, ok := r.redPackets[redPacketID]
+	if !ok {
+		return nil, ErrRedPacketNotFound
+	}
+	copied := *redPacket
+	return &copied, nil
+}
+
+func (r *MemoryPacketRepository) ListGrabbers(ctx context.Context, redPacketID uint) ([]uint, error) {
+	var grabberIDs []uint
+	for _, logEntry := range r.Logs(redPacketID) {
+		grabberIDs = append(grabberIDs, logEntry.UserID)
+	}
+	return grabberIDs, nil
+}
+
+func (r *MemoryPacketRepository) PersistGrab(ctx context.Context, userID, redPacketID uint, share func(*model.RedPacket) float64) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.FailPersist != nil {
+		return 0, r.FailPersist
+	}
+	redPacket, ok := r.redPackets[redPacketID]
+	if !ok {
+		return 0, ErrRedPacketNotExist
+	}
+	if redPacket.RemainingCount <= 0 {
+		return 0, ErrRedPacketEmpty
+	}
+	amount := share(redPacket)
+	redPacket.RemainingAmount = FromCents(ToCents(redPacket.RemainingAmount) - ToCents(amount))
+	redPacket.RemainingCount--
+	redPacket.Version++
+	r.logs = append(r.logs, model.RedPacketLog{
+		ID:          uint(len(r.logs) + 1),
+		UserID:      userID,
+		RedPacketID: redPacketID,
+		Amount:      amount,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	})
+	return amount, nil
+}
+
+// PersistClaim records a fast mode claim the way HandleClaimedEvent does. It returns false,
+// without writing anything, when the user already has a claim on the red packet.
+func (r *MemoryPacketRepository) PersistClaim(userID, redPacketID uint, amount float64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	redPacket, ok := r.redPackets[redPacketID]
+	if !ok {
+		return false
+	}
+	for _, logEntry := range r.logs {
+		if logEntry.RedPacketID == redPacketID && logEntry.ClaimUserID != nil && *logEntry.ClaimUserID == userID {
+			return false // Unique (red_packet_id, claim_user_id)
+		}
+	}
+
+	redPacket.RemainingAmount = FromCents(ToCents(redPacket.RemainingAmount) - ToCents(amount))
+	redPacket.RemainingCount--
+	redPacket.Version++
+	r.logs = append(r.logs, model.RedPacketLog{
+		ID:          uint(len(r.logs) + 1),
+		UserID:      userID,
+		RedPacketID: redPacketID,
+		ClaimUserID: &userID,
+		Amount:      amount,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	})
+	return true
+}
+
+// memoryFastState is the fast mode state of one red packet
+type memoryFastState struct {
+	stock    int
+	cents    int64
+	grabbers map[uint]bool
+	pending  map[uint]int64
+}
+
+// MemoryStockCache is an in-memory StockCache with the semantics of the Redis Lua scripts
+type MemoryStockCache struct {
+	mu    sync.Mutex
+	known map[uint]bool
+	modes map[uint]string
+	stock map[uint]int
+	fast  map[uint]*memoryFastState
+}
+
+// NewMemoryStockCache returns an empty MemoryStockCache
+func NewMemoryStockCache() *MemoryStockCache {
+	return &MemoryStockCache{
+		known: map[uint]bool{},
+		modes: map[uint]string{},
+		stock: map[uint]int{},
+		fast:  map[uint]*memoryFastState{},
+	}
+}
+
+// AddToFilter records a red packet in the existence filter
+func (c *MemoryStockCache) AddToFilter(redPacketID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.known[redPacketID] = true
+}
+
+// Stock returns the cached strict mode stock, ok is false when not cached
+func (c *MemoryStockCache) Stock(redPacketID uint) (stock int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stock, ok = c.stock[redPacketID]
+	return stock, ok
+}
+
+// FastState returns the fast mode stock, remaining cents and claim count
+func (c *MemoryStockCache) FastState(redPacketID uint) (stock int, cents int64, claims int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.fast[redPacketID]
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return state.stock, state.cents, len(state.grabbers), true
+}
+
+// PendingFast returns the fast mode claims marked pending, user ID to cents
+func (c *MemoryStockCache) PendingFast(redPacketID uint) map[uint]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.fast[redPacketID]
+	if !ok {
+		return nil
+	}
+	return maps.Clone(state.pending)
+}
+
+func (c *MemoryStockCache) MayExist(ctx context.Context, redPacketID uint) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.known[redPacketID]
+}
+
+func (c *MemoryStockCache) GrabMode(ctx context.Context, redPacketID uint) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mode, ok := c.modes[redPacketID]
+	return mode, ok
+}
+
+func (c *MemoryStockCache) SetGrabMode(ctx context.Context, redPacketID uint, mode string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modes[redPacketID] = mode
+	return nil
+}
+
+func (c *MemoryStockCache) DecrStock(ctx context.Context, redPacketID uint) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stock, ok := c.stock[redPacketID]
+	if !ok {
+		return StockNotCached, nil
+	}
+	if stock <= 0 {
+		return StockEmpty, nil
+	}
+	c.stock[redPacketID] = stock - 1
+	return stock - 1, nil
+}
+
+func (c *MemoryStockCache) SetStock(ctx context.Context, redPacketID uint, stock int, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stock[redPacketID] = stock
+	return nil
+}
+
+func (c *MemoryStockCache) IncrStock(ctx context.Context, redPacketID uint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stock[redPacketID]++
+	return nil
+}
+
+func (c *MemoryStockCache) ClaimFast(ctx context.Context, redPacketID, userID uint) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.fast[redPacketID]
+	if !ok {
+		return StockNotCached, nil
+	}
+	if state.grabbers[userID] {
+		return StockClaimed, nil
+	}
+	if state.stock <= 0 {
+		return StockEmpty, nil
+	}
+
+	share := state.cents
+	if state.stock > 1 {
+		share = state.cents / int64(state.stock)
+	}
+	state.stock--
+	state.cents -= share
+	state.grabbers[userID] = true
+	return share, nil
+}
+
+func (c *MemoryStockCache) LoadFast(ctx context.Context, redPacketID uint, stock int, cents int64, grabbers []uint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.fast[redPacketID]; ok {
+		return nil
+	}
+	state := &memoryFastState{stock: stock, cents: cents, grabbers: map[uint]bool{}, pending: map[uint]int64{}}
+	for _, id := range grabbers {
+		state.grabbers[id] = true
+	}
+	c.fast[redPacketID] = state
+	return nil
+}
+
+func (c *MemoryStockCache) RollbackFast(ctx context.Context, redPacketID, userID uint, cents int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.fast[redPacketID]; ok && state.grabbers[userID] {
+		delete(state.grabbers, userID)
+		state.stock++
+		state.cents += cents
+	}
+	return nil
+}
+
+func (c *MemoryStockCache) MarkPendingFast(ctx context.Context, redPacketID, userID uint, cents int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.fast[redPacketID]; ok {
+		state.pending[userID] = cents
+	}
+	return nil
+}
+
+// MemoryLockProvider is an in-process LockProvider
+type MemoryLockProvider struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// NewMemoryLockProvider returns a MemoryLockProvider
+func NewMemoryLockProvider() *MemoryLockProvider {
+	return &MemoryLockProvider{locks: map[string]chan struct{}{}}
+}
+
+func (p *MemoryLockProvider) Lock(ctx context.Context, key string) (func(), error) {
+	p.mu.Lock()
+	lock, ok := p.locks[key]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		p.locks[key] = lock
+	}
+	p.mu.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// MemorySessionPinner records pinned sessions
+type MemorySessionPinner struct {
+	mu     sync.Mutex
+	pinned []uint
+}
+
+// Pinned returns the pinned user IDs, in call order
+func (p *MemorySessionPinner) Pinned() []uint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]uint(nil), p.pinned...)
+}
+
+func (p *MemorySessionPinner) PinSession(ctx context.Context, userID uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pinned = append(p.pinned, userID)
+}
+
+// ClaimedEvent is a fast mode claim recorded by MemoryEventPublisher
+type ClaimedEvent struct {
+	UserID      uint
+	RedPacketID uint
+	Amount      float64
+}
+
+// MemoryEventPublisher records published claims
+type MemoryEventPublisher struct {
+	mu     sync.Mutex
+	events []ClaimedEvent
+
+	// Fail is returned by PublishClaimed, without recording the event, when set
+	Fail error
+}
+
+// Events returns the published claims
+func (p *MemoryEventPublisher) Events() []ClaimedEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ClaimedEvent(nil), p.events...)
+}
+
+func (p *MemoryEventPublisher) PublishClaimed(ctx context.Context, userID, redPacketID uint, amount float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Fail != nil {
+		return p.Fail
+	}
+	p.events = append(p.events, ClaimedEvent{UserID: userID, RedPacketID: redPacketID, Amount: amount})
+	return nil
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/db"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/redisclient"
+)
+
+// Reconciliation checks
+const (
+	CheckLogTotals   = "log_totals"   // RedPacket remaining count/amount vs RedPacketLog rows
+	CheckRedisStock  = "redis_stock"  // Redis stock vs MySQL remaining count
+	CheckClaimSet    = "claim_set"    // Fast mode grabbers set vs RedPacketLog users
+	CheckRedisTotals = "redis_totals" // Fast mode Redis stock + grabbers vs total count
+)
+
+const (
+	reconcileBatchSize = 500
+	reconcileLockKey   = "lock:stock_reconciler"
+	amountTolerance    = 0.005           // Half a cent
+	republishTimeout   = 5 * time.Second // Wait for the acknowledgement of a republished claim
+)
+
+// StockDiscrepancy describes a red packet whose Redis and MySQL state disagree
+type StockDiscrepancy struct {
+	RedPacketID uint   `json:"red_packet_id"`
+	GrabMode    string `json:"grab_mode"`
+	Check       string `json:"check"`
+	Detail      string `json:"detail"`
+	Repaired    bool   `json:"repaired"`
+}
+
+// ReconcileStock compares Redis stock and claim sets with MySQL rows and RedPacketLog
+// counts for every active red packet. With repair set, safe fixes are applied:
+//   - MySQL remaining count/amount are recomputed from RedPacketLog, the source of truth;
+//   - strict mode Redis stock is dropped so the next grab reloads it from MySQL;
+//   - fast mode Redis state is rebuilt from MySQL when it has no claims MySQL lacks and
+//     no claim changed it during the comparison.
+//
+// Fast mode claims present in Redis but not in MySQL may still be in flight to the
+// worker, so they are only reported. Those whose event delivery was unknown to the grab
+// are published again, with or without repair: the worker skips claims it already logged.
+func ReconcileStock(ctx context.Context, repair bool) ([]StockDiscrepancy, error) {
+	// Read from the master, replica lag would show up as false discrepancies
+	dbInstance := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx)
+	redisClient, redlock := redisclient.GetRedisClient(), redisclient.GetRedlock()
+	events := NewKafkaEventPublisher()
+
+	var discrepancies []StockDiscrepancy
+	var lastID uint
+	for {
+		var redPackets []model.RedPacket
+		if err := dbInstance.
+			Where("status = ? AND id > ?", 1, lastID).
+			Order("id").
+			Limit(reconcileBatchSize).
+			Find(&redPackets).Error; err != nil {
+			return discrepancies, err
+		}
+		if len(redPackets) == 0 {
+			return discrepancies, nil
+		}
+
+		for i := range redPackets {
+			found, err := reconcileRedPacket(ctx, dbInstance, redisClient, redlock, events, &redPackets[i], repair)
+			if err != nil {
+				return discrepancies, fmt.Errorf("red packet %d: %v", redPackets[i].ID, err)
+			}
+			discrepancies = append(discrepancies, found...)
+		}
+		lastID = redPackets[len(redPackets)-1].ID
+	}
+}
+
+// reconcileRedPacket checks one red packet while holding its grab lock
+func reconcileRedPacket(ctx context.Context, dbInstance *gorm.DB, redisClient *redis.ClusterClient, redlock *redsync.Redsync, events EventPublisher, redPacket *model.RedPacket, repair bool) ([]StockDiscrepancy, error) {
+	// Strict mode grabs hold this lock, so Redis and MySQL are stable while we compare
+	mutex := redlock.NewMutex(fmt.Sprintf("lock:red_packet_%d", redPacket.ID))
+	if err := mutex.LockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer mutex.Unlock()
+
+	// Reload under the lock
+	if err := dbInstance.First(redPacket, redPacket.ID).Error; err != nil {
+		return nil, err
+	}
+
+	var totals struct {
+		Count int64
+		Sum   float64
+	}
+	if err := db.Logs(dbInstance, redPacket.ID).
+		Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS sum").
+		Where("red_packet_id = ?", redPacket.ID).
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+
+	var discrepancies []StockDiscrepancy
+	report := func(check, detail string, repaired bool) {
+		discrepancies = append(discrepancies, StockDiscrepancy{
+			RedPacketID: redPacket.ID,
+			GrabMode:    redPacket.GrabMode,
+			Check:       check,
+			Detail:      detail,
+			Repaired:    repaired,
+		})
+	}
+
+	// MySQL counters must match the claim logs
+	expectedCount := redPacket.TotalCount - int(totals.Count)
+	expectedAmount := redPacket.TotalAmount - totals.Sum
+	if redPacket.RemainingCount != expectedCount || math.Abs(redPacket.RemainingAmount-expectedAmount) > amountTolerance {
+		detail := fmt.Sprintf("remaining count=%d amount=%.2f, logs imply count=%d amount=%.2f",
+			redPacket.RemainingCount, redPacket.RemainingAmount, expectedCount, expectedAmount)
+		repaired := false
+		if repair {
+			if err := dbInstance.Model(redPacket).Updates(map[string]interface{}{
+				"RemainingCount":  expectedCount,
+				"RemainingAmount": expectedAmount,
+				"Version":         gorm.Expr("version + 1"),
+			}).Error; err != nil {
+				return discrepancies, err
+			}
+			repaired = true
+		}
+		report(CheckLogTotals, detail, repaired)
+	}
+
+	if redPacket.GrabMode == model.GrabModeFast {
+		found, err := reconcileFastState(ctx, dbInstance, redisClient, events, redPacket, repair)
+		return append(discrepancies, found...), err
+	}
+
+	found, err := reconcileStrictStock(ctx, redisClient, redPacket, expectedCount, repair)
+	return append(discrepancies, found...), err
+}
+
+// reconcileStrictStock compares the strict mode Redis stock counter with MySQL
+func reconcileStrictStock(ctx context.Context, redisClient *redis.ClusterClient, redPacket *model.RedPacket, expectedCount int, repair bool) ([]StockDiscrepancy, error) {
+	redisKey := fmt.Sprintf("red_packet_%d", redPacket.ID)
+
+	stock, err := redisClient.Get(ctx, redisKey).Int()
+	if err == redis.Nil {
+		return nil, nil // Not cached, the next grab loads it from MySQL
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stock == expectedCount {
+		return nil, nil
+	}
+
+	repaired := false
+	if repair {
+		if err := redisClient.Del(ctx, redisKey).Err(); err != nil {
+			return nil, err
+		}
+		repaired = true
+	}
+	return []StockDiscrepancy{{
+		RedPacketID: redPacket.ID,
+		GrabMode:    redPacket.GrabMode,
+		Check:       CheckRedisStock,
+		Detail:      fmt.Sprintf("redis stock=%d, mysql remaining count=%d", stock, expectedCount),
+		Repaired:    repaired,
+	}}, nil
+}
+
+// reconcileFastState compares the fast mode Redis state with MySQL claim logs. The state is
+// rebuilt from the logs in one script that gives up if a claim changed it since it was read,
+// so concurrent fast grabs and claims still on their way to the worker are never wiped.
+// Pending claims missing from the logs are published again through events.
+func reconcileFastState(ctx context.Context, dbInstance *gorm.DB, redisClient *redis.ClusterClient, events EventPublisher, redPacket *model.RedPacket, repair bool) ([]StockDiscrepancy, error) {
+	stateKey, grabbersKey := FastStateKey(redPacket.ID), FastGrabbersKey(redPacket.ID)
+
+	state, err := redisClient.HMGet(ctx, stateKey, "stock", "amount").Result()
+	if err != nil {
+		return nil, err
+	}
+	if state[0] == nil {
+		return nil, nil // Not loaded, the next grab loads it from MySQL
+	}
+	stock, err1 := strconv.ParseInt(fmt.Sprint(state[0]), 10, 64)
+	cents, err2 := strconv.ParseInt(fmt.Sprint(state[1]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid fast state %v", state)
+	}
+
+	members, err := redisClient.SMembers(ctx, grabbersKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read after Redis: a claim persisted in between is missing from members and caught by the rebuild script
+	var logs []model.RedPacketLog
+	if err := db.Logs(dbInstance, redPacket.ID).
+		Select("user_id, amount").
+		Where("red_packet_id = ?", redPacket.ID).
+		Find(&logs).Error; err != nil {
+		return nil, err
+	}
+
+	claimed := make(map[string]bool, len(members))
+	for _, member := range members {
+		claimed[member] = true
+	}
+	logged := make(map[string]bool, len(logs))
+	loggedIDs := make([]uint, 0, len(logs))
+	var loggedCents int64
+	var missingInRedis []string
+	for _, logEntry := range logs {
+		member := strconv.FormatUint(uint64(logEntry.UserID), 10)
+		logged[member] = true
+		loggedIDs = append(loggedIDs, logEntry.UserID)
+		loggedCents += ToCents(logEntry.Amount)
+		if !claimed[member] {
+			missingInRedis = append(missingInRedis, member)
+		}
+	}
+	var unpersisted []string
+	for _, member := range members {
+		if !logged[member] {
+			unpersisted = append(unpersisted, member)
+		}
+	}
+
+	var discrepancies []StockDiscrepancy
+	report := func(check, detail string, repaired bool) {
+		discrepancies = append(discrepancies, StockDiscrepancy{
+			RedPacketID: redPacket.ID,
+			GrabMode:    redPacket.GrabMode,
+			Check:       check,
+			Detail:      detail,
+			Repaired:    repaired,
+		})
+	}
+
+	// Redis state can only be rebuilt from MySQL when MySQL knows every claim
+	canRebuild := len(unpersisted) == 0
+	rebuilt := false
+	if repair && canRebuild && (len(missingInRedis) > 0 || stock+int64(len(members)) != int64(redPacket.TotalCount)) {
+		rebuilt, err = rebuildFastState(ctx, redisClient, redPacket.ID, stock, cents, len(members),
+			redPacket.TotalCount-len(logs), ToCents(redPacket.TotalAmount)-loggedCents, loggedIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stock+int64(len(members)) != int64(redPacket.TotalCount) {
+		report(CheckRedisTotals, fmt.Sprintf("redis stock=%d + grabbers=%d != total count=%d",
+			stock, len(members), redPacket.TotalCount), rebuilt)
+	}
+	if len(missingInRedis) > 0 {
+		report(CheckClaimSet, fmt.Sprintf("users %v logged in MySQL but missing from redis grabbers", missingInRedis), rebuilt)
+	}
+	if len(unpersisted) > 0 {
+		report(CheckClaimSet, fmt.Sprintf("users %v claimed in redis but not persisted (%d logs), possibly in flight",
+			unpersisted, len(logs)), false)
+	}
+
+	republished, err := republishPendingClaims(ctx, redisClient, events, redPacket.ID, logged)
+	if err != nil {
+		return discrepancies, err
+	}
+	if len(republished) > 0 {
+		report(CheckClaimSet, fmt.Sprintf("pending claims of users %v published again", republished), true)
+	}
+	return discrepancies, nil
+}
+
+// republishPendingClaims publishes the pending claims of a fast mode red packet that are not
+// logged yet, and forgets them once Kafka acknowledged them or the worker logged them.
+// It returns the users whose claims were published.
+func republishPendingClaims(ctx context.Context, redisClient *redis.ClusterClient, events EventPublisher, redPacketID uint, logged map[string]bool) ([]string, error) {
+	pendingKey := FastPendingKey(redPacketID)
+	pending, err := redisClient.HGetAll(ctx, pendingKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var republished []string
+	for member, value := range pending {
+		if !logged[member] {
+			userID, err1 := strconv.ParseUint(member, 10, 64)
+			cents, err2 := strconv.ParseInt(value, 10, 64)
+			if err1 != nil || err2 != nil {
+				return republished, fmt.Errorf("invalid pending claim %s=%s", member, value)
+			}
+			publishCtx, cancel := context.WithTimeout(ctx, republishTimeout)
+			err := events.PublishClaimed(publishCtx, uint(userID), redPacketID, FromCents(cents))
+			cancel()
+			if err != nil {
+				return republished, fmt.Errorf("republishing the claim of user %s: %v", member, err)
+			}
+			republished = append(republished, member)
+		}
+		if err := redisClient.HDel(ctx, pendingKey, member).Err(); err != nil {
+			return republished, err
+		}
+	}
+	return republished, nil
+}
+
+// reconcileRepair enables the repairs of the scheduled reconciliation
+var reconcileRepair atomic.Bool
+
+// SetReconcileRepair turns the repairs of a running reconciler on or off
+func SetReconcileRepair(repair bool) {
+	reconcileRepair.Store(repair)
+}
+
+// StartReconciler runs ReconcileStock every interval until ctx is cancelled.
+// A Redlock keeps concurrent API instances from reconciling at the same time.
+func StartReconciler(ctx context.Context, interval time.Duration, repair bool) {
+	log := logger.GetLogger()
+	log.Info("Stock reconciler started", "interval", interval, "repair", repair)
+	SetReconcileRepair(repair)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stock reconciler stopped")
+			return
+		case <-ticker.C:
+			mutex := redisclient.GetRedlock().NewMutex(reconcileLockKey, redsync.WithExpiry(interval), redsync.WithTries(1))
+			if err := mutex.LockContext(ctx); err != nil {
+				continue // Another instance is reconciling
+			}
+
+			discrepancies, err := ReconcileStock(ctx, reconcileRepair.Load())
+			for _, d := range discrepancies {
+				log.Warn("Stock discrepancy", "red_packet_id", d.RedPacketID, "grab_mode", d.GrabMode, "check", d.Check, "detail", d.Detail, "repaired", d.Repaired)
+			}
+			if err != nil {
+				log.Error("Stock reconciliation failed", "error", err)
+			} else {
+				log.Info("Stock reconciliation completed", "discrepancies", len(discrepancies))
+			}
+			mutex.Unlock()
+		}
+	}
+}
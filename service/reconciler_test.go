@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"red-packet-system/db"
+	"red-packet-system/model"
+)
+
+// TestReconcileFastStateRepairRacesClaims repairs a fast mode state that lost a persisted
+// claim while other users claim shares: no claim granted by Redis may be wiped by the repair.
+func TestReconcileFastStateRepairRacesClaims(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	client := newTestRedis(t)
+	cache := NewRedisStockCache(client)
+
+	const shares, claimers = 50, 60
+	for round := 0; round < 20; round++ {
+		redPacket := createRedPacket(t, sqliteDB, 100, shares, model.GrabModeFast)
+
+		// User 1's claim is persisted, but Redis was loaded before it
+		if err := db.Logs(sqliteDB, redPacket.ID).Create(&model.RedPacketLog{UserID: 1, RedPacketID: redPacket.ID, Amount: 2}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.LoadFast(ctx, redPacket.ID, shares, 10000, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		start := make(chan struct{})
+		granted := make([]bool, claimers)
+		var wg sync.WaitGroup
+		for i := 0; i < claimers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				share, err := cache.ClaimFast(ctx, redPacket.ID, uint(100+i))
+				if err != nil {
+					t.Error(err)
+				}
+				granted[i] = share >= 0
+			}(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 5; i++ {
+				if _, err := reconcileFastState(ctx, sqliteDB, client, &MemoryEventPublisher{}, &redPacket, true); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		close(start)
+		wg.Wait()
+
+		for i, ok := range granted {
+			if !ok {
+				continue
+			}
+			member := strconv.Itoa(100 + i)
+			if held, _ := client.SIsMember(ctx, FastGrabbersKey(redPacket.ID), member).Result(); !held {
+				t.Fatalf("round %d: claim of user %s was wiped by the repair", round, member)
+			}
+		}
+		stock, _ := client.HGet(ctx, FastStateKey(redPacket.ID), "stock").Int()
+		grabbers, _ := client.SCard(ctx, FastGrabbersKey(redPacket.ID)).Result()
+		if stock+int(grabbers) != shares {
+			t.Fatalf("round %d: stock=%d + grabbers=%d != %d shares", round, stock, grabbers, shares)
+		}
+	}
+}
+
+func TestReconcileFastStateRepairsLostClaim(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	client := newTestRedis(t)
+	cache := NewRedisStockCache(client)
+
+	redPacket := createRedPacket(t, sqliteDB, 10, 5, model.GrabModeFast)
+	db.Logs(sqliteDB, redPacket.ID).Create(&model.RedPacketLog{UserID: 1, RedPacketID: redPacket.ID, Amount: 2})
+	cache.LoadFast(ctx, redPacket.ID, 5, 1000, nil)
+
+	discrepancies, err := reconcileFastState(ctx, sqliteDB, client, &MemoryEventPublisher{}, &redPacket, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discrepancies) == 0 || !discrepancies[0].Repaired {
+		t.Fatalf("expected a repaired discrepancy, got %+v", discrepancies)
+	}
+
+	// User 1 cannot claim twice and the rebuilt state holds the remaining shares
+	if share, _ := cache.ClaimFast(ctx, redPacket.ID, 1); share != StockClaimed {
+		t.Fatalf("ClaimFast by user 1 = %d, want %d", share, StockClaimed)
+	}
+	state, _ := client.HGetAll(ctx, FastStateKey(redPacket.ID)).Result()
+	if state["stock"] != "4" || state["amount"] != "800" {
+		t.Fatalf("rebuilt state is %v, want stock=4 amount=800", state)
+	}
+}
+
+func TestReconcileFastStateRepublishesPendingClaims(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	client := newTestRedis(t)
+	cache := NewRedisStockCache(client)
+
+	// Users 1 and 2 claimed with an unknown delivery; only user 1's claim reached the worker
+	redPacket := createRedPacket(t, sqliteDB, 10, 5, model.GrabModeFast)
+	cache.LoadFast(ctx, redPacket.ID, 5, 1000, nil)
+	for _, userID := range []uint{1, 2} {
+		share, _ := cache.ClaimFast(ctx, redPacket.ID, userID)
+		cache.MarkPendingFast(ctx, redPacket.ID, userID, share)
+	}
+	db.Logs(sqliteDB, redPacket.ID).Create(&model.RedPacketLog{UserID: 1, RedPacketID: redPacket.ID, Amount: 2})
+
+	// A failed publish keeps the claim pending for the next run
+	events := &MemoryEventPublisher{Fail: errors.New("broker down")}
+	if _, err := reconcileFastState(ctx, sqliteDB, client, events, &redPacket, false); err == nil {
+		t.Fatal("reconcile succeeded although the claim could not be published")
+	}
+	if held, _ := client.HExists(ctx, FastPendingKey(redPacket.ID), "2").Result(); !held {
+		t.Fatal("claim of user 2 forgotten after a failed publish")
+	}
+
+	events.Fail = nil
+	if _, err := reconcileFastState(ctx, sqliteDB, client, events, &redPacket, false); err != nil {
+		t.Fatal(err)
+	}
+	want := []ClaimedEvent{{UserID: 2, RedPacketID: redPacket.ID, Amount: 2}}
+	if got := events.Events(); !slices.Equal(got, want) {
+		t.Fatalf("published %+v, want %+v", got, want)
+	}
+	if pending, _ := client.HLen(ctx, FastPendingKey(redPacket.ID)).Result(); pending != 0 {
+		t.Fatalf("%d claims still pending, want 0", pending)
+	}
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/config"
+	"red-packet-system/db"
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+	"red-packet-system/redisclient"
+)
+
+// errRefundPending is returned while fast mode claims are still in flight to the worker
+var errRefundPending = errors.New("fast mode claims not yet persisted")
+
+// closeFastStateScript stops new fast mode claims and returns the number of claims made so far,
+// or -1 if the state was not loaded. The closed state is created in that case too, so no grab
+// loads the red packet from MySQL while it is refunded.
+var closeFastStateScript = redis.NewScript(`
+    local loaded = redis.call("EXISTS", KEYS[1])
+    redis.call("HSET", KEYS[1], "stock", 0, "amount", 0)
+    if loaded == 0 then
+        return -1
+    end
+    return redis.call("SCARD", KEYS[2])
+`)
+
+// CreateRedPacketRequest describes a red packet funded from the sender wallet
+type CreateRedPacketRequest struct {
+	SenderID    uint
+	TotalAmount float64
+	TotalCount  int
+	GrabMode    string // Defaults to DEFAULT_GRAB_MODE, then strict
+}
+
+// CreateRedPacket creates a red packet and moves its total amount from the sender wallet
+// into the red packet escrow in the same transaction.
+func CreateRedPacket(ctx context.Context, req CreateRedPacketRequest) (*model.RedPacket, error) {
+	log := logger.GetLogger()
+
+	cents := ToCents(req.TotalAmount)
+	if req.TotalCount <= 0 || cents < int64(req.TotalCount) {
+		return nil, errors.New("each share must be at least 0.01")
+	}
+
+	grabMode := req.GrabMode
+	if grabMode == "" {
+		grabMode = config.LoadConfig().DefaultGrabMode
+	}
+	if grabMode == "" {
+		grabMode = model.GrabModeStrict
+	}
+	if grabMode != model.GrabModeStrict && grabMode != model.GrabModeFast {
+		return nil, fmt.Errorf("invalid grab mode %q", grabMode)
+	}
+
+	redPacket := model.RedPacket{
+		SenderID:        req.SenderID,
+		TotalAmount:     FromCents(cents),
+		RemainingAmount: FromCents(cents),
+		TotalCount:      req.TotalCount,
+		RemainingCount:  req.TotalCount,
+		Status:          model.RedPacketStatusActive,
+		GrabMode:        grabMode,
+	}
+
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&redPacket).Error; err != nil {
+			return err
+		}
+		return PostEntry(tx, model.EntryTypePacketFunding, fmt.Sprintf("fund:%d", redPacket.ID),
+			senderLine(req.SenderID, -cents),
+			RedPacketLine(redPacket.ID, cents),
+		)
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to create red packet", "sender_id", req.SenderID, "error", err)
+		return nil, err
+	}
+	db.PinSession(ctx, req.SenderID)
+
+	log.InfoContext(ctx, "Red packet created", "sender_id", req.SenderID, "red_packet_id", redPacket.ID,
+		"amount", redPacket.TotalAmount, "shares", redPacket.TotalCount, "mode", grabMode)
+	return &redPacket, nil
+}
+
+// RefundExpiredRedPackets expires active red packets created more than ttl ago and refunds
+// their remaining amount to the sender. It returns the number of refunded red packets.
+func RefundExpiredRedPackets(ctx context.Context, ttl time.Duration) (int, error) {
+	log := logger.GetLogger()
+
+	redisClient, redlock := redisclient.GetRedisClient(), redisclient.GetRedlock()
+
+	var redPacketIDs []uint
+	if err := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx).
+		Model(&model.RedPacket{}).
+		Where("status = ? AND created_at < ?", model.RedPacketStatusActive, time.Now().Add(-ttl)).
+		Order("id").
+		Limit(reconcileBatchSize).
+		Pluck("id", &redPacketIDs).Error; err != nil {
+		return 0, err
+	}
+
+	refunded := 0
+	for _, redPacketID := range redPacketIDs {
+		err := refundRedPacket(ctx, redisClient, redlock, redPacketID)
+		if errors.Is(err, errRefundPending) {
+			log.Info("Refund postponed", "red_packet_id", redPacketID, "reason", err)
+			continue
+		}
+		if err != nil {
+			return refunded, fmt.Errorf("red packet %d: %v", redPacketID, err)
+		}
+		refunded++
+	}
+	return refunded, nil
+}
+
+// refundRedPacket stops grabs on a red packet, then refunds its remaining amount to the sender.
+// A fast mode red packet waits, with errRefundPending, until the worker persisted the claims
+// Redis holds; if the Redis state was lost, the claims logged in MySQL are all there is.
+func refundRedPacket(ctx context.Context, redisClient *redis.ClusterClient, redlock *redsync.Redsync, redPacketID uint) error {
+	log := logger.GetLogger()
+
+	// Same lock as strict mode grabs
+	mutex := redlock.NewMutex(fmt.Sprintf("lock:red_packet_%d", redPacketID))
+	if err := mutex.LockContext(ctx); err != nil {
+		return err
+	}
+	defer mutex.Unlock()
+
+	var redPacket model.RedPacket
+	if err := db.GetDB().Clauses(dbresolver.Write).WithContext(ctx).First(&redPacket, redPacketID).Error; err != nil {
+		return err
+	}
+
+	// Stop new grabs before moving money
+	if redPacket.GrabMode == model.GrabModeFast {
+		claims, err := closeFastStateScript.Run(ctx, redisClient,
+			[]string{FastStateKey(redPacketID), FastGrabbersKey(redPacketID)}).Int()
+		if err != nil {
+			return err
+		}
+		persisted := redPacket.TotalCount - redPacket.RemainingCount
+		if claims > persisted {
+			return errRefundPending
+		}
+		if claims != persisted && persisted > 0 {
+			log.Warn("Fast mode state lost claims, refunding from MySQL", "red_packet_id", redPacketID, "redis_claims", claims, "persisted", persisted)
+		}
+	} else if err := redisClient.Set(ctx, fmt.Sprintf("red_packet_%d", redPacketID), 0, redis.KeepTTL).Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&redPacket, redPacketID).Error; err != nil {
+			return err
+		}
+		if redPacket.Status != model.RedPacketStatusActive {
+			return nil // Refunded concurrently
+		}
+
+		refund := ToCents(redPacket.RemainingAmount)
+		if err := tx.Model(&redPacket).Updates(map[string]interface{}{
+			"Status":          model.RedPacketStatusExpired,
+			"RemainingAmount": 0,
+			"RemainingCount":  0,
+			"RefundedAmount":  FromCents(refund),
+			"RefundedAt":      &now,
+			"Version":         gorm.Expr("version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		if refund == 0 {
+			return nil
+		}
+
+		return PostEntry(tx, model.EntryTypePacketRefund, fmt.Sprintf("refund:%d", redPacketID),
+			RedPacketLine(redPacketID, -refund),
+			senderLine(redPacket.SenderID, refund),
+		)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Red packet expired and refunded", "red_packet_id", redPacketID, "refunded", redPacket.RefundedAmount, "sender_id", redPacket.SenderID)
+	return nil
+}
+
+// StartRefunder refunds expired red packets every interval until ctx is cancelled
+func StartRefunder(ctx context.Context, interval, ttl time.Duration) {
+	log := logger.GetLogger()
+	log.Info("Red packet refunder started", "interval", interval, "ttl", ttl)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Red packet refunder stopped")
+			return
+		case <-ticker.C:
+			refunded, err := RefundExpiredRedPackets(ctx, ttl)
+			if err != nil {
+				log.Error("Red packet refund round failed", "error", err)
+			} else if refunded > 0 {
+				log.Info("Refunded expired red packets", "refunded", refunded)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
+	"gorm.io/gorm"
+	"red-packet-system/model"
+)
+
+// createFundedFastPacket creates a fast mode red packet of 10.00 over 5 shares sent by user 9
+func createFundedFastPacket(t *testing.T, sqliteDB *gorm.DB) model.RedPacket {
+	t.Helper()
+	createUser(t, sqliteDB, 9, 0)
+	redPacket := createRedPacket(t, sqliteDB, 10, 5, model.GrabModeFast)
+	if err := sqliteDB.Model(&redPacket).Update("SenderID", 9).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := PostEntry(sqliteDB, model.EntryTypePacketFunding, "fund:1", ExternalLine(-1000), RedPacketLine(redPacket.ID, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	return redPacket
+}
+
+func TestRefundWaitsForClaimsInFlight(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	client := newTestRedis(t)
+	redlock := redsync.New(goredis.NewPool(client))
+	cache := NewRedisStockCache(client)
+
+	redPacket := createFundedFastPacket(t, sqliteDB)
+	cache.LoadFast(ctx, redPacket.ID, 5, 1000, nil)
+	cache.ClaimFast(ctx, redPacket.ID, 1) // Not yet persisted by the worker
+
+	if err := refundRedPacket(ctx, client, redlock, redPacket.ID); !errors.Is(err, errRefundPending) {
+		t.Fatalf("expected errRefundPending, got %v", err)
+	}
+	if share, _ := cache.ClaimFast(ctx, redPacket.ID, 2); share != StockEmpty {
+		t.Fatalf("ClaimFast after the refund started = %d, want %d", share, StockEmpty)
+	}
+	if balance := userBalance(t, sqliteDB, 9); balance != 0 {
+		t.Fatalf("sender refunded %d cents while a claim is in flight", balance)
+	}
+}
+
+func TestRefundAfterFastStateLost(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	client := newTestRedis(t)
+	redlock := redsync.New(goredis.NewPool(client))
+	cache := NewRedisStockCache(client)
+
+	// User 1's claim of 2.00 is persisted, then Redis loses the fast mode state
+	redPacket := createFundedFastPacket(t, sqliteDB)
+	if err := sqliteDB.Model(&redPacket).Updates(map[string]interface{}{"RemainingCount": 4, "RemainingAmount": 8}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := refundRedPacket(ctx, client, redlock, redPacket.ID); err != nil {
+		t.Fatal(err)
+	}
+	if balance := userBalance(t, sqliteDB, 9); balance != 800 {
+		t.Fatalf("sender refunded %d cents, want 800", balance)
+	}
+	var stored model.RedPacket
+	sqliteDB.First(&stored, redPacket.ID)
+	if stored.Status != model.RedPacketStatusExpired || ToCents(stored.RefundedAmount) != 800 {
+		t.Fatalf("red packet is status=%d refunded=%.2f, want expired with 8.00", stored.Status, stored.RefundedAmount)
+	}
+
+	// The closed state keeps grabs from reloading the red packet from MySQL
+	if share, _ := cache.ClaimFast(ctx, redPacket.ID, 2); share != StockEmpty {
+		t.Fatalf("ClaimFast after the refund = %d, want %d", share, StockEmpty)
+	}
+}
@@ -4,143 +4,215 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"gorm.io/gorm"
 	"math/rand"
 	"time"
 
-	"red-packet-system/db"
-	"red-packet-system/kafka"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"red-packet-system/model"
 	"red-packet-system/pkg/logger"
-	"red-packet-system/redisclient"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/pkg/tracing"
+)
 
-	"github.com/redis/go-redis/v9"
+// maxGrabAttempts bounds the optimistic concurrency retries of a strict mode grab
+const maxGrabAttempts = 3
+
+// Grab errors, returned to API clients as is
+var (
+	ErrRedPacketNotExist = errors.New("red packet does not exist")
+	ErrRedPacketEmpty    = errors.New("red packet is empty")
+	ErrAlreadyGrabbed    = errors.New("red packet already grabbed")
+	ErrSystemBusy        = errors.New("system is busy, please try again later")
+	// ErrGrabPending is returned with the amount of a fast mode claim whose event may not have
+	// reached Kafka: the share is held for the user and credited once the event is persisted
+	ErrGrabPending = errors.New("red packet grab accepted, pending confirmation")
+	errSystem      = errors.New("system error")
 )
 
-// Lua script for atomic stock decrement in Redis.
-var luaScript = redis.NewScript(`
-    local stock = redis.call("GET", KEYS[1])
-    if not stock then
-        return -2 -- No data in Redis, fallback to MySQL
-    end
-    if tonumber(stock) <= 0 then
-        return -1 -- No more red packets available
-    else
-        redis.call("DECR", KEYS[1])
-        return tonumber(stock) - 1
-    end
-`)
+// RedPacketService grabs red packets through its repository, stock cache, locks and publisher
+type RedPacketService struct {
+	packets PacketRepository
+	stock   StockCache
+	locks   LockProvider
+	events  EventPublisher
+}
+
+// NewRedPacketService returns a RedPacketService using the given dependencies
+func NewRedPacketService(packets PacketRepository, stock StockCache, locks LockProvider, events EventPublisher) *RedPacketService {
+	return &RedPacketService{packets: packets, stock: stock, locks: locks, events: events}
+}
 
 // GrabRedPacket handles red packet grabbing logic.
-func GrabRedPacket(userID uint, redPacketID uint) (float64, error) {
-	log := logger.GetLogger()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (s *RedPacketService) GrabRedPacket(ctx context.Context, userID uint, redPacketID uint) (float64, error) {
+	ctx, span := tracing.Start(ctx, "service.GrabRedPacket", trace.WithAttributes(
+		attribute.Int64("user.id", int64(userID)),
+		attribute.Int64("red_packet.id", int64(redPacketID)),
+	))
+	amount, err := s.grab(ctx, userID, redPacketID)
+
+	outcome := grabOutcome(err)
+	metrics.GrabResults.WithLabelValues(outcome).Inc()
+	span.SetAttributes(attribute.String("grab.result", outcome))
+	if outcome == "error" {
+		tracing.End(span, err)
+	} else {
+		span.End() // Empty, busy, duplicate and pending grabs are expected answers, not span errors
+	}
+	return amount, err
+}
+
+// grabOutcome labels the outcome of a grab in metrics
+func grabOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrRedPacketEmpty):
+		return "empty"
+	case errors.Is(err, ErrSystemBusy):
+		return "busy"
+	case errors.Is(err, ErrRedPacketNotExist):
+		return "not_found"
+	case errors.Is(err, ErrAlreadyGrabbed):
+		return "duplicate"
+	case errors.Is(err, ErrGrabPending):
+		return "pending"
+	}
+	return "error"
+}
+
+// grab runs one grab, see GrabRedPacket
+func (s *RedPacketService) grab(ctx context.Context, userID uint, redPacketID uint) (float64, error) {
+	log := logger.Sampled()
+
+	// A grab is not abandoned half-way when the client goes away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
 	defer cancel()
 
-	dbInstance := db.GetDB()
-	redisClient := redisclient.GetRedisClient()
-	redlock := redisclient.GetRedlock()
-	redisKey := fmt.Sprintf("red_packet_%d", redPacketID)
 	lockKey := fmt.Sprintf("lock:red_packet_%d", redPacketID)
 
 	// Check Bloom Filter before querying MySQL to prevent cache penetration
-	if !redisclient.ExistsInBloomFilter(redisClient, redPacketID) {
-		log.Println("[INFO] Red packet ID not found in Bloom Filter, rejecting request")
-		return 0, errors.New("red packet does not exist")
+	stepCtx, span := tracing.Start(ctx, "grab.check_exists")
+	exists := s.stock.MayExist(stepCtx, redPacketID)
+	span.End()
+	if !exists {
+		log.DebugContext(ctx, "Red packet not found in Bloom Filter, rejecting request", "red_packet_id", redPacketID)
+		return 0, ErrRedPacketNotExist
+	}
+
+	// Fast mode packets are claimed in Redis only, MySQL is updated by the Kafka worker
+	stepCtx, span = tracing.Start(ctx, "grab.mode")
+	mode, err := s.grabMode(stepCtx, redPacketID)
+	span.SetAttributes(attribute.String("grab.mode", mode))
+	tracing.End(span, err)
+	if err != nil {
+		return 0, err
+	}
+	if mode == model.GrabModeFast {
+		return s.grabRedPacketFast(ctx, userID, redPacketID)
 	}
 
 	// Acquire Redlock (Minimizing lock duration)
-	mutex := redlock.NewMutex(lockKey)
-	if err := mutex.Lock(); err != nil {
-		log.Println("[ERROR] Failed to acquire Redis lock:", err)
-		return 0, errors.New("system is busy, please try again later")
+	stepCtx, span = tracing.Start(ctx, "grab.lock")
+	unlock, err := s.locks.Lock(stepCtx, lockKey)
+	tracing.End(span, err)
+	if err != nil {
+		log.ErrorContext(ctx, "Failed to acquire Redis lock", "red_packet_id", redPacketID, "error", err)
+		return 0, ErrSystemBusy
 	}
-	defer mutex.Unlock()
+	defer unlock()
 
 	// Execute Lua script for atomic stock decrement in Redis
-	result, err := luaScript.Run(ctx, redisClient, []string{redisKey}).Int()
-	if err != nil && err != redis.Nil {
-		log.Println("[ERROR] Redis operation failed:", err)
-		return 0, errors.New("system error")
+	stepCtx, span = tracing.Start(ctx, "grab.decr_stock")
+	result, err := s.stock.DecrStock(stepCtx, redPacketID)
+	span.SetAttributes(attribute.Int("grab.stock", result))
+	tracing.End(span, err)
+	if err != nil {
+		log.ErrorContext(ctx, "Redis operation failed", "red_packet_id", redPacketID, "error", err)
+		return 0, errSystem
 	}
 
 	// No red packets left
-	if result == -1 {
-		log.Println("[INFO] Red packet is already empty")
-		return 0, errors.New("red packet is empty")
+	if result == StockEmpty {
+		log.DebugContext(ctx, "Red packet is already empty", "red_packet_id", redPacketID)
+		return 0, ErrRedPacketEmpty
 	}
 
 	// Redis cache miss, check MySQL
-	if result == -2 {
-		var redPacket model.RedPacket
-		if err := dbInstance.WithContext(ctx).First(&redPacket, redPacketID).Error; err != nil {
-			log.Println("[ERROR] Red packet does not exist, rolling back Redis operation")
-			redisClient.Set(ctx, redisKey, 0, 0)
-			return 0, errors.New("red packet does not exist")
+	if result == StockNotCached {
+		stepCtx, span = tracing.Start(ctx, "grab.load_stock")
+		redPacket, err := s.packets.GetRedPacket(stepCtx, redPacketID)
+		tracing.End(span, err)
+		if err != nil {
+			log.WarnContext(ctx, "Red packet does not exist, rolling back Redis operation", "red_packet_id", redPacketID, "error", err)
+			s.stock.SetStock(ctx, redPacketID, 0, 0)
+			return 0, ErrRedPacketNotExist
 		}
 
 		// 🌟 Set Redis cache with randomized TTL to prevent cache avalanche
+		// The cached stock already accounts for this grab, mirroring the Lua decrement
 		ttl := time.Duration(600+rand.Intn(60)) * time.Second
-		redisClient.Set(ctx, redisKey, redPacket.RemainingCount, ttl)
-		result = redPacket.RemainingCount
-	}
-
-	// Ensure red packet stock is available
-	if result <= 0 {
-		log.Println("[INFO] Red packet is already empty")
-		return 0, errors.New("red packet is empty")
+		result = redPacket.RemainingCount - 1
+		if result >= 0 {
+			s.stock.SetStock(ctx, redPacketID, result, ttl)
+		} else {
+			s.stock.SetStock(ctx, redPacketID, 0, ttl)
+		}
 	}
 
-	// Retrieve red packet data from MySQL
-	var redPacket model.RedPacket
-	if err := dbInstance.WithContext(ctx).First(&redPacket, redPacketID).Error; err != nil {
-		log.Println("[ERROR] Red packet does not exist, rolling back Redis")
-		redisClient.Incr(ctx, redisKey)
-		return 0, errors.New("red packet does not exist")
+	// Ensure red packet stock is available (result is the stock left after this grab)
+	if result < 0 {
+		log.DebugContext(ctx, "Red packet is already empty", "red_packet_id", redPacketID)
+		return 0, ErrRedPacketEmpty
 	}
 
-	// **Calculate amount to grab**
-	amount := redPacket.RemainingAmount / float64(redPacket.RemainingCount)
-
-	// **Use MySQL transaction to ensure consistency**
-	err = dbInstance.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&redPacket).Updates(
-			map[string]interface{}{
-				"RemainingAmount": redPacket.RemainingAmount - amount,
-				"RemainingCount":  redPacket.RemainingCount - 1,
-			},
-		).Error; err != nil {
-			log.Println("[ERROR] Red packet update failed, rolling back Redis")
-			redisClient.Incr(ctx, redisKey)
-			return errors.New("red packet update failed")
-		}
-
-		// **Log red packet transaction**
-		logEntry := model.RedPacketLog{
-			UserID:      userID,
-			RedPacketID: redPacketID,
-			Amount:      amount,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+	// Persist the grab, retrying when another writer bumped the version in between
+	stepCtx, span = tracing.Start(ctx, "grab.persist")
+	var amount float64
+	attempt := 1
+	for ; ; attempt++ {
+		amount, err = s.packets.PersistGrab(stepCtx, userID, redPacketID, evenShare)
+		if !errors.Is(err, errVersionConflict) || attempt == maxGrabAttempts {
+			break
 		}
-		if err := tx.Create(&logEntry).Error; err != nil {
-			log.Println("[ERROR] Failed to log red packet grab, rolling back Redis")
-			redisClient.Incr(ctx, redisKey)
-			return errors.New("failed to log red packet grab")
-		}
-
-		return nil
-	})
+		log.WarnContext(ctx, "Red packet modified concurrently, retrying grab", "red_packet_id", redPacketID, "attempt", attempt)
+	}
+	span.SetAttributes(attribute.Int("grab.attempts", attempt))
+	tracing.End(span, err)
 
 	if err != nil {
-		log.Println("[ERROR] Transaction failed, rolling back Redis")
-		redisClient.Incr(ctx, redisKey)
+		log.ErrorContext(ctx, "Grab transaction failed, rolling back Redis", "user_id", userID, "red_packet_id", redPacketID, "error", err)
+		s.restoreStock(ctx, redPacketID, err)
+		if errors.Is(err, errVersionConflict) {
+			return 0, ErrSystemBusy
+		}
 		return 0, err
 	}
 
-	// **Send Kafka event asynchronously**
-	go kafka.SendToKafka(int64(userID), int64(redPacketID), amount)
-
-	log.Printf("[SUCCESS] User %d grabbed %.2f from Red Packet %d\n", userID, amount, redPacketID)
+	log.InfoContext(ctx, "Red packet grabbed", "user_id", userID, "red_packet_id", redPacketID, "amount", amount, "mode", model.GrabModeStrict)
 	return amount, nil
 }
+
+// restoreStock undoes the Redis decrement of a grab that PersistGrab rejected. The share goes
+// back only when MySQL still has it: an empty or missing red packet means Redis was ahead of
+// MySQL, so the stock is cleared instead, and a duplicate grab leaves the stock as it is.
+func (s *RedPacketService) restoreStock(ctx context.Context, redPacketID uint, err error) {
+	switch {
+	case errors.Is(err, ErrRedPacketEmpty), errors.Is(err, ErrRedPacketNotExist):
+		ttl := time.Duration(600+rand.Intn(60)) * time.Second
+		s.stock.SetStock(ctx, redPacketID, 0, ttl)
+	case errors.Is(err, ErrAlreadyGrabbed):
+	default:
+		s.stock.IncrStock(ctx, redPacketID)
+	}
+}
+
+// evenShare splits the remaining amount evenly over the remaining shares in whole cents, as
+// the fast mode script does: the last share takes the rest, so the shares add up to the funding
+func evenShare(redPacket *model.RedPacket) float64 {
+	cents := ToCents(redPacket.RemainingAmount)
+	if redPacket.RemainingCount <= 1 {
+		return FromCents(cents)
+	}
+	return FromCents(cents / int64(redPacket.RemainingCount))
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+)
+
+// memoryBackends is a RedPacketService wired to the in-memory implementations
+type memoryBackends struct {
+	packets *MemoryPacketRepository
+	stock   *MemoryStockCache
+	events  *MemoryEventPublisher
+	svc     *RedPacketService
+}
+
+func newMemoryBackends() *memoryBackends {
+	b := &memoryBackends{
+		packets: NewMemoryPacketRepository(),
+		stock:   NewMemoryStockCache(),
+		events:  &MemoryEventPublisher{},
+	}
+	b.svc = NewRedPacketService(b.packets, b.stock, NewMemoryLockProvider(), b.events)
+	return b
+}
+
+// addRedPacket stores an active red packet and registers it in the existence filter
+func (b *memoryBackends) addRedPacket(id uint, amount float64, count int, mode string) {
+	b.packets.Add(model.RedPacket{
+		ID:              id,
+		TotalAmount:     amount,
+		RemainingAmount: amount,
+		TotalCount:      count,
+		RemainingCount:  count,
+		Status:          model.RedPacketStatusActive,
+		GrabMode:        mode,
+	})
+	b.stock.AddToFilter(id)
+}
+
+// grabResult is the outcome of one concurrent grab
+type grabResult struct {
+	userID uint
+	amount float64
+	err    error
+}
+
+// grabConcurrently makes users 1..users grab the red packet at the same time
+func grabConcurrently(svc *RedPacketService, redPacketID uint, users int) []grabResult {
+	results := make([]grabResult, users)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			userID := uint(i + 1)
+			amount, err := svc.GrabRedPacket(context.Background(), userID, redPacketID)
+			results[i] = grabResult{userID: userID, amount: amount, err: err}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return results
+}
+
+// successes returns the total amount and number of successful grabs
+func successes(results []grabResult) (total float64, count int) {
+	for _, result := range results {
+		if result.err == nil {
+			total += result.amount
+			count++
+		}
+	}
+	return total, count
+}
+
+func TestGrabRedPacketConcurrentNoOversell(t *testing.T) {
+	for _, mode := range []string{model.GrabModeStrict, model.GrabModeFast} {
+		t.Run(mode, func(t *testing.T) {
+			b := newMemoryBackends()
+			b.addRedPacket(1, 100, 10, mode)
+
+			results := grabConcurrently(b.svc, 1, 200)
+
+			total, count := successes(results)
+			if count != 10 {
+				t.Fatalf("got %d successful grabs, want 10", count)
+			}
+			if math.Abs(total-100) > 0.005 {
+				t.Fatalf("grabbed %.2f in total, want 100.00", total)
+			}
+			for _, result := range results {
+				if result.err != nil && !errors.Is(result.err, ErrRedPacketEmpty) {
+					t.Fatalf("user %d: unexpected error %v", result.userID, result.err)
+				}
+			}
+
+			if mode == model.GrabModeStrict {
+				if logs := b.packets.Logs(1); len(logs) != 10 {
+					t.Fatalf("got %d claim logs, want 10", len(logs))
+				}
+				if stock, _ := b.stock.Stock(1); stock != 0 {
+					t.Fatalf("cached stock is %d, want 0", stock)
+				}
+			} else {
+				if events := b.events.Events(); len(events) != 10 {
+					t.Fatalf("got %d claim events, want 10", len(events))
+				}
+				if stock, cents, claims, _ := b.stock.FastState(1); stock != 0 || cents != 0 || claims != 10 {
+					t.Fatalf("fast state is stock=%d cents=%d claims=%d, want 0/0/10", stock, cents, claims)
+				}
+			}
+		})
+	}
+}
+
+func TestGrabRedPacketUnknown(t *testing.T) {
+	b := newMemoryBackends()
+
+	if _, err := b.svc.GrabRedPacket(context.Background(), 1, 42); !errors.Is(err, ErrRedPacketNotExist) {
+		t.Fatalf("grab of an unknown red packet returned %v, want ErrRedPacketNotExist", err)
+	}
+	if _, ok := b.stock.Stock(42); ok {
+		t.Fatal("unknown red packet was cached")
+	}
+}
+
+func TestGrabRedPacketStrictRollbackOnDBFailure(t *testing.T) {
+	b := newMemoryBackends()
+	b.addRedPacket(1, 10, 2, model.GrabModeStrict)
+	b.packets.FailPersist = errors.New("connection reset")
+
+	if _, err := b.svc.GrabRedPacket(context.Background(), 1, 1); err == nil {
+		t.Fatal("grab succeeded although the database failed")
+	}
+	if stock, _ := b.stock.Stock(1); stock != 2 {
+		t.Fatalf("cached stock is %d after rollback, want 2", stock)
+	}
+
+	// Once the database is back, the share is still available
+	b.packets.FailPersist = nil
+	results := grabConcurrently(b.svc, 1, 5)
+	if _, count := successes(results); count != 2 {
+		t.Fatalf("got %d successful grabs after recovery, want 2", count)
+	}
+}
+
+func TestGrabRedPacketStrictKeepsStockMySQLTook(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantStock int
+	}{
+		{name: "empty", err: ErrRedPacketEmpty, wantStock: 0},
+		{name: "deleted", err: ErrRedPacketNotExist, wantStock: 0},
+		{name: "duplicate", err: ErrAlreadyGrabbed, wantStock: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newMemoryBackends()
+			b.addRedPacket(1, 10, 2, model.GrabModeStrict)
+			b.packets.FailPersist = tt.err
+
+			if _, err := b.svc.GrabRedPacket(context.Background(), 1, 1); !errors.Is(err, tt.err) {
+				t.Fatalf("grab returned %v, want %v", err, tt.err)
+			}
+			if stock, _ := b.stock.Stock(1); stock != tt.wantStock {
+				t.Fatalf("cached stock is %d after the rejected grab, want %d", stock, tt.wantStock)
+			}
+		})
+	}
+}
+
+func TestGrabRedPacketStrictRepeat(t *testing.T) {
+	b := newMemoryBackends()
+	b.addRedPacket(1, 10, 3, model.GrabModeStrict)
+
+	// Only fast mode packets are limited to one claim per user
+	for i := 0; i < 2; i++ {
+		if _, err := b.svc.GrabRedPacket(context.Background(), 7, 1); err != nil {
+			t.Fatalf("grab %d failed: %v", i+1, err)
+		}
+	}
+	if stock, _ := b.stock.Stock(1); stock != 1 {
+		t.Fatalf("cached stock is %d, want 1", stock)
+	}
+	if logs := b.packets.Logs(1); len(logs) != 2 {
+		t.Fatalf("got %d claim logs, want 2", len(logs))
+	}
+}
+
+func TestGrabRedPacketFastDuplicate(t *testing.T) {
+	b := newMemoryBackends()
+	b.addRedPacket(1, 10, 3, model.GrabModeFast)
+
+	if _, err := b.svc.GrabRedPacket(context.Background(), 7, 1); err != nil {
+		t.Fatalf("first grab failed: %v", err)
+	}
+	_, err := b.svc.GrabRedPacket(context.Background(), 7, 1)
+	if !errors.Is(err, ErrAlreadyGrabbed) {
+		t.Fatalf("second grab returned %v, want ErrAlreadyGrabbed", err)
+	}
+	if stock, _, claims, _ := b.stock.FastState(1); stock != 2 || claims != 1 {
+		t.Fatalf("fast state is stock=%d claims=%d, want 2/1", stock, claims)
+	}
+}
+
+func TestGrabRedPacketFastRollbackOnPublishFailure(t *testing.T) {
+	b := newMemoryBackends()
+	b.addRedPacket(1, 10, 2, model.GrabModeFast)
+	b.events.Fail = errors.New("broker unavailable")
+
+	if _, err := b.svc.GrabRedPacket(context.Background(), 7, 1); err == nil {
+		t.Fatal("grab succeeded although the event was not published")
+	}
+	if stock, cents, claims, _ := b.stock.FastState(1); stock != 2 || cents != 1000 || claims != 0 {
+		t.Fatalf("fast state is stock=%d cents=%d claims=%d, want 2/1000/0", stock, cents, claims)
+	}
+
+	// The user can retry once the broker is back
+	b.events.Fail = nil
+	if _, err := b.svc.GrabRedPacket(context.Background(), 7, 1); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+}
+
+func TestGrabRedPacketFastKeepsClaimOnUnknownDelivery(t *testing.T) {
+	b := newMemoryBackends()
+	b.addRedPacket(1, 10, 2, model.GrabModeFast)
+	b.events.Fail = kafka.ErrDeliveryUnknown
+
+	amount, err := b.svc.GrabRedPacket(context.Background(), 7, 1)
+	if !errors.Is(err, ErrGrabPending) || amount != 5 {
+		t.Fatalf("grab returned %.2f, %v, want 5.00 and ErrGrabPending", amount, err)
+	}
+	// The event may still reach the worker, so the claim stays and is marked for the reconciler
+	if stock, _, claims, _ := b.stock.FastState(1); stock != 1 || claims != 1 {
+		t.Fatalf("fast state is stock=%d claims=%d, want 1/1", stock, claims)
+	}
+	if pending := b.stock.PendingFast(1); pending[7] != 500 {
+		t.Fatalf("pending claims are %v, want user 7 with 500 cents", pending)
+	}
+}
+
+// conflictingRepository fails the first conflicts PersistGrab calls with errVersionConflict
+type conflictingRepository struct {
+	*MemoryPacketRepository
+	mu        sync.Mutex
+	conflicts int
+}
+
+func (r *conflictingRepository) PersistGrab(ctx context.Context, userID, redPacketID uint, share func(*model.RedPacket) float64) (float64, error) {
+	r.mu.Lock()
+	if r.conflicts > 0 {
+		r.conflicts--
+		r.mu.Unlock()
+		return 0, errVersionConflict
+	}
+	r.mu.Unlock()
+	return r.MemoryPacketRepository.PersistGrab(ctx, userID, redPacketID, share)
+}
+
+func TestGrabRedPacketStrictVersionConflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int
+		wantErr   bool
+	}{
+		{name: "retried", conflicts: maxGrabAttempts - 1},
+		{name: "gives up", conflicts: maxGrabAttempts, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newMemoryBackends()
+			b.addRedPacket(1, 10, 2, model.GrabModeStrict)
+			packets := &conflictingRepository{MemoryPacketRepository: b.packets, conflicts: tt.conflicts}
+			svc := NewRedPacketService(packets, b.stock, NewMemoryLockProvider(), b.events)
+
+			_, err := svc.GrabRedPacket(context.Background(), 7, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("grab returned %v, want error %t", err, tt.wantErr)
+			}
+
+			wantStock := 1
+			if tt.wantErr {
+				wantStock = 2
+			}
+			if stock, _ := b.stock.Stock(1); stock != wantStock {
+				t.Fatalf("cached stock is %d, want %d", stock, wantStock)
+			}
+		})
+	}
+}
+
+func TestGrabOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: nil, want: "success"},
+		{err: ErrRedPacketEmpty, want: "empty"},
+		{err: ErrSystemBusy, want: "busy"},
+		{err: ErrRedPacketNotExist, want: "not_found"},
+		{err: ErrAlreadyGrabbed, want: "duplicate"},
+		{err: ErrGrabPending, want: "pending"},
+		{err: errors.New("failed to record grab event"), want: "error"},
+	}
+	for _, tt := range tests {
+		if got := grabOutcome(tt.err); got != tt.want {
+			t.Errorf("grabOutcome(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"red-packet-system/pkg/metrics"
+	"red-packet-system/redisclient"
+)
+
+// Lua script for atomic stock decrement in Redis.
+var luaScript = redis.NewScript(`
+    local stock = redis.call("GET", KEYS[1])
+    if not stock then
+        return -2 -- No data in Redis, fallback to MySQL
+    end
+    if tonumber(stock) <= 0 then
+        return -1 -- No more red packets available
+    else
+        redis.call("DECR", KEYS[1])
+        return tonumber(stock) - 1
+    end
+`)
+
+// Fast mode state lives in Redis as the single source of truth for claims.
+// Both keys share the `{id}` hash tag so the Lua scripts stay on one cluster slot.
+// Amounts are stored in cents to keep Lua arithmetic exact.
+var fastGrabScript = redis.NewScript(`
+    if redis.call("EXISTS", KEYS[1]) == 0 then
+        return -2 -- State not loaded, fallback to MySQL
+    end
+    if redis.call("SISMEMBER", KEYS[2], ARGV[1]) == 1 then
+        return -3 -- User already claimed this red packet
+    end
+    local stock = tonumber(redis.call("HGET", KEYS[1], "stock"))
+    if stock <= 0 then
+        return -1 -- No more red packets available
+    end
+    local amount = tonumber(redis.call("HGET", KEYS[1], "amount"))
+    local share = amount
+    if stock > 1 then
+        share = math.floor(amount / stock)
+    end
+    redis.call("HINCRBY", KEYS[1], "stock", -1)
+    redis.call("HINCRBY", KEYS[1], "amount", -share)
+    redis.call("SADD", KEYS[2], ARGV[1])
+    return share
+`)
+
+// fastInitScript loads state only if absent, so concurrent loaders cannot reset it.
+var fastInitScript = redis.NewScript(`
+    if redis.call("EXISTS", KEYS[1]) == 1 then
+        return 0
+    end
+    redis.call("HSET", KEYS[1], "stock", ARGV[1], "amount", ARGV[2])
+    for i = 3, #ARGV do
+        redis.call("SADD", KEYS[2], ARGV[i])
+    end
+    return 1
+`)
+
+// fastRollbackScript undoes a claim whose Kafka event was rejected.
+var fastRollbackScript = redis.NewScript(`
+    if redis.call("SREM", KEYS[2], ARGV[1]) == 1 then
+        redis.call("HINCRBY", KEYS[1], "stock", 1)
+        redis.call("HINCRBY", KEYS[1], "amount", ARGV[2])
+    end
+    return 0
+`)
+
+// fastRebuildScript replaces the fast mode state with the one rebuilt from MySQL, unless a
+// claim or rollback changed it since it was compared (ARGV[1..3]: stock, amount, grabbers seen)
+var fastRebuildScript = redis.NewScript(`
+    local state = redis.call("HMGET", KEYS[1], "stock", "amount")
+    if state[1] ~= ARGV[1] or state[2] ~= ARGV[2] or redis.call("SCARD", KEYS[2]) ~= tonumber(ARGV[3]) then
+        return 0
+    end
+    redis.call("DEL", KEYS[2])
+    redis.call("HSET", KEYS[1], "stock", ARGV[4], "amount", ARGV[5])
+    for i = 6, #ARGV do
+        redis.call("SADD", KEYS[2], ARGV[i])
+    end
+    return 1
+`)
+
+// redisStockCache is the Redis Cluster StockCache
+type redisStockCache struct {
+	client *redis.ClusterClient
+}
+
+// NewRedisStockCache returns a StockCache backed by Redis Cluster
+func NewRedisStockCache(client *redis.ClusterClient) StockCache {
+	return &redisStockCache{client: client}
+}
+
+// stockKey holds the strict mode stock counter
+func stockKey(redPacketID uint) string {
+	return fmt.Sprintf("red_packet_%d", redPacketID)
+}
+
+// fastKeys returns the fast mode state and grabbers keys
+func fastKeys(redPacketID uint) []string {
+	return []string{FastStateKey(redPacketID), FastGrabbersKey(redPacketID)}
+}
+
+func (c *redisStockCache) MayExist(ctx context.Context, redPacketID uint) bool {
+	return redisclient.ExistsInBloomFilter(c.client, redPacketID)
+}
+
+func (c *redisStockCache) GrabMode(ctx context.Context, redPacketID uint) (string, bool) {
+	mode, err := c.client.Get(ctx, grabModeKey(redPacketID)).Result()
+	return mode, err == nil
+}
+
+func (c *redisStockCache) SetGrabMode(ctx context.Context, redPacketID uint, mode string, ttl time.Duration) error {
+	return c.client.Set(ctx, grabModeKey(redPacketID), mode, ttl).Err()
+}
+
+func (c *redisStockCache) DecrStock(ctx context.Context, redPacketID uint) (int, error) {
+	start := time.Now()
+	result, err := luaScript.Run(ctx, c.client, []string{stockKey(redPacketID)}).Int()
+	observeScript("decr_stock", start, err)
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+func (c *redisStockCache) SetStock(ctx context.Context, redPacketID uint, stock int, ttl time.Duration) error {
+	return c.client.Set(ctx, stockKey(redPacketID), stock, ttl).Err()
+}
+
+func (c *redisStockCache) IncrStock(ctx context.Context, redPacketID uint) error {
+	return c.client.Incr(ctx, stockKey(redPacketID)).Err()
+}
+
+func (c *redisStockCache) ClaimFast(ctx context.Context, redPacketID, userID uint) (int64, error) {
+	member := strconv.FormatUint(uint64(userID), 10)
+	start := time.Now()
+	share, err := fastGrabScript.Run(ctx, c.client, fastKeys(redPacketID), member).Int64()
+	observeScript("fast_grab", start, err)
+	return share, err
+}
+
+func (c *redisStockCache) LoadFast(ctx context.Context, redPacketID uint, stock int, cents int64, grabbers []uint) error {
+	args := []interface{}{stock, cents}
+	for _, id := range grabbers {
+		args = append(args, strconv.FormatUint(uint64(id), 10))
+	}
+	start := time.Now()
+	err := fastInitScript.Run(ctx, c.client, fastKeys(redPacketID), args...).Err()
+	observeScript("fast_init", start, err)
+	return err
+}
+
+func (c *redisStockCache) RollbackFast(ctx context.Context, redPacketID, userID uint, cents int64) error {
+	member := strconv.FormatUint(uint64(userID), 10)
+	start := time.Now()
+	err := fastRollbackScript.Run(ctx, c.client, fastKeys(redPacketID), member, cents).Err()
+	observeScript("fast_rollback", start, err)
+	return err
+}
+
+func (c *redisStockCache) MarkPendingFast(ctx context.Context, redPacketID, userID uint, cents int64) error {
+	return c.client.HSet(ctx, FastPendingKey(redPacketID), strconv.FormatUint(uint64(userID), 10), cents).Err()
+}
+
+// rebuildFastState atomically sets the fast mode state of a red packet to stock, cents and
+// grabbers if it still holds seenStock, seenCents and seenGrabbers members. It returns false
+// when the state changed in between, e.g. a concurrent claim, leaving it untouched.
+func rebuildFastState(ctx context.Context, client *redis.ClusterClient, redPacketID uint, seenStock, seenCents int64, seenGrabbers int, stock int, cents int64, grabbers []uint) (bool, error) {
+	args := []interface{}{seenStock, seenCents, seenGrabbers, stock, cents}
+	for _, id := range grabbers {
+		args = append(args, strconv.FormatUint(uint64(id), 10))
+	}
+	start := time.Now()
+	rebuilt, err := fastRebuildScript.Run(ctx, client, fastKeys(redPacketID), args...).Int()
+	observeScript("fast_rebuild", start, err)
+	return rebuilt == 1, err
+}
+
+// observeScript records the latency of a Lua script, redis.Nil is a normal reply
+func observeScript(script string, start time.Time, err error) {
+	if err == redis.Nil {
+		err = nil
+	}
+	metrics.LuaScriptDuration.WithLabelValues(script, metrics.Result(err)).Observe(metrics.Since(start))
+}
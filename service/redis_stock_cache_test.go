@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"red-packet-system/pkg/testenv"
+)
+
+// newTestRedis returns a client of an in-process Redis server, closed with the test
+func newTestRedis(t *testing.T) *redis.ClusterClient {
+	t.Helper()
+	server, client, err := testenv.StartRedis()
+	if err != nil {
+		t.Fatalf("failed to start Redis: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestRedisStockCacheDecrStock(t *testing.T) {
+	ctx := context.Background()
+	cache := NewRedisStockCache(newTestRedis(t))
+
+	if got, err := cache.DecrStock(ctx, 1); err != nil || got != StockNotCached {
+		t.Fatalf("DecrStock on a cache miss = %d, %v, want %d", got, err, StockNotCached)
+	}
+
+	cache.SetStock(ctx, 1, 2, 0)
+	for _, want := range []int{1, 0, StockEmpty, StockEmpty} {
+		if got, err := cache.DecrStock(ctx, 1); err != nil || got != want {
+			t.Fatalf("DecrStock = %d, %v, want %d", got, err, want)
+		}
+	}
+
+	cache.IncrStock(ctx, 1)
+	if got, _ := cache.DecrStock(ctx, 1); got != 0 {
+		t.Fatalf("DecrStock after IncrStock = %d, want 0", got)
+	}
+}
+
+func TestRedisStockCacheFastClaims(t *testing.T) {
+	ctx := context.Background()
+	cache := NewRedisStockCache(newTestRedis(t))
+
+	if got, err := cache.ClaimFast(ctx, 1, 10); err != nil || got != StockNotCached {
+		t.Fatalf("ClaimFast on a cache miss = %d, %v, want %d", got, err, StockNotCached)
+	}
+
+	// User 9 claimed before the state was loaded
+	if err := cache.LoadFast(ctx, 1, 3, 1000, []uint{9}); err != nil {
+		t.Fatalf("LoadFast failed: %v", err)
+	}
+	if err := cache.LoadFast(ctx, 1, 5, 5000, nil); err != nil {
+		t.Fatalf("second LoadFast failed: %v", err)
+	}
+
+	// Every share but the last is the even split, the last takes the remainder
+	steps := []struct {
+		userID uint
+		want   int64
+	}{
+		{userID: 9, want: StockClaimed},
+		{userID: 1, want: 333},
+		{userID: 1, want: StockClaimed},
+		{userID: 2, want: 333},
+		{userID: 3, want: 334},
+		{userID: 4, want: StockEmpty},
+	}
+	for _, step := range steps {
+		if got, err := cache.ClaimFast(ctx, 1, step.userID); err != nil || got != step.want {
+			t.Fatalf("ClaimFast(user %d) = %d, %v, want %d", step.userID, got, err, step.want)
+		}
+	}
+
+	// A rolled back claim can be taken again, rolling back twice is a no-op
+	cache.RollbackFast(ctx, 1, 3, 334)
+	cache.RollbackFast(ctx, 1, 3, 334)
+	if got, _ := cache.ClaimFast(ctx, 1, 4); got != 334 {
+		t.Fatalf("ClaimFast after rollback = %d, want 334", got)
+	}
+	if got, _ := cache.ClaimFast(ctx, 1, 5); got != StockEmpty {
+		t.Fatalf("ClaimFast after the last share = %d, want %d", got, StockEmpty)
+	}
+}
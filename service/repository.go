@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"red-packet-system/model"
+)
+
+// The grab path depends on these interfaces instead of the MySQL, Redis and Kafka singletons,
+// so it can run against the in-memory implementations in memory.go.
+
+// errVersionConflict means the red packet row changed between read and update
+var errVersionConflict = errors.New("red packet was modified concurrently")
+
+// Stock cache results below zero
+const (
+	StockEmpty     = -1 // No share left
+	StockNotCached = -2 // State not loaded, fall back to the PacketRepository
+	StockClaimed   = -3 // Fast mode: the user already claimed the red packet
+)
+
+// PacketRepository reads red packets and persists strict mode grabs
+type PacketRepository interface {
+	// GetRedPacket reads a red packet from the primary store, ErrRedPacketNotFound if missing
+	GetRedPacket(ctx context.Context, redPacketID uint) (*model.RedPacket, error)
+	// ListGrabbers returns the users who claimed a red packet
+	ListGrabbers(ctx context.Context, redPacketID uint) ([]uint, error)
+	// PersistGrab atomically takes one share of the red packet, computed by share from the
+	// current row, records the claim and its event, and returns the amount. It returns
+	// errVersionConflict when the row changed after it was read.
+	PersistGrab(ctx context.Context, userID, redPacketID uint, share func(*model.RedPacket) float64) (float64, error)
+}
+
+// StockCache holds the hot grab state (stock counters and fast mode claims)
+type StockCache interface {
+	// MayExist reports whether the red packet may exist (false means it certainly does not)
+	MayExist(ctx context.Context, redPacketID uint) bool
+	// GrabMode returns the cached grab mode, ok is false on a cache miss
+	GrabMode(ctx context.Context, redPacketID uint) (mode string, ok bool)
+	SetGrabMode(ctx context.Context, redPacketID uint, mode string, ttl time.Duration) error
+
+	// DecrStock takes one strict mode share and returns the stock left, StockEmpty or StockNotCached
+	DecrStock(ctx context.Context, redPacketID uint) (int, error)
+	SetStock(ctx context.Context, redPacketID uint, stock int, ttl time.Duration) error
+	IncrStock(ctx context.Context, redPacketID uint) error
+
+	// ClaimFast takes one fast mode share for userID and returns it in cents,
+	// or StockEmpty, StockNotCached or StockClaimed
+	ClaimFast(ctx context.Context, redPacketID, userID uint) (int64, error)
+	// LoadFast sets the fast mode state unless it is already loaded
+	LoadFast(ctx context.Context, redPacketID uint, stock int, cents int64, grabbers []uint) error
+	// RollbackFast undoes a fast mode claim
+	RollbackFast(ctx context.Context, redPacketID, userID uint, cents int64) error
+	// MarkPendingFast records a fast mode claim whose event delivery is unknown
+	MarkPendingFast(ctx context.Context, redPacketID, userID uint, cents int64) error
+}
+
+// LockProvider hands out distributed locks
+type LockProvider interface {
+	// Lock blocks until key is locked and returns the function releasing it
+	Lock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// SessionPinner routes a user's reads to the master after their writes
+type SessionPinner interface {
+	// PinSession is called once a grab of userID is committed
+	PinSession(ctx context.Context, userID uint)
+}
+
+// EventPublisher publishes fast mode claims to the worker
+type EventPublisher interface {
+	// PublishClaimed returns once the event is durably accepted. kafka.ErrDeliveryUnknown
+	// means the event may still be delivered.
+	PublishClaimed(ctx context.Context, userID, redPacketID uint, amount float64) error
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"red-packet-system/db"
+	"red-packet-system/model"
+)
+
+// Transaction types shown in the user history
+const (
+	TransactionTypeReceived = "received" // Grabbed from a red packet (red_packet_logs shards)
+	TransactionTypeSent     = "sent"     // Red packet funded by the user (red_packets.sender_id)
+	TransactionTypeRefund   = "refund"   // Remaining amount refunded on expiry (red_packets.refunded_at)
+)
+
+const (
+	defaultTransactionLimit = 20
+	maxTransactionLimit     = 100
+)
+
+// transactionRanks orders transactions sharing a timestamp, so the cursor is a total order
+var transactionRanks = map[string]int{
+	TransactionTypeReceived: 0,
+	TransactionTypeSent:     1,
+	TransactionTypeRefund:   2,
+}
+
+var (
+	ErrInvalidCursor          = errors.New("invalid cursor")
+	ErrInvalidTransactionType = errors.New("invalid transaction type")
+)
+
+// Transaction is one entry of a user history
+type Transaction struct {
+	ID          uint      `json:"id"` // Row ID within its source table
+	Type        string    `json:"type"`
+	RedPacketID uint      `json:"red_packet_id"`
+	Amount      float64   `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TransactionQuery filters a user history. Zero From/To leave the range open.
+type TransactionQuery struct {
+	UserID uint
+	Types  []string // Defaults to all types
+	From   time.Time
+	To     time.Time
+	Cursor string
+	Limit  int
+}
+
+// TransactionPage is a page of history, newest first
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
+
+// transactionCursor is the position of the last returned transaction
+type transactionCursor struct {
+	At   time.Time
+	Rank int
+	ID   uint
+}
+
+// encode serializes the cursor as an opaque URL-safe string
+func (c transactionCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d:%d", c.At.UnixNano(), c.Rank, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeTransactionCursor parses a cursor produced by encode
+func decodeTransactionCursor(value string) (*transactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var nanos int64
+	var cursor transactionCursor
+	if _, err := fmt.Sscanf(string(raw), "%d:%d:%d", &nanos, &cursor.Rank, &cursor.ID); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor.At = time.Unix(0, nanos)
+	return &cursor, nil
+}
+
+// ListTransactions returns the red packets a user received, sent and got refunded,
+// newest first, with keyset pagination. Queries run on the read replica.
+func ListTransactions(ctx context.Context, query TransactionQuery) (*TransactionPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultTransactionLimit
+	}
+	if limit > maxTransactionLimit {
+		limit = maxTransactionLimit
+	}
+
+	types := query.Types
+	if len(types) == 0 {
+		types = []string{TransactionTypeReceived, TransactionTypeSent, TransactionTypeRefund}
+	}
+
+	var cursor *transactionCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeTransactionCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	dbInstance := db.GetDB().Clauses(dbresolver.Read).WithContext(ctx)
+
+	// Fetch one page from every source, then merge
+	var transactions []Transaction
+	for _, transactionType := range types {
+		rank, ok := transactionRanks[transactionType]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrInvalidTransactionType, transactionType)
+		}
+
+		found, err := listTransactionsOfType(dbInstance, transactionType, rank, query, cursor, limit+1)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, found...)
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		a, b := transactions[i], transactions[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		if transactionRanks[a.Type] != transactionRanks[b.Type] {
+			return transactionRanks[a.Type] > transactionRanks[b.Type]
+		}
+		return a.ID > b.ID
+	})
+
+	page := &TransactionPage{Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+		last := page.Transactions[limit-1]
+		page.NextCursor = transactionCursor{At: last.CreatedAt, Rank: transactionRanks[last.Type], ID: last.ID}.encode()
+	}
+	if page.Transactions == nil {
+		page.Transactions = []Transaction{}
+	}
+	return page, nil
+}
+
+// listTransactionsOfType reads the hot and archive tables of one source using their (user, time) index
+func listTransactionsOfType(dbInstance *gorm.DB, transactionType string, rank int, query TransactionQuery, cursor *transactionCursor, limit int) ([]Transaction, error) {
+	var columns, condition, timeColumn string
+	switch transactionType {
+	case TransactionTypeReceived:
+		return listReceivedTransactions(dbInstance, query, cursor, rank, limit)
+	case TransactionTypeSent:
+		timeColumn = "created_at"
+		columns = "id, id AS red_packet_id, total_amount AS amount, created_at"
+		condition = "sender_id = ?"
+	case TransactionTypeRefund:
+		timeColumn = "refunded_at"
+		columns = "id, id AS red_packet_id, refunded_amount AS amount, refunded_at AS created_at"
+		condition = "sender_id = ? AND refunded_at IS NOT NULL AND refunded_amount > 0"
+	}
+
+	var transactions []Transaction
+	for _, source := range []interface{}{&model.RedPacket{}, &model.ArchivedRedPacket{}} {
+		scope := filterTransactions(dbInstance.Model(source).Select(columns).Where(condition, query.UserID),
+			timeColumn, rank, query, cursor)
+
+		var found []Transaction
+		if err := scope.Order(timeColumn + " DESC, id DESC").Limit(limit).Scan(&found).Error; err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, found...)
+	}
+	for i := range transactions {
+		transactions[i].Type = transactionType
+	}
+	return transactions, nil
+}
+
+// listReceivedTransactions reads every claim log shard, since logs are sharded by red packet
+// and not by user, plus the archived logs, and merges the per-table pages
+func listReceivedTransactions(dbInstance *gorm.DB, query TransactionQuery, cursor *transactionCursor, rank, limit int) ([]Transaction, error) {
+	var (
+		mu           sync.Mutex
+		transactions []Transaction
+	)
+	listTable := func(table *gorm.DB) error {
+		scope := filterTransactions(table.Select("id, red_packet_id, amount, created_at").Where("user_id = ?", query.UserID),
+			"created_at", rank, query, cursor)
+
+		var found []Transaction
+		if err := scope.Order("created_at DESC, id DESC").Limit(limit).Scan(&found).Error; err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range found {
+			found[i].Type = TransactionTypeReceived
+		}
+		transactions = append(transactions, found...)
+		return nil
+	}
+
+	if err := db.FanOutLogs(dbInstance, listTable); err != nil {
+		return nil, err
+	}
+	if err := listTable(dbInstance.Model(&model.ArchivedRedPacketLog{})); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// filterTransactions applies the time range and the keyset condition on (time, rank, id) descending
+func filterTransactions(scope *gorm.DB, timeColumn string, rank int, query TransactionQuery, cursor *transactionCursor) *gorm.DB {
+	if !query.From.IsZero() {
+		scope = scope.Where(timeColumn+" >= ?", query.From)
+	}
+	if !query.To.IsZero() {
+		scope = scope.Where(timeColumn+" < ?", query.To)
+	}
+
+	if cursor != nil {
+		switch {
+		case rank < cursor.Rank:
+			scope = scope.Where(timeColumn+" <= ?", cursor.At)
+		case rank == cursor.Rank:
+			scope = scope.Where("("+timeColumn+" < ? OR ("+timeColumn+" = ? AND id < ?))", cursor.At, cursor.At, cursor.ID)
+		default:
+			scope = scope.Where(timeColumn+" < ?", cursor.At)
+		}
+	}
+
+	return scope
+}
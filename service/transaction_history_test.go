@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"red-packet-system/db"
+	"red-packet-system/model"
+)
+
+// seedHistory gives user 7 claims spread over shards and the archive, sent red packets and
+// a refund, several of them sharing a timestamp, and returns the history newest first
+func seedHistory(t *testing.T, tx *gorm.DB, base time.Time) []Transaction {
+	t.Helper()
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	sent := func(amount float64, created time.Time) model.RedPacket {
+		redPacket := createRedPacket(t, tx, amount, 2, model.GrabModeStrict)
+		if err := tx.Model(&redPacket).Updates(map[string]interface{}{"SenderID": 7, "CreatedAt": created}).Error; err != nil {
+			t.Fatal(err)
+		}
+		redPacket.CreatedAt = created
+		return redPacket
+	}
+	claim := func(userID uint, redPacketID uint, amount float64, created time.Time) model.RedPacketLog {
+		// Shards allocate IDs from their own range, as in migration 000011
+		logEntry := model.RedPacketLog{
+			ID:          uint(redPacketID%db.LogShardCount)*1_000_000 + redPacketID*10 + userID,
+			UserID:      userID,
+			RedPacketID: redPacketID,
+			Amount:      amount,
+			CreatedAt:   created,
+		}
+		if err := db.Logs(tx, redPacketID).Create(&logEntry).Error; err != nil {
+			t.Fatal(err)
+		}
+		return logEntry
+	}
+	received := func(logEntry model.RedPacketLog) Transaction {
+		return Transaction{ID: logEntry.ID, Type: TransactionTypeReceived, RedPacketID: logEntry.RedPacketID, Amount: logEntry.Amount, CreatedAt: logEntry.CreatedAt}
+	}
+
+	first := sent(10, at(1))
+	second := sent(20, at(3))
+	refunded := at(5)
+	if err := tx.Model(&second).Updates(map[string]interface{}{"RefundedAt": refunded, "RefundedAmount": 5}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Claims on red packets of other senders, in different shards
+	others := make([]model.RedPacket, 4)
+	for i := range others {
+		others[i] = createRedPacket(t, tx, 4, 4, model.GrabModeStrict)
+	}
+	claims := []model.RedPacketLog{
+		claim(7, others[0].ID, 1, at(2)),
+		claim(7, others[1].ID, 2, at(3)), // Same time as the second sent red packet
+		claim(7, others[2].ID, 3, at(3)), // Same time, other shard
+		claim(7, others[3].ID, 4, at(6)),
+	}
+	claim(8, others[0].ID, 1, at(4)) // Another user
+
+	// Archived claim and archived sent red packet
+	archivedLog := model.ArchivedRedPacketLog{RedPacketLog: model.RedPacketLog{ID: 900, UserID: 7, RedPacketID: 900, Amount: 6, CreatedAt: at(0)}}
+	if err := tx.Create(&archivedLog).Error; err != nil {
+		t.Fatal(err)
+	}
+	archivedPacket := model.ArchivedRedPacket{RedPacket: model.RedPacket{ID: 901, SenderID: 7, TotalAmount: 8, TotalCount: 1, CreatedAt: at(0)}, ArchivedAt: at(10)}
+	if err := tx.Create(&archivedPacket).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return []Transaction{
+		received(claims[3]),
+		{ID: second.ID, Type: TransactionTypeRefund, RedPacketID: second.ID, Amount: 5, CreatedAt: refunded},
+		{ID: second.ID, Type: TransactionTypeSent, RedPacketID: second.ID, Amount: 20, CreatedAt: at(3)},
+		received(claims[2]),
+		received(claims[1]),
+		received(claims[0]),
+		{ID: first.ID, Type: TransactionTypeSent, RedPacketID: first.ID, Amount: 10, CreatedAt: at(1)},
+		{ID: 901, Type: TransactionTypeSent, RedPacketID: 901, Amount: 8, CreatedAt: at(0)},
+		received(archivedLog.RedPacketLog),
+	}
+}
+
+func TestListTransactionsPaginatesMergedHistory(t *testing.T) {
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	want := seedHistory(t, sqliteDB, base)
+
+	// Small pages cut through ties of time and rank, every transaction comes back once in order
+	for _, limit := range []int{1, 2, 3, 4, len(want), len(want) + 1} {
+		var got []Transaction
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("limit %d: pagination does not end", limit)
+			}
+			page, err := ListTransactions(context.Background(), TransactionQuery{UserID: 7, Cursor: cursor, Limit: limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Transactions) > limit {
+				t.Fatalf("limit %d: got a page of %d", limit, len(page.Transactions))
+			}
+			got = append(got, page.Transactions...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("limit %d: got %d transactions, want %d", limit, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].ID != want[i].ID || got[i].Type != want[i].Type || got[i].RedPacketID != want[i].RedPacketID ||
+				got[i].Amount != want[i].Amount || !got[i].CreatedAt.Equal(want[i].CreatedAt) {
+				t.Fatalf("limit %d: transaction %d is %+v, want %+v", limit, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestListTransactionsFilters(t *testing.T) {
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	all := seedHistory(t, sqliteDB, base)
+	from, to := base.Add(2*time.Minute), base.Add(5*time.Minute)
+
+	tests := []struct {
+		name  string
+		query TransactionQuery
+		keep  func(Transaction) bool
+	}{
+		{
+			name:  "type",
+			query: TransactionQuery{UserID: 7, Types: []string{TransactionTypeSent, TransactionTypeRefund}},
+			keep:  func(tr Transaction) bool { return tr.Type != TransactionTypeReceived },
+		},
+		{
+			name:  "range",
+			query: TransactionQuery{UserID: 7, From: from, To: to},
+			keep:  func(tr Transaction) bool { return !tr.CreatedAt.Before(from) && tr.CreatedAt.Before(to) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := ListTransactions(context.Background(), tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want []string
+			for _, transaction := range page.Transactions {
+				got = append(got, fmt.Sprintf("%s %d", transaction.Type, transaction.ID))
+			}
+			for _, transaction := range all {
+				if tt.keep(transaction) {
+					want = append(want, fmt.Sprintf("%s %d", transaction.Type, transaction.ID))
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got transactions %v, want %v", got, want)
+			}
+		})
+	}
+
+	// Claims of other users stay out of the history
+	page, err := ListTransactions(context.Background(), TransactionQuery{UserID: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].Type != TransactionTypeReceived {
+		t.Fatalf("user 8 has transactions %+v, want their single claim", page.Transactions)
+	}
+}
+
+func TestListTransactionsRejectsBadInput(t *testing.T) {
+	useTestDB(t, newTestDB(t))
+
+	if _, err := ListTransactions(context.Background(), TransactionQuery{UserID: 7, Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("bad cursor returned %v, want ErrInvalidCursor", err)
+	}
+	if _, err := ListTransactions(context.Background(), TransactionQuery{UserID: 7, Types: []string{"bonus"}}); !errors.Is(err, ErrInvalidTransactionType) {
+		t.Fatalf("bad type returned %v, want ErrInvalidTransactionType", err)
+	}
+}
+
+func TestTransactionCursorRoundTrip(t *testing.T) {
+	cursor := transactionCursor{At: time.Unix(0, 1735783200123456789), Rank: 2, ID: 42}
+	decoded, err := decodeTransactionCursor(cursor.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.At.Equal(cursor.At) || decoded.Rank != cursor.Rank || decoded.ID != cursor.ID {
+		t.Fatalf("decoded %+v, want %+v", *decoded, cursor)
+	}
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+	"red-packet-system/payment"
+	"red-packet-system/pkg/logger"
+)
+
+var (
+	ErrUnknownProvider        = errors.New("unknown payment provider")
+	ErrWalletTxNotFound       = errors.New("wallet transaction not found")
+	ErrWalletTxAlreadyHandled = errors.New("wallet transaction already settled with a different status")
+)
+
+// paymentProviders holds the providers registered at startup
+var (
+	paymentProviders   = map[string]payment.Provider{}
+	paymentProvidersMu sync.RWMutex
+)
+
+// RegisterPaymentProvider makes a provider available to deposits, withdrawals and callbacks
+func RegisterPaymentProvider(provider payment.Provider) {
+	paymentProvidersMu.Lock()
+	defer paymentProvidersMu.Unlock()
+	paymentProviders[provider.Name()] = provider
+}
+
+// GetPaymentProvider returns a registered provider by name
+func GetPaymentProvider(name string) (payment.Provider, error) {
+	paymentProvidersMu.RLock()
+	defer paymentProvidersMu.RUnlock()
+
+	provider, ok := paymentProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+// newWalletReference generates the idempotency key of a wallet transaction
+func newWalletReference(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + "_" + hex.EncodeToString(b)
+}
+
+// payoutLine moves cents into (positive) or out of (negative) the payout hold of a user
+func payoutLine(userID uint, cents int64) LedgerLine {
+	return LedgerLine{AccountType: model.AccountTypePayout, OwnerID: userID, Amount: cents}
+}
+
+// Deposit starts a top-up: the wallet transaction stays pending and the user is only
+// credited when the provider callback reports it settled. It fails right away only if
+// the provider declined the charge.
+func Deposit(ctx context.Context, userID uint, amount float64, providerName string) (*model.WalletTransaction, error) {
+	log := logger.GetLogger()
+
+	provider, err := GetPaymentProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	cents := ToCents(amount)
+	if cents <= 0 {
+		return nil, errors.New("amount must be at least 0.01")
+	}
+
+	var user model.User
+	if err := db.GetDB().WithContext(ctx).Select("id").First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("user %d not found", userID)
+	}
+
+	walletTx := model.WalletTransaction{
+		UserID:    userID,
+		Type:      model.WalletTransactionDeposit,
+		Amount:    FromCents(cents),
+		Status:    model.WalletStatusPending,
+		Provider:  provider.Name(),
+		Reference: newWalletReference("dep"),
+	}
+	if err := db.GetDB().WithContext(ctx).Create(&walletTx).Error; err != nil {
+		return nil, err
+	}
+
+	providerReference, err := provider.Charge(ctx, payment.Request{
+		Reference: walletTx.Reference,
+		UserID:    userID,
+		Amount:    walletTx.Amount,
+		Currency:  kafka.DefaultCurrency,
+	})
+	if errors.Is(err, payment.ErrDeclined) {
+		log.ErrorContext(ctx, "Deposit rejected by the provider", "reference", walletTx.Reference, "provider", provider.Name(), "error", err)
+		return failWalletTransaction(ctx, &walletTx, err.Error())
+	}
+	if err != nil {
+		// The charge may have started, only the callback can tell
+		log.WarnContext(ctx, "Deposit outcome unknown, waiting for the callback", "reference", walletTx.Reference, "provider", provider.Name(), "error", err)
+	} else if err := db.GetDB().WithContext(ctx).Model(&walletTx).
+		Update("ProviderReference", providerReference).Error; err != nil {
+		return nil, err
+	}
+
+	log.InfoContext(ctx, "Deposit started", "reference", walletTx.Reference, "amount", walletTx.Amount, "user_id", userID, "provider", provider.Name())
+	db.PinSession(ctx, walletTx.UserID)
+	return &walletTx, nil
+}
+
+// Withdraw starts a cash-out: the amount moves from the wallet to the payout hold right
+// away, then leaves the system when the provider settles or returns to the wallet on failure.
+// A payout error other than payment.ErrDeclined keeps the amount on hold until the callback.
+func Withdraw(ctx context.Context, userID uint, amount float64, providerName string) (*model.WalletTransaction, error) {
+	log := logger.GetLogger()
+
+	provider, err := GetPaymentProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	cents := ToCents(amount)
+	if cents <= 0 {
+		return nil, errors.New("amount must be at least 0.01")
+	}
+
+	walletTx := model.WalletTransaction{
+		UserID:    userID,
+		Type:      model.WalletTransactionWithdrawal,
+		Amount:    FromCents(cents),
+		Status:    model.WalletStatusPending,
+		Provider:  provider.Name(),
+		Reference: newWalletReference("wd"),
+	}
+	err = db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&walletTx).Error; err != nil {
+			return err
+		}
+		return PostEntry(tx, model.EntryTypeWithdrawalHold, walletTx.Reference+":hold",
+			UserLine(userID, -cents),
+			payoutLine(userID, cents),
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	providerReference, err := provider.Payout(ctx, payment.Request{
+		Reference: walletTx.Reference,
+		UserID:    userID,
+		Amount:    walletTx.Amount,
+		Currency:  kafka.DefaultCurrency,
+	})
+	if errors.Is(err, payment.ErrDeclined) {
+		log.ErrorContext(ctx, "Withdrawal rejected by the provider", "reference", walletTx.Reference, "provider", provider.Name(), "error", err)
+		return failWalletTransaction(ctx, &walletTx, err.Error())
+	}
+	if err != nil {
+		// The payout may have been sent: keep the amount on hold until the callback tells
+		log.WarnContext(ctx, "Withdrawal outcome unknown, waiting for the callback", "reference", walletTx.Reference, "provider", provider.Name(), "error", err)
+	} else if err := db.GetDB().WithContext(ctx).Model(&walletTx).
+		Update("ProviderReference", providerReference).Error; err != nil {
+		return nil, err
+	}
+
+	log.InfoContext(ctx, "Withdrawal started", "reference", walletTx.Reference, "amount", walletTx.Amount, "user_id", userID, "provider", provider.Name())
+	db.PinSession(ctx, walletTx.UserID)
+	return &walletTx, nil
+}
+
+// failWalletTransaction marks a transaction failed when the provider declined to start it
+func failWalletTransaction(ctx context.Context, walletTx *model.WalletTransaction, reason string) (*model.WalletTransaction, error) {
+	callback := &payment.Callback{Reference: walletTx.Reference, Status: payment.StatusFailed, Reason: reason}
+	return HandlePaymentCallback(ctx, walletTx.Provider, callback)
+}
+
+// HandlePaymentCallback applies the final state reported by a provider. Callbacks are
+// idempotent: repeating the current final state is a no-op.
+func HandlePaymentCallback(ctx context.Context, providerName string, callback *payment.Callback) (*model.WalletTransaction, error) {
+	log := logger.GetLogger()
+
+	status := model.WalletStatusSettled
+	switch callback.Status {
+	case payment.StatusSettled:
+	case payment.StatusFailed:
+		status = model.WalletStatusFailed
+	default:
+		return nil, fmt.Errorf("%w: status %q", payment.ErrInvalidCallback, callback.Status)
+	}
+
+	var walletTx model.WalletTransaction
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reference = ? AND provider = ?", callback.Reference, providerName).
+			First(&walletTx).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWalletTxNotFound
+			}
+			return err
+		}
+
+		if walletTx.Status != model.WalletStatusPending {
+			if walletTx.Status == status {
+				return nil // Duplicate callback
+			}
+			return ErrWalletTxAlreadyHandled
+		}
+
+		updates := map[string]interface{}{"Status": status, "FailureReason": callback.Reason}
+		if callback.ProviderReference != "" {
+			updates["ProviderReference"] = callback.ProviderReference
+		}
+		if err := tx.Model(&walletTx).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		return postWalletSettlement(tx, &walletTx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.InfoContext(ctx, "Wallet transaction settled", "reference", walletTx.Reference, "type", walletTx.Type, "amount", walletTx.Amount, "user_id", walletTx.UserID, "status", walletTx.Status)
+	db.PinSession(ctx, walletTx.UserID)
+	return &walletTx, nil
+}
+
+// postWalletSettlement records the ledger entry of a final wallet transaction state
+func postWalletSettlement(tx *gorm.DB, walletTx *model.WalletTransaction) error {
+	cents := ToCents(walletTx.Amount)
+	reference := walletTx.Reference + ":" + walletTx.Status
+
+	switch {
+	case walletTx.Type == model.WalletTransactionDeposit && walletTx.Status == model.WalletStatusSettled:
+		return PostEntry(tx, model.EntryTypeDeposit, reference,
+			ExternalLine(-cents),
+			UserLine(walletTx.UserID, cents),
+		)
+	case walletTx.Type == model.WalletTransactionWithdrawal && walletTx.Status == model.WalletStatusSettled:
+		return PostEntry(tx, model.EntryTypeWithdrawal, reference,
+			payoutLine(walletTx.UserID, -cents),
+			ExternalLine(cents),
+		)
+	case walletTx.Type == model.WalletTransactionWithdrawal && walletTx.Status == model.WalletStatusFailed:
+		return PostEntry(tx, model.EntryTypeWithdrawalVoid, reference,
+			payoutLine(walletTx.UserID, -cents),
+			UserLine(walletTx.UserID, cents),
+		)
+	}
+	return nil // Failed deposits never moved money
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+	"red-packet-system/model"
+	"red-packet-system/payment"
+)
+
+// useFakeProvider registers a fake provider delivering its callbacks to HandlePaymentCallback
+func useFakeProvider(t *testing.T) *payment.FakeProvider {
+	t.Helper()
+	provider := payment.NewFakeProvider("test-secret")
+	provider.Notify = func(ctx context.Context, callback *payment.Callback) error {
+		_, err := HandlePaymentCallback(ctx, payment.FakeProviderName, callback)
+		return err
+	}
+	RegisterPaymentProvider(provider)
+	return provider
+}
+
+// completeOnly finishes the single operation pending at the provider
+func completeOnly(t *testing.T, provider *payment.FakeProvider, status string) {
+	t.Helper()
+	pending := provider.Pending()
+	if len(pending) != 1 {
+		t.Fatalf("expected one pending operation, got %d", len(pending))
+	}
+	for providerReference := range pending {
+		if err := provider.Complete(context.Background(), providerReference, status, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// walletStatus returns the stored status of a wallet transaction
+func walletStatus(t *testing.T, tx *gorm.DB, reference string) string {
+	t.Helper()
+	var walletTx model.WalletTransaction
+	if err := tx.Where("reference = ?", reference).First(&walletTx).Error; err != nil {
+		t.Fatal(err)
+	}
+	return walletTx.Status
+}
+
+func TestDepositSettlesOnCallback(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	provider := useFakeProvider(t)
+	createUser(t, sqliteDB, 1, 0)
+
+	walletTx, err := Deposit(ctx, 1, 5, payment.FakeProviderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walletTx.Status != model.WalletStatusPending || userBalance(t, sqliteDB, 1) != 0 {
+		t.Fatalf("deposit credited before the callback: status=%s", walletTx.Status)
+	}
+
+	completeOnly(t, provider, payment.StatusSettled)
+	if balance := userBalance(t, sqliteDB, 1); balance != 500 {
+		t.Fatalf("expected a balance of 500 after settlement, got %d", balance)
+	}
+	if balance := ledgerBalance(t, sqliteDB, model.AccountTypeExternal, 0); balance != -500 {
+		t.Fatalf("expected an external balance of -500, got %d", balance)
+	}
+
+	// Repeating the callback is a no-op, contradicting it is refused
+	settled := &payment.Callback{Reference: walletTx.Reference, Status: payment.StatusSettled}
+	if _, err := HandlePaymentCallback(ctx, payment.FakeProviderName, settled); err != nil {
+		t.Fatalf("duplicate callback: %v", err)
+	}
+	failed := &payment.Callback{Reference: walletTx.Reference, Status: payment.StatusFailed}
+	if _, err := HandlePaymentCallback(ctx, payment.FakeProviderName, failed); !errors.Is(err, ErrWalletTxAlreadyHandled) {
+		t.Fatalf("expected ErrWalletTxAlreadyHandled, got %v", err)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 500 {
+		t.Fatalf("repeated callbacks changed the balance to %d", balance)
+	}
+}
+
+func TestWithdrawalHoldSettleAndVoid(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	provider := useFakeProvider(t)
+	createUser(t, sqliteDB, 1, 0)
+	if err := PostEntry(sqliteDB, model.EntryTypeDeposit, "dep:1", ExternalLine(-1000), UserLine(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The hold leaves the wallet right away, a failed payout voids it
+	if _, err := Withdraw(ctx, 1, 4, payment.FakeProviderName); err != nil {
+		t.Fatal(err)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 600 {
+		t.Fatalf("expected a balance of 600 while on hold, got %d", balance)
+	}
+	if hold := ledgerBalance(t, sqliteDB, model.AccountTypePayout, 1); hold != 400 {
+		t.Fatalf("expected a payout hold of 400, got %d", hold)
+	}
+	completeOnly(t, provider, payment.StatusFailed)
+	if balance := userBalance(t, sqliteDB, 1); balance != 1000 {
+		t.Fatalf("expected the voided hold back in the wallet, got %d", balance)
+	}
+
+	// A settled payout leaves the system
+	if _, err := Withdraw(ctx, 1, 3, payment.FakeProviderName); err != nil {
+		t.Fatal(err)
+	}
+	completeOnly(t, provider, payment.StatusSettled)
+	if balance := userBalance(t, sqliteDB, 1); balance != 700 {
+		t.Fatalf("expected a balance of 700, got %d", balance)
+	}
+	if hold := ledgerBalance(t, sqliteDB, model.AccountTypePayout, 1); hold != 0 {
+		t.Fatalf("expected an empty payout hold, got %d", hold)
+	}
+	if balance := ledgerBalance(t, sqliteDB, model.AccountTypeExternal, 0); balance != -700 {
+		t.Fatalf("expected an external balance of -700, got %d", balance)
+	}
+
+	// Overdrawing fails before anything is recorded
+	if _, err := Withdraw(ctx, 1, 8, payment.FakeProviderName); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance, got %v", err)
+	}
+	var withdrawals int64
+	sqliteDB.Model(&model.WalletTransaction{}).Where("type = ?", model.WalletTransactionWithdrawal).Count(&withdrawals)
+	if withdrawals != 2 {
+		t.Fatalf("expected 2 withdrawals recorded, got %d", withdrawals)
+	}
+}
+
+func TestWithdrawProviderErrors(t *testing.T) {
+	ctx := context.Background()
+	sqliteDB := newTestDB(t)
+	useTestDB(t, sqliteDB)
+	provider := useFakeProvider(t)
+	createUser(t, sqliteDB, 1, 0)
+	if err := PostEntry(sqliteDB, model.EntryTypeDeposit, "dep:1", ExternalLine(-1000), UserLine(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A timeout may hide a sent payout: the amount stays on hold for the callback
+	provider.FailNext(errors.New("read timeout"))
+	walletTx, err := Withdraw(ctx, 1, 4, payment.FakeProviderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := walletStatus(t, sqliteDB, walletTx.Reference); status != model.WalletStatusPending {
+		t.Fatalf("ambiguous payout error left the withdrawal %s, want pending", status)
+	}
+	if hold := ledgerBalance(t, sqliteDB, model.AccountTypePayout, 1); hold != 400 {
+		t.Fatalf("expected a payout hold of 400, got %d", hold)
+	}
+	callback := &payment.Callback{Reference: walletTx.Reference, ProviderReference: "po_late", Status: payment.StatusSettled}
+	if _, err := HandlePaymentCallback(ctx, payment.FakeProviderName, callback); err != nil {
+		t.Fatal(err)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 600 {
+		t.Fatalf("expected a balance of 600 after the late settlement, got %d", balance)
+	}
+
+	// A refusal fails the withdrawal and voids the hold right away
+	provider.FailNext(fmt.Errorf("%w: daily limit", payment.ErrDeclined))
+	walletTx, err = Withdraw(ctx, 1, 2, payment.FakeProviderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walletTx.Status != model.WalletStatusFailed {
+		t.Fatalf("declined withdrawal is %s, want failed", walletTx.Status)
+	}
+	if balance := userBalance(t, sqliteDB, 1); balance != 600 {
+		t.Fatalf("expected the declined amount back in the wallet, got %d", balance)
+	}
+	if hold := ledgerBalance(t, sqliteDB, model.AccountTypePayout, 1); hold != 0 {
+		t.Fatalf("expected an empty payout hold, got %d", hold)
+	}
+}
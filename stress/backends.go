@@ -0,0 +1,291 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"red-packet-system/db"
+	"red-packet-system/kafka"
+	"red-packet-system/model"
+	"red-packet-system/pkg/testenv"
+	"red-packet-system/service"
+)
+
+// Backends selectable with Options.Backend
+const (
+	BackendMemory = "memory" // service/memory.go implementations, no ledger
+	BackendStore  = "store"  // SQLite with the production schema and an in-process Redis running the Lua scripts
+)
+
+// backend is the storage a run grabs against
+type backend interface {
+	dependencies() (service.PacketRepository, service.StockCache, service.LockProvider)
+	events() *service.MemoryEventPublisher
+	createRedPacket(ctx context.Context, amount float64, count int, mode string) (uint, error)
+	// deliver hands the grab events to their consumers, like the Kafka worker, times times
+	deliver(ctx context.Context, times int) error
+	snapshot(ctx context.Context, redPacketID uint) (packetSnapshot, error)
+	// balances returns the wallet balance of every user in cents and the users whose cached
+	// balance differs from the ledger. ok is false when the backend has no wallets.
+	balances(ctx context.Context) (balances map[uint]int64, mismatches []string, ok bool, err error)
+	close()
+}
+
+func newBackend(name string, users int) (backend, error) {
+	switch name {
+	case BackendMemory:
+		return newMemoryBackend(), nil
+	case BackendStore:
+		return newStoreBackend(users)
+	}
+	return nil, fmt.Errorf("unknown backend %q", name)
+}
+
+// memoryBackend runs on the in-memory implementations
+type memoryBackend struct {
+	packets   *service.MemoryPacketRepository
+	stock     *service.MemoryStockCache
+	locks     *service.MemoryLockProvider
+	publisher *service.MemoryEventPublisher
+	nextID    uint
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		packets:   service.NewMemoryPacketRepository(),
+		stock:     service.NewMemoryStockCache(),
+		locks:     service.NewMemoryLockProvider(),
+		publisher: &service.MemoryEventPublisher{},
+	}
+}
+
+func (b *memoryBackend) dependencies() (service.PacketRepository, service.StockCache, service.LockProvider) {
+	return b.packets, b.stock, b.locks
+}
+
+func (b *memoryBackend) events() *service.MemoryEventPublisher { return b.publisher }
+
+func (b *memoryBackend) createRedPacket(ctx context.Context, amount float64, count int, mode string) (uint, error) {
+	b.nextID++
+	b.packets.Add(model.RedPacket{
+		ID:              b.nextID,
+		TotalAmount:     amount,
+		RemainingAmount: amount,
+		TotalCount:      count,
+		RemainingCount:  count,
+		Status:          model.RedPacketStatusActive,
+		GrabMode:        mode,
+	})
+	b.stock.AddToFilter(b.nextID)
+	return b.nextID, nil
+}
+
+// deliver persists fast mode claims the way HandleClaimedEvent does. Redelivered claims are
+// rejected by the one claim per user rule of fast mode.
+func (b *memoryBackend) deliver(ctx context.Context, times int) error {
+	for i := 0; i < times; i++ {
+		for _, event := range b.publisher.Events() {
+			b.packets.PersistClaim(event.UserID, event.RedPacketID, event.Amount)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) snapshot(ctx context.Context, redPacketID uint) (packetSnapshot, error) {
+	redPacket, err := b.packets.GetRedPacket(ctx, redPacketID)
+	if err != nil {
+		return packetSnapshot{}, err
+	}
+	s := packetSnapshot{RedPacket: *redPacket, Logs: b.packets.Logs(redPacketID)}
+	if redPacket.GrabMode == model.GrabModeFast {
+		s.Stock.Stock, s.Stock.Cents, s.Stock.Claims, s.Stock.Cached = b.stock.FastState(redPacketID)
+	} else {
+		s.Stock.Stock, s.Stock.Cached = b.stock.Stock(redPacketID)
+	}
+	return s, nil
+}
+
+func (b *memoryBackend) balances(ctx context.Context) (map[uint]int64, []string, bool, error) {
+	return nil, nil, false, nil
+}
+
+func (b *memoryBackend) close() {}
+
+// storeBackend runs on SQLite and an in-process Redis server. The Kafka handlers and
+// CreateRedPacket use the db singleton, which points at SQLite for the duration of the run.
+type storeBackend struct {
+	sqliteDB  *gorm.DB
+	redis     *miniredis.Miniredis
+	client    *redis.ClusterClient
+	publisher *service.MemoryEventPublisher
+	previous  *gorm.DB
+}
+
+func newStoreBackend(users int) (*storeBackend, error) {
+	sqliteDB, err := testenv.OpenSQLite()
+	if err != nil {
+		return nil, err
+	}
+	server, client, err := testenv.StartRedis()
+	if err != nil {
+		return nil, err
+	}
+	b := &storeBackend{sqliteDB: sqliteDB, redis: server, client: client, publisher: &service.MemoryEventPublisher{}, previous: db.DB}
+	db.DB = sqliteDB
+
+	// The existence check only looks for the filter key, see redisclient.ExistsInBloomFilter
+	if err := client.Set(context.Background(), "bloom_filter:red_packets", 1, 0).Err(); err != nil {
+		b.close()
+		return nil, err
+	}
+
+	accounts := make([]model.User, users)
+	for i := range accounts {
+		accounts[i] = model.User{ID: uint(i + 1), Username: fmt.Sprintf("stress_user_%d", i+1)}
+	}
+	if err := sqliteDB.CreateInBatches(accounts, 500).Error; err != nil {
+		b.close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *storeBackend) dependencies() (service.PacketRepository, service.StockCache, service.LockProvider) {
+	return service.NewGormPacketRepository(b.sqliteDB, &service.MemorySessionPinner{}), service.NewRedisStockCache(b.client), service.NewMemoryLockProvider()
+}
+
+func (b *storeBackend) events() *service.MemoryEventPublisher { return b.publisher }
+
+// createRedPacket funds a system red packet through the ledger
+func (b *storeBackend) createRedPacket(ctx context.Context, amount float64, count int, mode string) (uint, error) {
+	redPacket, err := service.CreateRedPacket(ctx, service.CreateRedPacketRequest{TotalAmount: amount, TotalCount: count, GrabMode: mode})
+	if err != nil {
+		return 0, err
+	}
+	return redPacket.ID, nil
+}
+
+// deliver credits strict mode grabs from the outbox and persists fast mode claims,
+// with the handlers of the Kafka worker
+func (b *storeBackend) deliver(ctx context.Context, times int) error {
+	var outboxEvents []model.OutboxEvent
+	if err := b.sqliteDB.WithContext(ctx).Order("id").Find(&outboxEvents).Error; err != nil {
+		return err
+	}
+
+	var events []*kafka.Event
+	for _, outboxEvent := range outboxEvents {
+		codec, err := kafka.CodecFor(outboxEvent.ContentType)
+		if err != nil {
+			return err
+		}
+		event, err := codec.Decode(outboxEvent.Payload)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	for _, claim := range b.publisher.Events() {
+		events = append(events, kafka.NewEvent(kafka.EventTypeRedPacketClaimed, &kafka.ClaimedPayload{
+			GrabbedPayload: kafka.GrabbedPayload{
+				UserID:      claim.UserID,
+				RedPacketID: claim.RedPacketID,
+				Amount:      claim.Amount,
+				Currency:    kafka.DefaultCurrency,
+			},
+		}))
+	}
+
+	for i := 0; i < times; i++ {
+		for _, event := range events {
+			handle := service.HandleGrabbedEvent
+			if event.Type == kafka.EventTypeRedPacketClaimed {
+				handle = service.HandleClaimedEvent
+			}
+			if err := handle(ctx, event); err != nil {
+				return fmt.Errorf("event %s: %v", event.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *storeBackend) snapshot(ctx context.Context, redPacketID uint) (packetSnapshot, error) {
+	var s packetSnapshot
+	tx := b.sqliteDB.WithContext(ctx)
+	if err := tx.First(&s.RedPacket, redPacketID).Error; err != nil {
+		return s, err
+	}
+	if err := db.Logs(tx, redPacketID).Where("red_packet_id = ?", redPacketID).Find(&s.Logs).Error; err != nil {
+		return s, err
+	}
+
+	escrow, err := service.LedgerBalance(tx, model.AccountTypeRedPacket, redPacketID)
+	if err != nil {
+		return s, err
+	}
+	s.HasLedger, s.EscrowCents = true, escrow
+
+	if s.RedPacket.GrabMode == model.GrabModeFast {
+		state, err := b.client.HGetAll(ctx, service.FastStateKey(redPacketID)).Result()
+		if err != nil {
+			return s, err
+		}
+		if len(state) > 0 {
+			s.Stock.Cached = true
+			fmt.Sscan(state["stock"], &s.Stock.Stock)
+			fmt.Sscan(state["amount"], &s.Stock.Cents)
+			claims, err := b.client.SCard(ctx, service.FastGrabbersKey(redPacketID)).Result()
+			if err != nil {
+				return s, err
+			}
+			s.Stock.Claims = int(claims)
+		}
+		return s, nil
+	}
+
+	stock, err := b.client.Get(ctx, fmt.Sprintf("red_packet_%d", redPacketID)).Int()
+	if err == redis.Nil {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	s.Stock.Cached, s.Stock.Stock = true, stock
+	return s, nil
+}
+
+func (b *storeBackend) balances(ctx context.Context) (map[uint]int64, []string, bool, error) {
+	var users []model.User
+	if err := b.sqliteDB.WithContext(ctx).Find(&users).Error; err != nil {
+		return nil, nil, false, err
+	}
+	balances := map[uint]int64{}
+	for _, user := range users {
+		if cents := service.ToCents(user.Balance); cents != 0 {
+			balances[user.ID] = cents
+		}
+	}
+
+	mismatches, err := service.VerifyBalances(ctx, false)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	var violations []string
+	for _, m := range mismatches {
+		violations = append(violations, fmt.Sprintf("user %d has a balance of %.2f, ledger says %.2f", m.UserID, m.Balance, m.LedgerBalance))
+	}
+	return balances, violations, true, nil
+}
+
+func (b *storeBackend) close() {
+	db.DB = b.previous
+	b.client.Close()
+	b.redis.Close()
+	if pool, err := b.sqliteDB.DB(); err == nil {
+		pool.Close()
+	}
+}
@@ -0,0 +1,244 @@
+// Package stress fires concurrent grabs at service.RedPacketService against local backends,
+// then checks that no red packet was oversold and that claim logs, stock cache and balances agree.
+package stress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"red-packet-system/model"
+	"red-packet-system/service"
+)
+
+// Grab modes selectable with Options.Mode, besides model.GrabModeStrict and model.GrabModeFast
+const ModeMixed = "mixed" // Red packets alternate between strict and fast
+
+// maxReportedSteps bounds the interleaving printed for a violation
+const maxReportedSteps = 200
+
+// Options configures a run
+type Options struct {
+	Backend     string  `json:"backend"`     // BackendMemory or BackendStore
+	Mode        string  `json:"mode"`        // strict, fast or mixed
+	Packets     int     `json:"packets"`     // Red packets created
+	Shares      int     `json:"shares"`      // Shares per red packet
+	Amount      float64 `json:"amount"`      // Amount per red packet
+	Users       int     `json:"users"`       // Distinct users, picked at random by every grab
+	Grabs       int     `json:"grabs"`       // Grab attempts in total
+	Concurrency int     `json:"concurrency"` // Goroutines grabbing at the same time
+	Deliveries  int     `json:"deliveries"`  // Times every grab event is handed to the worker handlers (>1 redelivers)
+	Seed        int64   `json:"seed"`        // Seed of the red packet and user picks
+}
+
+// DefaultOptions returns a run that exhausts every red packet several times over
+func DefaultOptions() Options {
+	return Options{
+		Backend:     BackendStore,
+		Mode:        ModeMixed,
+		Packets:     10,
+		Shares:      50,
+		Amount:      100,
+		Users:       1000,
+		Grabs:       5000,
+		Concurrency: 200,
+		Deliveries:  2,
+		Seed:        1,
+	}
+}
+
+// Violation is a broken invariant. RedPacketID is 0 for balance violations,
+// which have no interleaving.
+type Violation struct {
+	RedPacketID  uint   `json:"red_packet_id"`
+	Message      string `json:"message"`
+	Interleaving []Step `json:"interleaving,omitempty"`
+}
+
+// Report summarizes a run
+type Report struct {
+	Options        Options        `json:"options"`
+	Duration       time.Duration  `json:"duration_ns"`
+	Successes      int            `json:"successes"`
+	Errors         map[string]int `json:"errors"`
+	GrabsPerSecond float64        `json:"grabs_per_second"`
+	BalancesCheck  bool           `json:"balances_checked"`
+	Violations     []Violation    `json:"violations"`
+}
+
+// Run creates the red packets, fires the grabs, delivers the grab events and checks the invariants.
+// The error is about the run itself; broken invariants are reported in Report.Violations.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if err := validate(opts); err != nil {
+		return nil, err
+	}
+
+	b, err := newBackend(opts.Backend, opts.Users)
+	if err != nil {
+		return nil, err
+	}
+	defer b.close()
+
+	rec := newRecorder()
+	packets, stock, locks := b.dependencies()
+	svc := service.NewRedPacketService(
+		tracedPackets{PacketRepository: packets, rec: rec},
+		tracedStock{StockCache: stock, rec: rec},
+		tracedLocks{LockProvider: locks, rec: rec},
+		tracedEvents{EventPublisher: b.events(), rec: rec},
+	)
+
+	redPacketIDs := make([]uint, opts.Packets)
+	for i := range redPacketIDs {
+		mode := opts.Mode
+		if mode == ModeMixed {
+			mode = []string{model.GrabModeStrict, model.GrabModeFast}[i%2]
+		}
+		if redPacketIDs[i], err = b.createRedPacket(ctx, opts.Amount, opts.Shares, mode); err != nil {
+			return nil, fmt.Errorf("failed to create red packet: %v", err)
+		}
+	}
+
+	report := &Report{Options: opts, Errors: map[string]int{}}
+	var (
+		mu      sync.Mutex
+		granted = map[uint][]grab{}
+		next    atomic.Int64
+		wg      sync.WaitGroup
+	)
+
+	start := time.Now()
+	for worker := 0; worker < opts.Concurrency; worker++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for next.Add(1) <= int64(opts.Grabs) {
+				redPacketID := redPacketIDs[rng.Intn(len(redPacketIDs))]
+				userID := uint(1 + rng.Intn(opts.Users))
+
+				grabCtx := withUser(ctx, userID)
+				amount, err := svc.GrabRedPacket(grabCtx, userID, redPacketID)
+				rec.record(grabCtx, redPacketID, "Grab", outcome(fmt.Sprintf("%.2f", amount), err))
+
+				mu.Lock()
+				if err != nil {
+					report.Errors[err.Error()]++
+				} else {
+					report.Successes++
+					granted[redPacketID] = append(granted[redPacketID], grab{UserID: userID, Cents: service.ToCents(amount)})
+				}
+				mu.Unlock()
+			}
+		}(rand.New(rand.NewSource(opts.Seed + int64(worker))))
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	report.GrabsPerSecond = float64(opts.Grabs) / report.Duration.Seconds()
+
+	if err := b.deliver(ctx, opts.Deliveries); err != nil {
+		return nil, fmt.Errorf("failed to deliver grab events: %v", err)
+	}
+
+	// Red packet invariants
+	grantedByUser := map[uint]int64{}
+	for _, redPacketID := range redPacketIDs {
+		snapshot, err := b.snapshot(ctx, redPacketID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read red packet %d: %v", redPacketID, err)
+		}
+		snapshot.Grabs = granted[redPacketID]
+		for _, g := range snapshot.Grabs {
+			grantedByUser[g.UserID] += g.Cents
+		}
+
+		for _, message := range checkPacket(snapshot) {
+			report.Violations = append(report.Violations, Violation{
+				RedPacketID:  redPacketID,
+				Message:      message,
+				Interleaving: rec.interleaving(redPacketID),
+			})
+		}
+	}
+
+	// Wallet invariants
+	balances, mismatches, ok, err := b.balances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read balances: %v", err)
+	}
+	if ok {
+		report.BalancesCheck = true
+		for _, message := range append(mismatches, checkBalances(balances, grantedByUser)...) {
+			report.Violations = append(report.Violations, Violation{Message: message})
+		}
+	}
+	return report, nil
+}
+
+func validate(opts Options) error {
+	switch {
+	case opts.Mode != model.GrabModeStrict && opts.Mode != model.GrabModeFast && opts.Mode != ModeMixed:
+		return fmt.Errorf("unknown mode %q", opts.Mode)
+	case opts.Packets <= 0 || opts.Shares <= 0 || opts.Users <= 0 || opts.Grabs <= 0 || opts.Concurrency <= 0:
+		return errors.New("packets, shares, users, grabs and concurrency must be positive")
+	case service.ToCents(opts.Amount) < int64(opts.Shares):
+		return errors.New("each share must be at least 0.01")
+	case opts.Deliveries < 1:
+		return errors.New("deliveries must be at least 1")
+	}
+	return nil
+}
+
+// WriteText prints the report, with the interleaving of each violating red packet
+func (r *Report) WriteText(w io.Writer) {
+	opts := r.Options
+	fmt.Fprintf(w, "backend=%s mode=%s packets=%d shares=%d amount=%.2f users=%d grabs=%d concurrency=%d deliveries=%d seed=%d\n",
+		opts.Backend, opts.Mode, opts.Packets, opts.Shares, opts.Amount, opts.Users, opts.Grabs, opts.Concurrency, opts.Deliveries, opts.Seed)
+	fmt.Fprintf(w, "%d grabs in %s (%.0f grabs/s), %d succeeded\n", opts.Grabs, r.Duration.Round(time.Millisecond), r.GrabsPerSecond, r.Successes)
+
+	messages := make([]string, 0, len(r.Errors))
+	for message := range r.Errors {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { return r.Errors[messages[i]] > r.Errors[messages[j]] })
+	for _, message := range messages {
+		fmt.Fprintf(w, "  %6d  %s\n", r.Errors[message], message)
+	}
+	if !r.BalancesCheck {
+		fmt.Fprintf(w, "balances not checked, the %s backend has no wallets\n", opts.Backend)
+	}
+
+	if len(r.Violations) == 0 {
+		fmt.Fprintln(w, "OK: no invariant violated")
+		return
+	}
+	fmt.Fprintf(w, "FAIL: %d invariant violations\n", len(r.Violations))
+
+	printed := map[uint]bool{}
+	for _, v := range r.Violations {
+		if v.RedPacketID == 0 {
+			fmt.Fprintf(w, "\n%s\n", v.Message)
+			continue
+		}
+		fmt.Fprintf(w, "\nred packet %d: %s\n", v.RedPacketID, v.Message)
+		if printed[v.RedPacketID] {
+			fmt.Fprintln(w, "  (interleaving above)")
+			continue
+		}
+		printed[v.RedPacketID] = true
+
+		steps := v.Interleaving
+		if len(steps) > maxReportedSteps {
+			fmt.Fprintf(w, "  ... %d earlier steps omitted\n", len(steps)-maxReportedSteps)
+			steps = steps[len(steps)-maxReportedSteps:]
+		}
+		for _, step := range steps {
+			fmt.Fprintf(w, "  %s\n", step)
+		}
+	}
+}
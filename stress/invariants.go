@@ -0,0 +1,151 @@
+package stress
+
+import (
+	"fmt"
+	"sort"
+
+	"red-packet-system/model"
+	"red-packet-system/service"
+)
+
+// grab is a successful grab as seen by the user
+type grab struct {
+	UserID uint
+	Cents  int64
+}
+
+// stockState is what the stock cache holds for a red packet after a run
+type stockState struct {
+	Cached bool  // Strict mode counter or fast mode state present
+	Stock  int   // Shares left
+	Cents  int64 // Fast mode amount left
+	Claims int   // Fast mode grabbers
+}
+
+// packetSnapshot is the final state of one red packet across the backends
+type packetSnapshot struct {
+	RedPacket model.RedPacket
+	Logs      []model.RedPacketLog
+	Grabs     []grab
+	Stock     stockState
+
+	// Escrow balance from the ledger, checked when HasLedger is set
+	HasLedger   bool
+	EscrowCents int64
+}
+
+// checkPacket returns the invariants a red packet violates:
+// no more claims than shares, no more paid than funded, every successful grab stored exactly
+// once, one claim per user in fast mode, counters consistent with the claims, and the stock
+// cache in line with the database.
+func checkPacket(s packetSnapshot) []string {
+	var violations []string
+	redPacket := s.RedPacket
+	totalCents := service.ToCents(redPacket.TotalAmount)
+
+	// Claims stored in the database
+	var paid int64
+	stored := map[uint]int64{}
+	for _, logEntry := range s.Logs {
+		if _, ok := stored[logEntry.UserID]; ok && redPacket.GrabMode == model.GrabModeFast {
+			violations = append(violations, fmt.Sprintf("user %d has more than one claim log", logEntry.UserID))
+		}
+		cents := service.ToCents(logEntry.Amount)
+		stored[logEntry.UserID] += cents
+		paid += cents
+	}
+	if len(s.Logs) > redPacket.TotalCount {
+		violations = append(violations, fmt.Sprintf("oversold: %d claim logs for %d shares", len(s.Logs), redPacket.TotalCount))
+	}
+	if paid > totalCents {
+		violations = append(violations, fmt.Sprintf("overpaid: claim logs pay %s of %s", formatCents(paid), formatCents(totalCents)))
+	}
+
+	// Grabs reported as successful to users, a user may grab a strict mode packet repeatedly
+	var granted int64
+	grabbed := map[uint]int64{}
+	var grabbers []uint
+	for _, g := range s.Grabs {
+		granted += g.Cents
+		if _, ok := grabbed[g.UserID]; !ok {
+			grabbers = append(grabbers, g.UserID)
+		}
+		grabbed[g.UserID] += g.Cents
+	}
+	for _, userID := range grabbers {
+		cents, ok := stored[userID]
+		switch {
+		case !ok:
+			violations = append(violations, fmt.Sprintf("grab of user %d (%s) has no claim log", userID, formatCents(grabbed[userID])))
+		case cents != grabbed[userID]:
+			violations = append(violations, fmt.Sprintf("grab of user %d returned %s, claim log has %s", userID, formatCents(grabbed[userID]), formatCents(cents)))
+		}
+	}
+	if len(s.Grabs) > redPacket.TotalCount || granted > totalCents {
+		violations = append(violations, fmt.Sprintf("oversold: %d successful grabs paying %s for %d shares of %s",
+			len(s.Grabs), formatCents(granted), redPacket.TotalCount, formatCents(totalCents)))
+	}
+	if len(s.Logs) != len(s.Grabs) {
+		violations = append(violations, fmt.Sprintf("%d claim logs for %d successful grabs", len(s.Logs), len(s.Grabs)))
+	}
+
+	// Red packet row
+	if redPacket.RemainingCount != redPacket.TotalCount-len(s.Logs) {
+		violations = append(violations, fmt.Sprintf("remaining_count is %d, claim logs leave %d", redPacket.RemainingCount, redPacket.TotalCount-len(s.Logs)))
+	}
+	if remaining := service.ToCents(redPacket.RemainingAmount); remaining != totalCents-paid {
+		violations = append(violations, fmt.Sprintf("remaining_amount is %s, claim logs leave %s", formatCents(remaining), formatCents(totalCents-paid)))
+	}
+	if redPacket.Version != int64(len(s.Logs)) {
+		violations = append(violations, fmt.Sprintf("version is %d after %d claims", redPacket.Version, len(s.Logs)))
+	}
+
+	// Stock cache
+	if s.Stock.Cached {
+		if s.Stock.Stock != redPacket.RemainingCount {
+			violations = append(violations, fmt.Sprintf("cached stock is %d, remaining_count is %d", s.Stock.Stock, redPacket.RemainingCount))
+		}
+		if redPacket.GrabMode == model.GrabModeFast {
+			if s.Stock.Cents != service.ToCents(redPacket.RemainingAmount) {
+				violations = append(violations, fmt.Sprintf("fast state amount is %s, remaining_amount is %s",
+					formatCents(s.Stock.Cents), formatCents(service.ToCents(redPacket.RemainingAmount))))
+			}
+			if s.Stock.Claims != len(s.Logs) {
+				violations = append(violations, fmt.Sprintf("fast state has %d grabbers, %d claim logs", s.Stock.Claims, len(s.Logs)))
+			}
+		}
+	}
+
+	// Ledger escrow
+	if s.HasLedger && s.EscrowCents != totalCents-paid {
+		violations = append(violations, fmt.Sprintf("escrow balance is %s, claim logs leave %s", formatCents(s.EscrowCents), formatCents(totalCents-paid)))
+	}
+	return violations
+}
+
+// checkBalances returns the users whose balance differs from the sum of their successful grabs
+func checkBalances(balances, granted map[uint]int64) []string {
+	userIDs := make([]uint, 0, len(granted))
+	for userID := range granted {
+		userIDs = append(userIDs, userID)
+	}
+	for userID := range balances {
+		if _, ok := granted[userID]; !ok {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+	var violations []string
+	for _, userID := range userIDs {
+		if balances[userID] != granted[userID] {
+			violations = append(violations, fmt.Sprintf("user %d has a balance of %s, grabbed %s",
+				userID, formatCents(balances[userID]), formatCents(granted[userID])))
+		}
+	}
+	return violations
+}
+
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%.2f", service.FromCents(cents))
+}
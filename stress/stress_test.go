@@ -0,0 +1,120 @@
+package stress
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"red-packet-system/model"
+	"red-packet-system/pkg/logger"
+)
+
+var (
+	grabsFlag       = flag.Int("stress.grabs", 2000, "grab attempts per stress run")
+	concurrencyFlag = flag.Int("stress.concurrency", 100, "concurrent grabs per stress run")
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// Every grab logs, keep the output to the reports
+	logger.Init(logger.Options{Output: io.Discard})
+	os.Exit(m.Run())
+}
+
+func TestNoOversell(t *testing.T) {
+	for _, backend := range []string{BackendMemory, BackendStore} {
+		for _, mode := range []string{model.GrabModeStrict, model.GrabModeFast} {
+			t.Run(backend+"/"+mode, func(t *testing.T) {
+				opts := DefaultOptions()
+				opts.Backend = backend
+				opts.Mode = mode
+				opts.Grabs = *grabsFlag
+				opts.Concurrency = *concurrencyFlag
+				if testing.Short() {
+					opts.Packets, opts.Users, opts.Grabs = 4, 200, 500
+				}
+
+				report, err := Run(context.Background(), opts)
+				if err != nil {
+					t.Fatalf("stress run failed: %v", err)
+				}
+
+				var out strings.Builder
+				report.WriteText(&out)
+				if len(report.Violations) > 0 {
+					t.Fatalf("invariants violated:\n%s", out.String())
+				}
+				if want := opts.Packets * opts.Shares; report.Successes != want {
+					t.Fatalf("%d successful grabs, want every share (%d) taken:\n%s", report.Successes, want, out.String())
+				}
+				t.Log("\n" + out.String())
+			})
+		}
+	}
+}
+
+func TestCheckPacketOversold(t *testing.T) {
+	s := packetSnapshot{
+		RedPacket: model.RedPacket{
+			ID:              1,
+			TotalAmount:     10,
+			RemainingAmount: 0,
+			TotalCount:      2,
+			RemainingCount:  0,
+			GrabMode:        model.GrabModeStrict,
+			Version:         2,
+		},
+		Logs: []model.RedPacketLog{
+			{UserID: 1, RedPacketID: 1, Amount: 5},
+			{UserID: 2, RedPacketID: 1, Amount: 5},
+			{UserID: 3, RedPacketID: 1, Amount: 5},
+		},
+		Grabs: []grab{{UserID: 1, Cents: 500}, {UserID: 2, Cents: 500}, {UserID: 3, Cents: 500}},
+		Stock: stockState{Cached: true, Stock: 0},
+	}
+
+	violations := strings.Join(checkPacket(s), "\n")
+	for _, want := range []string{
+		"oversold: 3 claim logs for 2 shares",
+		"overpaid: claim logs pay 15.00 of 10.00",
+		"remaining_count is 0, claim logs leave -1",
+		"version is 2 after 3 claims",
+	} {
+		if !strings.Contains(violations, want) {
+			t.Errorf("violations do not report %q:\n%s", want, violations)
+		}
+	}
+}
+
+func TestCheckPacketLostGrab(t *testing.T) {
+	s := packetSnapshot{
+		RedPacket: model.RedPacket{ID: 1, TotalAmount: 10, RemainingAmount: 10, TotalCount: 2, RemainingCount: 2, GrabMode: model.GrabModeFast},
+		Grabs:     []grab{{UserID: 1, Cents: 500}},
+		Stock:     stockState{Cached: true, Stock: 1, Cents: 500, Claims: 1},
+	}
+
+	violations := strings.Join(checkPacket(s), "\n")
+	for _, want := range []string{
+		"grab of user 1 (5.00) has no claim log",
+		"cached stock is 1, remaining_count is 2",
+		"fast state has 1 grabbers, 0 claim logs",
+	} {
+		if !strings.Contains(violations, want) {
+			t.Errorf("violations do not report %q:\n%s", want, violations)
+		}
+	}
+}
+
+func TestCheckBalances(t *testing.T) {
+	violations := checkBalances(map[uint]int64{1: 500, 2: 300}, map[uint]int64{1: 500, 3: 200})
+	want := []string{
+		"user 2 has a balance of 3.00, grabbed 0.00",
+		"user 3 has a balance of 0.00, grabbed 2.00",
+	}
+	if strings.Join(violations, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("checkBalances = %q, want %q", violations, want)
+	}
+}
@@ -0,0 +1,170 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"red-packet-system/model"
+	"red-packet-system/service"
+)
+
+// Step is one backend operation made by a grab, in global order
+type Step struct {
+	Seq         int64  `json:"seq"`
+	UserID      uint   `json:"user_id"`
+	RedPacketID uint   `json:"red_packet_id"`
+	Op          string `json:"op"`
+	Result      string `json:"result"`
+}
+
+// String formats a step as one line of an interleaving
+func (s Step) String() string {
+	return fmt.Sprintf("#%-6d user %-5d %-14s %s", s.Seq, s.UserID, s.Op, s.Result)
+}
+
+// userKey carries the grabbing user into the traced backends
+type userKey struct{}
+
+// withUser tags ctx with the grabbing user. RedPacketService keeps context values,
+// so every backend call of the grab can be attributed.
+func withUser(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+func userFrom(ctx context.Context) uint {
+	userID, _ := ctx.Value(userKey{}).(uint)
+	return userID
+}
+
+// recorder keeps the steps of every red packet
+type recorder struct {
+	seq   atomic.Int64
+	mu    sync.Mutex
+	steps map[uint][]Step
+}
+
+func newRecorder() *recorder {
+	return &recorder{steps: map[uint][]Step{}}
+}
+
+func (r *recorder) record(ctx context.Context, redPacketID uint, op, result string) {
+	step := Step{Seq: r.seq.Add(1), UserID: userFrom(ctx), RedPacketID: redPacketID, Op: op, Result: result}
+	r.mu.Lock()
+	r.steps[redPacketID] = append(r.steps[redPacketID], step)
+	r.mu.Unlock()
+}
+
+// interleaving returns the steps of a red packet in global order
+func (r *recorder) interleaving(redPacketID uint) []Step {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Step(nil), r.steps[redPacketID]...)
+}
+
+// outcome formats the result of a backend call
+func outcome(value interface{}, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return fmt.Sprint(value)
+}
+
+// tracedPackets records PacketRepository calls
+type tracedPackets struct {
+	service.PacketRepository
+	rec *recorder
+}
+
+func (t tracedPackets) GetRedPacket(ctx context.Context, redPacketID uint) (*model.RedPacket, error) {
+	redPacket, err := t.PacketRepository.GetRedPacket(ctx, redPacketID)
+	result := outcome(nil, err)
+	if err == nil {
+		result = fmt.Sprintf("remaining=%d version=%d", redPacket.RemainingCount, redPacket.Version)
+	}
+	t.rec.record(ctx, redPacketID, "GetRedPacket", result)
+	return redPacket, err
+}
+
+func (t tracedPackets) PersistGrab(ctx context.Context, userID, redPacketID uint, share func(*model.RedPacket) float64) (float64, error) {
+	amount, err := t.PacketRepository.PersistGrab(ctx, userID, redPacketID, share)
+	t.rec.record(ctx, redPacketID, "PersistGrab", outcome(fmt.Sprintf("%.2f", amount), err))
+	return amount, err
+}
+
+// tracedStock records StockCache writes and claims
+type tracedStock struct {
+	service.StockCache
+	rec *recorder
+}
+
+func (t tracedStock) DecrStock(ctx context.Context, redPacketID uint) (int, error) {
+	stock, err := t.StockCache.DecrStock(ctx, redPacketID)
+	t.rec.record(ctx, redPacketID, "DecrStock", outcome(stock, err))
+	return stock, err
+}
+
+func (t tracedStock) SetStock(ctx context.Context, redPacketID uint, stock int, ttl time.Duration) error {
+	err := t.StockCache.SetStock(ctx, redPacketID, stock, ttl)
+	t.rec.record(ctx, redPacketID, "SetStock", outcome(stock, err))
+	return err
+}
+
+func (t tracedStock) IncrStock(ctx context.Context, redPacketID uint) error {
+	err := t.StockCache.IncrStock(ctx, redPacketID)
+	t.rec.record(ctx, redPacketID, "IncrStock", outcome("ok", err))
+	return err
+}
+
+func (t tracedStock) ClaimFast(ctx context.Context, redPacketID, userID uint) (int64, error) {
+	share, err := t.StockCache.ClaimFast(ctx, redPacketID, userID)
+	t.rec.record(ctx, redPacketID, "ClaimFast", outcome(share, err))
+	return share, err
+}
+
+func (t tracedStock) LoadFast(ctx context.Context, redPacketID uint, stock int, cents int64, grabbers []uint) error {
+	err := t.StockCache.LoadFast(ctx, redPacketID, stock, cents, grabbers)
+	t.rec.record(ctx, redPacketID, "LoadFast", outcome(fmt.Sprintf("stock=%d cents=%d grabbers=%d", stock, cents, len(grabbers)), err))
+	return err
+}
+
+func (t tracedStock) RollbackFast(ctx context.Context, redPacketID, userID uint, cents int64) error {
+	err := t.StockCache.RollbackFast(ctx, redPacketID, userID, cents)
+	t.rec.record(ctx, redPacketID, "RollbackFast", outcome(cents, err))
+	return err
+}
+
+// tracedLocks records when a grab holds the red packet lock
+type tracedLocks struct {
+	service.LockProvider
+	rec *recorder
+}
+
+func (t tracedLocks) Lock(ctx context.Context, key string) (func(), error) {
+	var redPacketID uint
+	fmt.Sscanf(key, "lock:red_packet_%d", &redPacketID)
+
+	unlock, err := t.LockProvider.Lock(ctx, key)
+	t.rec.record(ctx, redPacketID, "Lock", outcome("acquired", err))
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		t.rec.record(ctx, redPacketID, "Unlock", "released")
+		unlock()
+	}, nil
+}
+
+// tracedEvents records published claims
+type tracedEvents struct {
+	service.EventPublisher
+	rec *recorder
+}
+
+func (t tracedEvents) PublishClaimed(ctx context.Context, userID, redPacketID uint, amount float64) error {
+	err := t.EventPublisher.PublishClaimed(ctx, userID, redPacketID, amount)
+	t.rec.record(ctx, redPacketID, "PublishClaimed", outcome(fmt.Sprintf("%.2f", amount), err))
+	return err
+}